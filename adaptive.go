@@ -0,0 +1,165 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Outcome is the outcome of a call to the downstream reported to an AdaptiveLimiter.
+type Outcome int
+
+const (
+	// OutcomeSuccess increases the rate additively.
+	OutcomeSuccess Outcome = iota
+	// OutcomeThrottled decreases the rate multiplicatively, e.g. the downstream responded 429.
+	OutcomeThrottled
+	// OutcomeTimeout decreases the rate multiplicatively, e.g. the downstream is overloaded.
+	OutcomeTimeout
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeSuccess:
+		return "success"
+	case OutcomeThrottled:
+		return "throttled"
+	case OutcomeTimeout:
+		return "timeout"
+	default:
+		return fmt.Sprintf("Outcome(%d)", int(o))
+	}
+}
+
+// AdaptiveRequest is the request of an AdaptiveLimiter, the DurationPerToken of the key
+// is adjusted by additive-increase/multiplicative-decrease within [MinDurationPerToken, MaxDurationPerToken].
+type AdaptiveRequest struct {
+	Key              string
+	Burst            int
+	Tokens           int
+	MaxFutureReserve time.Duration
+
+	// MinDurationPerToken is the fastest rate, which is also the initial rate.
+	MinDurationPerToken time.Duration
+	// MaxDurationPerToken is the slowest rate.
+	MaxDurationPerToken time.Duration
+	// AdditiveIncrease is the tokens per second added to the rate on every success.
+	AdditiveIncrease float64
+	// MultiplicativeDecrease is the factor in (0, 1) the rate is multiplied by on every throttled or timeout.
+	MultiplicativeDecrease float64
+}
+
+func validateAdaptiveRequest(req *AdaptiveRequest) error {
+	if req.Key == "" || req.Burst <= 0 || req.Tokens <= 0 || req.Tokens > req.Burst ||
+		req.MinDurationPerToken < time.Microsecond || req.MaxDurationPerToken < req.MinDurationPerToken ||
+		req.AdditiveIncrease < 0 || req.MultiplicativeDecrease <= 0 || req.MultiplicativeDecrease >= 1 {
+		return errors.Wrapf(ErrInvalidParameters, "%v", req)
+	}
+	return nil
+}
+
+// reserveRequest returns the ReserveRequest of the key at the current DurationPerToken.
+func (req *AdaptiveRequest) reserveRequest(durationPerToken time.Duration) *ReserveRequest {
+	return &ReserveRequest{
+		Key:              req.Key,
+		DurationPerToken: durationPerToken,
+		Burst:            req.Burst,
+		Tokens:           req.Tokens,
+		MaxFutureReserve: req.MaxFutureReserve,
+	}
+}
+
+// adaptiveState is the GCRA timeBase of a key along with its current DurationPerToken, both in microseconds.
+type adaptiveState struct {
+	TimeBase         int64
+	DurationPerToken int64
+}
+
+func (s adaptiveState) String() string {
+	return fmt.Sprintf("%d:%d", s.TimeBase, s.DurationPerToken)
+}
+
+func parseAdaptiveState(value string) (adaptiveState, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return adaptiveState{}, errors.Errorf("ratelimiter: invalid adaptive state %q", value)
+	}
+	timeBase, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return adaptiveState{}, errors.Wrap(err, "ratelimiter: failed to parse adaptive time base")
+	}
+	durationPerToken, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return adaptiveState{}, errors.Wrap(err, "ratelimiter: failed to parse adaptive duration per token")
+	}
+	return adaptiveState{TimeBase: timeBase, DurationPerToken: durationPerToken}, nil
+}
+
+// durationPerToken returns the current DurationPerToken of the state within the bounds of the request,
+// which may have been changed since the state was saved.
+func (req *AdaptiveRequest) durationPerToken(state adaptiveState, found bool) time.Duration {
+	if !found {
+		return req.MinDurationPerToken
+	}
+	d := time.Duration(state.DurationPerToken) * time.Microsecond
+	return min(max(d, req.MinDurationPerToken), req.MaxDurationPerToken)
+}
+
+// adjustAdaptive applies the outcome to the DurationPerToken, embed/redis_adaptive_report.lua must be kept in sync.
+func adjustAdaptive(req *AdaptiveRequest, durationPerToken time.Duration, outcome Outcome) time.Duration {
+	rate := 1e6 / float64(durationPerToken.Microseconds()) // tokens per second
+	if outcome == OutcomeSuccess {
+		rate += req.AdditiveIncrease
+	} else {
+		rate *= req.MultiplicativeDecrease
+	}
+	d := time.Duration(math.Floor(1e6/rate+0.5)) * time.Microsecond
+	return min(max(d, req.MinDurationPerToken), req.MaxDurationPerToken)
+}
+
+// AdaptiveDriver is the storage of an AdaptiveLimiter, the current DurationPerToken is stored alongside the bucket,
+// so that all instances converge on the same rate.
+type AdaptiveDriver interface {
+	// ReserveAdaptive reserves the tokens at the current DurationPerToken of the key.
+	ReserveAdaptive(ctx context.Context, req *AdaptiveRequest) (*Reservation, error)
+	// ReportOutcome adjusts the DurationPerToken of the key by the outcome and returns the adjusted one.
+	ReportOutcome(ctx context.Context, req *AdaptiveRequest, outcome Outcome) (time.Duration, error)
+}
+
+// AdaptiveLimiter limits the calls to a fragile downstream at a rate adjusted by the reported outcomes,
+// the DurationPerToken of the returned reservations is the current one.
+type AdaptiveLimiter struct {
+	driver AdaptiveDriver
+}
+
+func NewAdaptiveLimiter(driver AdaptiveDriver) *AdaptiveLimiter {
+	return &AdaptiveLimiter{driver: driver}
+}
+
+func (lim *AdaptiveLimiter) Reserve(ctx context.Context, req *AdaptiveRequest) (*Reservation, error) {
+	return lim.driver.ReserveAdaptive(ctx, req)
+}
+
+func (lim *AdaptiveLimiter) Allow(ctx context.Context, req *AdaptiveRequest) (bool, error) {
+	reserveReq := *req
+	reserveReq.MaxFutureReserve = 0
+
+	r, err := lim.Reserve(ctx, &reserveReq)
+	if err != nil {
+		return false, err
+	}
+	return r.OK, nil
+}
+
+// Report reports the outcome of a call and returns the adjusted DurationPerToken of the key.
+func (lim *AdaptiveLimiter) Report(ctx context.Context, req *AdaptiveRequest, outcome Outcome) (time.Duration, error) {
+	if outcome < OutcomeSuccess || outcome > OutcomeTimeout {
+		return 0, errors.Wrapf(ErrInvalidParameters, "outcome: %v", outcome)
+	}
+	return lim.driver.ReportOutcome(ctx, req, outcome)
+}
@@ -0,0 +1,97 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testAdaptive(t *testing.T, limiter *AdaptiveLimiter, key string) {
+	now := time.Now().Truncate(time.Microsecond)
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+
+	newRequest := func(key string) *AdaptiveRequest {
+		return &AdaptiveRequest{
+			Key:                    key,
+			Burst:                  1,
+			Tokens:                 1,
+			MaxFutureReserve:       10 * time.Second,
+			MinDurationPerToken:    10 * time.Millisecond,
+			MaxDurationPerToken:    time.Second,
+			AdditiveIncrease:       10,
+			MultiplicativeDecrease: 0.5,
+		}
+	}
+	req := newRequest(key)
+
+	// starts at the fastest rate
+	r, err := limiter.Reserve(ctx, req)
+	require.NoError(t, err)
+	require.True(t, r.OK)
+	require.Equal(t, 10*time.Millisecond, r.DurationPerToken)
+	require.True(t, now.Equal(r.TimeToAct), "timeToAct: %v", r.TimeToAct.Sub(now))
+
+	// 100/s * 0.5 = 50/s
+	d, err := limiter.Report(ctx, req, OutcomeThrottled)
+	require.NoError(t, err)
+	require.Equal(t, 20*time.Millisecond, d)
+
+	r, err = limiter.Reserve(ctx, req)
+	require.NoError(t, err)
+	require.True(t, r.OK)
+	require.Equal(t, 20*time.Millisecond, r.DurationPerToken)
+	require.True(t, now.Add(20*time.Millisecond).Equal(r.TimeToAct), "timeToAct: %v", r.TimeToAct.Sub(now))
+
+	// 50/s + 10/s = 60/s
+	d, err = limiter.Report(ctx, req, OutcomeSuccess)
+	require.NoError(t, err)
+	require.Equal(t, 16667*time.Microsecond, d)
+
+	// bounded by the slowest rate
+	for range 10 {
+		d, err = limiter.Report(ctx, req, OutcomeTimeout)
+		require.NoError(t, err)
+	}
+	require.Equal(t, time.Second, d)
+
+	// 1/s + 10/s = 11/s
+	d, err = limiter.Report(ctx, req, OutcomeSuccess)
+	require.NoError(t, err)
+	require.Equal(t, 90909*time.Microsecond, d)
+
+	allowed, err := limiter.Allow(ctx, req)
+	require.NoError(t, err)
+	require.False(t, allowed)
+
+	// the rate of a new key can be reported before any reservation
+	newKeyReq := newRequest(key + ":new")
+	d, err = limiter.Report(ctx, newKeyReq, OutcomeThrottled)
+	require.NoError(t, err)
+	require.Equal(t, 20*time.Millisecond, d)
+	r, err = limiter.Reserve(ctx, newKeyReq)
+	require.NoError(t, err)
+	require.True(t, r.OK)
+	require.Equal(t, 20*time.Millisecond, r.DurationPerToken)
+	require.True(t, now.Equal(r.TimeToAct), "timeToAct: %v", r.TimeToAct.Sub(now))
+
+	invalid := newRequest(key)
+	invalid.MultiplicativeDecrease = 1
+	_, err = limiter.Reserve(ctx, invalid)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+	_, err = limiter.Report(ctx, req, Outcome(3))
+	require.ErrorIs(t, err, ErrInvalidParameters)
+}
+
+func TestAdaptive_DriverGORM(t *testing.T) {
+	testAdaptive(t, NewAdaptiveLimiter(NewGormDriver(db)), "TestAdaptive_DriverGORM")
+}
+
+func TestAdaptive_DriverRedis(t *testing.T) {
+	d, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+	testAdaptive(t, NewAdaptiveLimiter(d), "TestAdaptive_DriverRedis")
+}
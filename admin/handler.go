@@ -0,0 +1,302 @@
+// Package admin provides an http.Handler to inspect and reset the keys of ratelimiter, and to apply temporary overrides.
+// It has no authentication, so it must be mounted behind the authentication of the application.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/theplant/ratelimiter"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Handler serves the admin API:
+//
+//	GET    /keys?prefix=login:&cursor=&limit=100  lists the keys with the prefix
+//	GET    /keys/{key}?rate=5/min&burst=5         shows the status of the GCRA bucket of the key
+//	GET    /keys/{key}?policy=login               same as above with the rate and burst of the policy
+//	DELETE /keys/{key}                            resets the key
+//	GET    /overrides/{key}                       shows the override of the key
+//	PUT    /overrides/{key}                       sets the override of the key, e.g. {"action": "deny", "ttl": "1h"}
+//	DELETE /overrides/{key}                       deletes the override of the key
+//
+// The /overrides endpoints are served only if an OverrideStore is provided.
+type Handler struct {
+	driver    ratelimiter.StateDriver
+	overrides ratelimiter.OverrideStore
+	policies  *ratelimiter.PolicyRegistry
+	mux       *http.ServeMux
+}
+
+type Option func(h *Handler)
+
+// WithOverrideStore serves the /overrides endpoints by the store.
+func WithOverrideStore(store ratelimiter.OverrideStore) Option {
+	return func(h *Handler) {
+		h.overrides = store
+	}
+}
+
+// WithPolicyRegistry allows the status of a key to be shown with the rate and burst of a policy.
+func WithPolicyRegistry(registry *ratelimiter.PolicyRegistry) Option {
+	return func(h *Handler) {
+		h.policies = registry
+	}
+}
+
+// NewHandler returns the admin handler of the driver, e.g. a RedisDriver or a GormDriver,
+// mount it with http.StripPrefix if it is not at the root.
+func NewHandler(driver ratelimiter.StateDriver, opts ...Option) *Handler {
+	h := &Handler{
+		driver: driver,
+		mux:    http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	h.mux.HandleFunc("GET /keys", h.listKeys)
+	h.mux.HandleFunc("GET /keys/{key...}", h.getKey)
+	h.mux.HandleFunc("DELETE /keys/{key...}", h.resetKey)
+	if h.overrides != nil {
+		h.mux.HandleFunc("GET /overrides/{key...}", h.getOverride)
+		h.mux.HandleFunc("PUT /overrides/{key...}", h.setOverride)
+		h.mux.HandleFunc("DELETE /overrides/{key...}", h.deleteOverride)
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ratelimiter.ErrInvalidParameters), errors.Is(err, ratelimiter.ErrPolicyNotFound):
+		status = http.StatusBadRequest
+	case errors.Is(err, errNotFound):
+		status = http.StatusNotFound
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+var errNotFound = errors.New("ratelimiter: not found")
+
+func now(ctx context.Context) time.Time {
+	if ratelimiter.Test {
+		nowFunc, exists := ratelimiter.NowFuncFromContextForTest(ctx)
+		if exists {
+			return nowFunc().UTC()
+		}
+	}
+	return time.Now().UTC()
+}
+
+type listKeysResponse struct {
+	Keys []string `json:"keys"`
+	// Cursor is the cursor of the next page, it is empty after the last page.
+	Cursor string `json:"cursor"`
+}
+
+func (h *Handler) listKeys(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultLimit
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxLimit {
+			writeError(w, errors.Wrapf(ratelimiter.ErrInvalidParameters, "limit must be between 1 and %d", maxLimit))
+			return
+		}
+		limit = n
+	}
+
+	keys, cursor, err := h.driver.ScanKeys(r.Context(), query.Get("prefix"), query.Get("cursor"), limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if keys == nil {
+		keys = []string{}
+	}
+	writeJSON(w, http.StatusOK, listKeysResponse{Keys: keys, Cursor: cursor})
+}
+
+type keyResponse struct {
+	*ratelimiter.KeyState
+	// Status is set if the rate and burst or the policy are provided.
+	Status *ratelimiter.BucketStatus `json:"status,omitempty"`
+}
+
+func (h *Handler) getKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	key := r.PathValue("key")
+
+	durationPerToken, burst, err := h.bucketParams(r, key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	state, err := h.driver.GetKeyState(ctx, key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if durationPerToken == 0 {
+		if state == nil {
+			writeError(w, errors.Wrapf(errNotFound, "key %q", key))
+			return
+		}
+		writeJSON(w, http.StatusOK, keyResponse{KeyState: state})
+		return
+	}
+
+	status, err := ratelimiter.NewBucketStatus(key, state, durationPerToken, burst, now(ctx))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if state == nil {
+		state = &ratelimiter.KeyState{Key: key}
+	}
+	writeJSON(w, http.StatusOK, keyResponse{KeyState: state, Status: status})
+}
+
+// bucketParams returns the duration per token and the burst of the key by the policy, including its override
+// of the key, or the rate and burst of the query, the duration per token is 0 if neither is provided.
+// Only the policies of AlgorithmGCRA have a bucket status.
+func (h *Handler) bucketParams(r *http.Request, key string) (time.Duration, int, error) {
+	query := r.URL.Query()
+	if name := query.Get("policy"); name != "" {
+		if h.policies == nil {
+			return 0, 0, errors.Wrap(ratelimiter.ErrInvalidParameters, "no policy registry")
+		}
+		p, err := h.policies.Get(name)
+		if err != nil {
+			return 0, 0, err
+		}
+		rate, burst := p.Rate, p.Burst
+		if override, ok := p.Overrides[key]; ok {
+			if override.Rate != (ratelimiter.Rate{}) {
+				rate = override.Rate
+			}
+			if override.Burst != 0 {
+				burst = override.Burst
+			}
+		}
+		if p.Algorithm != ratelimiter.AlgorithmGCRA || rate.Tokens <= 0 || rate.Per <= 0 {
+			return 0, 0, errors.Wrapf(ratelimiter.ErrInvalidParameters, "policy %q has no bucket status, algorithm %v, rate %v", name, p.Algorithm, rate)
+		}
+		return rate.DurationPerToken(), burst, nil
+	}
+
+	if query.Get("rate") == "" {
+		return 0, 0, nil
+	}
+	rate, err := ratelimiter.ParseRate(query.Get("rate"))
+	if err != nil {
+		return 0, 0, err
+	}
+	burst := rate.Tokens
+	if s := query.Get("burst"); s != "" {
+		if burst, err = strconv.Atoi(s); err != nil {
+			return 0, 0, errors.Wrapf(ratelimiter.ErrInvalidParameters, "burst %q", s)
+		}
+	}
+	return rate.DurationPerToken(), burst, nil
+}
+
+func (h *Handler) resetKey(w http.ResponseWriter, r *http.Request) {
+	if err := h.driver.ResetKey(r.Context(), r.PathValue("key")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) getOverride(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	o, err := h.overrides.GetOverride(r.Context(), key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if o == nil {
+		writeError(w, errors.Wrapf(errNotFound, "override of key %q", key))
+		return
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+type setOverrideRequest struct {
+	Action ratelimiter.OverrideAction `json:"action"`
+	// Rate is the overridden rate of OverrideActionLimit, e.g. "100/min".
+	Rate  string `json:"rate"`
+	Burst int    `json:"burst"`
+	// TTL is the duration until the override expires, e.g. "1h", it never expires if empty.
+	TTL string `json:"ttl"`
+}
+
+func (h *Handler) setOverride(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	key := r.PathValue("key")
+
+	var req setOverrideRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, errors.Wrapf(ratelimiter.ErrInvalidParameters, "invalid override: %v", err))
+		return
+	}
+
+	o := &ratelimiter.KeyOverride{Action: req.Action, Burst: req.Burst}
+	if req.Rate != "" {
+		rate, err := ratelimiter.ParseRate(req.Rate)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		o.DurationPerToken = rate.DurationPerToken()
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil {
+			writeError(w, errors.Wrapf(ratelimiter.ErrInvalidParameters, "ttl %q", req.TTL))
+			return
+		}
+	}
+
+	if err := h.overrides.SetOverride(ctx, key, o, ttl); err != nil {
+		writeError(w, err)
+		return
+	}
+	h.getOverride(w, r)
+}
+
+func (h *Handler) deleteOverride(w http.ResponseWriter, r *http.Request) {
+	if err := h.overrides.DeleteOverride(r.Context(), r.PathValue("key")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
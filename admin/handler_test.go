@@ -0,0 +1,194 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/theplant/ratelimiter"
+)
+
+// memoryStateDriver is a StateDriver of GCRA buckets in memory.
+type memoryStateDriver struct {
+	values map[string]string
+}
+
+func (d *memoryStateDriver) ScanKeys(ctx context.Context, prefix string, cursor string, limit int) ([]string, string, error) {
+	var keys []string
+	for key := range d.values {
+		if strings.HasPrefix(key, prefix) && key > cursor {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) <= limit {
+		return keys, "", nil
+	}
+	return keys[:limit], keys[limit-1], nil
+}
+
+func (d *memoryStateDriver) GetKeyState(ctx context.Context, key string) (*ratelimiter.KeyState, error) {
+	value, ok := d.values[key]
+	if !ok {
+		return nil, nil
+	}
+	return &ratelimiter.KeyState{Key: key, Value: value}, nil
+}
+
+func (d *memoryStateDriver) ResetKey(ctx context.Context, key string) error {
+	delete(d.values, key)
+	return nil
+}
+
+func (d *memoryStateDriver) ResetKeyIf(ctx context.Context, key string, value string) (bool, error) {
+	if current, ok := d.values[key]; !ok || current != value {
+		return false, nil
+	}
+	delete(d.values, key)
+	return true, nil
+}
+
+type memoryOverrideStore struct {
+	overrides map[string]*ratelimiter.KeyOverride
+}
+
+func (s *memoryOverrideStore) GetOverride(ctx context.Context, key string) (*ratelimiter.KeyOverride, error) {
+	return s.overrides[key], nil
+}
+
+func (s *memoryOverrideStore) SetOverride(ctx context.Context, key string, o *ratelimiter.KeyOverride, ttl time.Duration) error {
+	if o.Action == ratelimiter.OverrideActionLimit && o.DurationPerToken == 0 && o.Burst == 0 {
+		return ratelimiter.ErrInvalidParameters
+	}
+	stored := *o
+	if ttl > 0 {
+		stored.ExpiresAt = time.Now().Add(ttl)
+	}
+	s.overrides[key] = &stored
+	return nil
+}
+
+func (s *memoryOverrideStore) DeleteOverride(ctx context.Context, key string) error {
+	delete(s.overrides, key)
+	return nil
+}
+
+func serve(t *testing.T, h http.Handler, method, target, body string, v any) int {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if v != nil {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), v), rec.Body.String())
+	}
+	return rec.Code
+}
+
+func TestHandler(t *testing.T) {
+	now := time.Now().Truncate(time.Microsecond)
+	driver := &memoryStateDriver{values: map[string]string{
+		"login:u1":      "0",
+		"login:u2":      "0",
+		"login:u3":      "0",
+		"login:a/b":     "0",
+		"upload:u1":     "0",
+		"login:counter": "1:2",
+	}}
+	driver.values["login:u1"] = strconv.FormatInt(now.Add(-3*time.Second).UnixMicro(), 10)
+	store := &memoryOverrideStore{overrides: map[string]*ratelimiter.KeyOverride{}}
+	registry := ratelimiter.NewPolicyRegistry()
+	require.NoError(t, registry.Register(ratelimiter.Policy{
+		Name:      "login",
+		Rate:      ratelimiter.Rate{Tokens: 1, Per: time.Second},
+		Burst:     5,
+		Overrides: map[string]ratelimiter.PolicyOverride{"login:u2": {Burst: 10}},
+	}))
+	require.NoError(t, registry.Register(ratelimiter.Policy{Name: "export", Burst: 100, Algorithm: ratelimiter.AlgorithmQuota, Period: ratelimiter.PeriodDay}))
+	h := NewHandler(driver, WithOverrideStore(store), WithPolicyRegistry(registry))
+
+	t.Run("list keys", func(t *testing.T) {
+		var keys []string
+		cursor := ""
+		for {
+			var res listKeysResponse
+			require.Equal(t, http.StatusOK, serve(t, h, http.MethodGet, "/keys?prefix=login:&limit=2&cursor="+cursor, "", &res))
+			keys = append(keys, res.Keys...)
+			if res.Cursor == "" {
+				break
+			}
+			cursor = res.Cursor
+		}
+		require.Equal(t, []string{"login:a/b", "login:counter", "login:u1", "login:u2", "login:u3"}, keys)
+
+		require.Equal(t, http.StatusBadRequest, serve(t, h, http.MethodGet, "/keys?limit=0", "", nil))
+	})
+
+	t.Run("get key", func(t *testing.T) {
+		var res keyResponse
+		require.Equal(t, http.StatusOK, serve(t, h, http.MethodGet, "/keys/login:a/b", "", &res))
+		require.Equal(t, "login:a/b", res.Key)
+		require.Nil(t, res.Status)
+
+		require.Equal(t, http.StatusNotFound, serve(t, h, http.MethodGet, "/keys/login:none", "", nil))
+
+		res = keyResponse{}
+		require.Equal(t, http.StatusOK, serve(t, h, http.MethodGet, "/keys/login:u1?policy=login", "", &res))
+		require.True(t, res.Status.Found)
+		require.LessOrEqual(t, res.Status.Available, 3)
+		require.GreaterOrEqual(t, res.Status.Available, 2)
+
+		// the keys not found are full
+		res = keyResponse{}
+		require.Equal(t, http.StatusOK, serve(t, h, http.MethodGet, "/keys/login:none?rate=10/min&burst=20", "", &res))
+		require.False(t, res.Status.Found)
+		require.Equal(t, 20, res.Status.Available)
+
+		// the override of the key in the policy applies
+		res = keyResponse{}
+		require.Equal(t, http.StatusOK, serve(t, h, http.MethodGet, "/keys/login:u2?policy=login", "", &res))
+		require.Equal(t, 10, res.Status.Available)
+
+		require.Equal(t, http.StatusBadRequest, serve(t, h, http.MethodGet, "/keys/login:u1?policy=signup", "", nil))
+		// a quota has no bucket
+		require.Equal(t, http.StatusBadRequest, serve(t, h, http.MethodGet, "/keys/export:u1?policy=export", "", nil))
+		require.Equal(t, http.StatusBadRequest, serve(t, h, http.MethodGet, "/keys/login:u1?rate=10", "", nil))
+		require.Equal(t, http.StatusBadRequest, serve(t, h, http.MethodGet, "/keys/login:counter?rate=10/min", "", nil))
+	})
+
+	t.Run("reset key", func(t *testing.T) {
+		require.Equal(t, http.StatusNoContent, serve(t, h, http.MethodDelete, "/keys/login:u2", "", nil))
+		require.NotContains(t, driver.values, "login:u2")
+	})
+
+	t.Run("overrides", func(t *testing.T) {
+		require.Equal(t, http.StatusNotFound, serve(t, h, http.MethodGet, "/overrides/login:u3", "", nil))
+
+		var o ratelimiter.KeyOverride
+		require.Equal(t, http.StatusOK, serve(t, h, http.MethodPut, "/overrides/login:u3", `{"action": "limit", "rate": "100/min", "burst": 100, "ttl": "1h"}`, &o))
+		require.Equal(t, ratelimiter.OverrideActionLimit, o.Action)
+		require.Equal(t, 600*time.Millisecond, o.DurationPerToken)
+		require.Equal(t, 100, o.Burst)
+		require.WithinDuration(t, time.Now().Add(time.Hour), o.ExpiresAt, time.Minute)
+
+		o = ratelimiter.KeyOverride{}
+		require.Equal(t, http.StatusOK, serve(t, h, http.MethodGet, "/overrides/login:u3", "", &o))
+		require.Equal(t, 100, o.Burst)
+
+		require.Equal(t, http.StatusBadRequest, serve(t, h, http.MethodPut, "/overrides/login:u3", `{"action": "block"}`, nil))
+		require.Equal(t, http.StatusBadRequest, serve(t, h, http.MethodPut, "/overrides/login:u3", `{"action": "limit"}`, nil))
+		require.Equal(t, http.StatusBadRequest, serve(t, h, http.MethodPut, "/overrides/login:u3", `{"action": "deny", "ttl": "1x"}`, nil))
+
+		require.Equal(t, http.StatusNoContent, serve(t, h, http.MethodDelete, "/overrides/login:u3", "", nil))
+		require.Empty(t, store.overrides)
+	})
+
+	t.Run("no override store", func(t *testing.T) {
+		require.Equal(t, http.StatusNotFound, serve(t, NewHandler(driver), http.MethodGet, "/overrides/login:u3", "", nil))
+	})
+}
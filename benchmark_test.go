@@ -58,3 +58,8 @@ func BenchmarkDriverGORM_Reserve(b *testing.B) {
 	limiter := New(NewGormDriver(db))
 	runBenchmarks(b, limiter)
 }
+
+func BenchmarkDriverSQL_Reserve(b *testing.B) {
+	limiter := New(newSQLDriverForTest(b))
+	runBenchmarks(b, limiter)
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/theplant/ratelimiter"
+)
+
+// parseFlags parses the flags of a command, which may be before or after its arguments.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	fs.SetOutput(io.Discard)
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, errors.Wrapf(errUsage, "%s: %v", fs.Name(), err)
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// bucketFlags are the rate and burst flags of a GCRA bucket.
+type bucketFlags struct {
+	rate  string
+	burst int
+}
+
+func (f *bucketFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.rate, "rate", "", "rate of the bucket, e.g. 5/min")
+	fs.IntVar(&f.burst, "burst", 0, "burst of the bucket, defaults to the tokens of the rate")
+}
+
+// durationPerToken returns 0 if the rate is not provided.
+func (f *bucketFlags) durationPerToken() (time.Duration, int, error) {
+	if f.rate == "" {
+		return 0, 0, nil
+	}
+	rate, err := ratelimiter.ParseRate(f.rate)
+	if err != nil {
+		return 0, 0, err
+	}
+	burst := f.burst
+	if burst == 0 {
+		burst = rate.Tokens
+	}
+	return rate.DurationPerToken(), burst, nil
+}
+
+type inspectResult struct {
+	Key    string                    `json:"key"`
+	Found  bool                      `json:"found"`
+	Value  string                    `json:"value"`
+	Status *ratelimiter.BucketStatus `json:"status,omitempty"`
+}
+
+func inspect(ctx context.Context, driver ratelimiter.StateDriver, args []string, out printer) error {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	var bucket bucketFlags
+	bucket.register(fs)
+	keys, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(keys) != 1 {
+		return errors.Wrap(errUsage, "inspect requires a key")
+	}
+	durationPerToken, burst, err := bucket.durationPerToken()
+	if err != nil {
+		return err
+	}
+
+	state, err := driver.GetKeyState(ctx, keys[0])
+	if err != nil {
+		return err
+	}
+	res := inspectResult{Key: keys[0], Found: state != nil}
+	if state != nil {
+		res.Value = state.Value
+	}
+	if durationPerToken > 0 {
+		if res.Status, err = ratelimiter.NewBucketStatus(keys[0], state, durationPerToken, burst, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	if out.json() {
+		return out.print(res)
+	}
+	rows := [][]string{{"KEY", "FOUND", "VALUE"}, {res.Key, strconv.FormatBool(res.Found), res.Value}}
+	if s := res.Status; s != nil {
+		rows[0] = append(rows[0], "TIME BASE", "AVAILABLE", "FULL IN")
+		timeBase := ""
+		if s.Found {
+			timeBase = s.TimeBase.Format(time.RFC3339Nano)
+		}
+		rows[1] = append(rows[1], timeBase, strconv.Itoa(s.Available), s.FullIn.String())
+	}
+	return out.table(rows)
+}
+
+type keyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// scanStates calls fn with the state of every key with the prefix, the keys deleted during the scan are skipped.
+func scanStates(ctx context.Context, driver ratelimiter.StateDriver, prefix string, fn func(state *ratelimiter.KeyState) error) error {
+	cursor := ""
+	for {
+		keys, next, err := driver.ScanKeys(ctx, prefix, cursor, 1000)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			state, err := driver.GetKeyState(ctx, key)
+			if err != nil {
+				return err
+			}
+			if state == nil {
+				continue
+			}
+			if err := fn(state); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+func scan(ctx context.Context, driver ratelimiter.StateDriver, args []string, out printer) error {
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	limit := fs.Int("limit", 0, "maximum number of keys, 0 means unlimited")
+	prefixes, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(prefixes) > 1 {
+		return errors.Wrap(errUsage, "scan accepts one prefix")
+	}
+	prefix := ""
+	if len(prefixes) == 1 {
+		prefix = prefixes[0]
+	}
+
+	errLimit := errors.New("limit reached")
+	results := []keyValue{}
+	err = scanStates(ctx, driver, prefix, func(state *ratelimiter.KeyState) error {
+		results = append(results, keyValue{Key: state.Key, Value: state.Value})
+		if *limit > 0 && len(results) >= *limit {
+			return errLimit
+		}
+		return nil
+	})
+	if err != nil && err != errLimit {
+		return err
+	}
+
+	if out.json() {
+		return out.print(results)
+	}
+	rows := [][]string{{"KEY", "VALUE"}}
+	for _, r := range results {
+		rows = append(rows, []string{r.Key, r.Value})
+	}
+	return out.table(rows)
+}
+
+func reset(ctx context.Context, driver ratelimiter.StateDriver, args []string, out printer) error {
+	if len(args) == 0 {
+		return errors.Wrap(errUsage, "reset requires keys")
+	}
+	for _, key := range args {
+		if err := driver.ResetKey(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	if out.json() {
+		return out.print(map[string]any{"reset": args})
+	}
+	rows := [][]string{{"RESET"}}
+	for _, key := range args {
+		rows = append(rows, []string{key})
+	}
+	return out.table(rows)
+}
+
+type simulateResult struct {
+	Offset     time.Duration `json:"offset"`
+	OK         bool          `json:"ok"`
+	Delay      time.Duration `json:"delay"`
+	RetryAfter time.Duration `json:"retryAfter"`
+}
+
+func simulate(args []string, out printer) error {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	var bucket bucketFlags
+	bucket.register(fs)
+	tokens := fs.Int("tokens", 1, "tokens of each request")
+	maxFutureReserve := fs.Duration("max-future-reserve", 0, "maximum delay of a request")
+	requests := fs.Int("requests", 10, "number of requests")
+	interval := fs.Duration("interval", 0, "interval between the requests")
+	offsetsFlag := fs.String("offsets", "", "comma separated offsets of the requests, e.g. 0,0,100ms,1s, overrides --requests and --interval")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if bucket.rate == "" {
+		return errors.Wrap(errUsage, "simulate requires --rate")
+	}
+	durationPerToken, burst, err := bucket.durationPerToken()
+	if err != nil {
+		return err
+	}
+
+	var offsets []time.Duration
+	if *offsetsFlag != "" {
+		for _, s := range strings.Split(*offsetsFlag, ",") {
+			offset, err := time.ParseDuration(strings.TrimSpace(s))
+			if err != nil {
+				return errors.Wrapf(errUsage, "invalid offset %q", s)
+			}
+			offsets = append(offsets, offset)
+		}
+	} else {
+		for i := range *requests {
+			offsets = append(offsets, time.Duration(i)*(*interval))
+		}
+	}
+
+	rs, err := ratelimiter.SimulateGCRA(&ratelimiter.ReserveRequest{
+		Key:              "simulate",
+		DurationPerToken: durationPerToken,
+		Burst:            burst,
+		Tokens:           *tokens,
+		MaxFutureReserve: *maxFutureReserve,
+	}, time.Now(), offsets)
+	if err != nil {
+		return err
+	}
+
+	results := make([]simulateResult, len(rs))
+	for i, r := range rs {
+		results[i] = simulateResult{Offset: offsets[i], OK: r.OK}
+		if r.OK {
+			results[i].Delay = r.DelayFrom(r.Now)
+		} else {
+			results[i].RetryAfter = r.RetryAfterFrom(r.Now)
+		}
+	}
+
+	if out.json() {
+		return out.print(results)
+	}
+	rows := [][]string{{"#", "OFFSET", "OK", "DELAY", "RETRY AFTER"}}
+	for i, r := range results {
+		rows = append(rows, []string{strconv.Itoa(i + 1), r.Offset.String(), strconv.FormatBool(r.OK), r.Delay.String(), r.RetryAfter.String()})
+	}
+	return out.table(rows)
+}
+
+// compacter is implemented by GormDriver, whose logs, queues and leases are not expired by the storage itself.
+type compacter interface {
+	Compact(ctx context.Context, prefix string) (int, error)
+	CompactLeases(ctx context.Context, prefix string) (int, error)
+}
+
+// minTimeBase is the earliest plausible timeBase of a GCRA key, the other values that parse as an integer,
+// e.g. the counters of other algorithms or timestamps of another unit, are not GCRA keys.
+var minTimeBase = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).UnixMicro()
+
+// gc deletes the GCRA keys whose timeBase is older than the idle duration, since a bucket is full once its timeBase
+// is older than its burst duration, and a full bucket is the same as a missing key. The idle duration must not be
+// shorter than the longest burst duration of the keys, so it is either provided or derived from the rate and burst
+// of the keys. The other keys, e.g. of the sliding windows, are kept, and so are the keys reserved after they are
+// read. If the driver is a compacter, the expired logs, queues and leases are compacted as well.
+func gc(ctx context.Context, driver ratelimiter.StateDriver, args []string, out printer) error {
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "prefix of the keys")
+	idle := fs.Duration("idle", 0, "minimum duration since the timeBase of the deleted keys, defaults to the burst duration of --rate and --burst")
+	var bucket bucketFlags
+	bucket.register(fs)
+	dryRun := fs.Bool("dry-run", false, "list the keys without deleting them")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *prefix == "" {
+		return errors.Wrap(errUsage, "gc requires --prefix")
+	}
+	durationPerToken, burst, err := bucket.durationPerToken()
+	if err != nil {
+		return err
+	}
+	burstDuration := durationPerToken * time.Duration(burst)
+	switch {
+	case *idle < 0:
+		return errors.Wrap(errUsage, "--idle must be positive")
+	case *idle == 0 && burstDuration == 0:
+		return errors.Wrap(errUsage, "gc requires --idle or --rate")
+	case *idle == 0:
+		*idle = burstDuration
+	case *idle < burstDuration:
+		return errors.Wrapf(errUsage, "--idle %v is shorter than the burst duration %v", *idle, burstDuration)
+	}
+
+	threshold := time.Now().Add(-*idle).UnixMicro()
+	deleted := []keyValue{}
+	err = scanStates(ctx, driver, *prefix, func(state *ratelimiter.KeyState) error {
+		timeBase, err := strconv.ParseInt(state.Value, 10, 64)
+		if err != nil || timeBase < minTimeBase || timeBase >= threshold {
+			return nil
+		}
+		if !*dryRun {
+			// the key is kept if it is reserved after it is read
+			ok, err := driver.ResetKeyIf(ctx, state.Key, state.Value)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+		}
+		deleted = append(deleted, keyValue{Key: state.Key, Value: state.Value})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	compacted := 0
+	c, compacting := driver.(compacter)
+	compacting = compacting && !*dryRun
+	if compacting {
+		n, err := c.Compact(ctx, *prefix)
+		if err != nil {
+			return err
+		}
+		compacted += n
+		n, err = c.CompactLeases(ctx, *prefix)
+		if err != nil {
+			return err
+		}
+		compacted += n
+	}
+
+	if out.json() {
+		return out.print(map[string]any{"deleted": deleted, "compacted": compacted, "dryRun": *dryRun})
+	}
+	rows := [][]string{{"DELETED", "TIME BASE"}}
+	for _, kv := range deleted {
+		rows = append(rows, []string{kv.Key, kv.Value})
+	}
+	if err := out.table(rows); err != nil {
+		return err
+	}
+	if !compacting {
+		return nil
+	}
+	return out.table([][]string{{"COMPACTED"}, {strconv.Itoa(compacted)}})
+}
+
+// export writes the JSON lines of the buckets to stdout whatever the output format is, so they can be piped to import.
+func export(ctx context.Context, driver ratelimiter.StateDriver, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "prefix of the keys")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	_, err := ratelimiter.ExportBuckets(ctx, driver, *prefix, stdout)
+	return err
+}
+
+func importBuckets(ctx context.Context, driver ratelimiter.ImportDriver, stdin io.Reader, out printer) error {
+	stats, err := ratelimiter.ImportBuckets(ctx, driver, stdin)
+	if err != nil {
+		return err
+	}
+	return printStats(stats, out)
+}
+
+func migrate(ctx context.Context, from ratelimiter.StateDriver, args []string, out printer) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "prefix of the keys")
+	toRedis := fs.String("to-redis", "", "redis URL of the target")
+	toDSN := fs.String("to-dsn", "", "PostgreSQL DSN of the target")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	to, closeTo, err := connect(ctx, *toRedis, *toDSN)
+	if err != nil {
+		return err
+	}
+	defer closeTo()
+
+	stats, err := ratelimiter.MigrateBuckets(ctx, from, to, *prefix)
+	if err != nil {
+		return err
+	}
+	return printStats(stats, out)
+}
+
+func printStats(stats ratelimiter.MigrationStats, out printer) error {
+	if out.json() {
+		return out.print(stats)
+	}
+	return out.table([][]string{{"BUCKETS", "SKIPPED"}, {strconv.Itoa(stats.Buckets), strconv.Itoa(stats.Skipped)}})
+}
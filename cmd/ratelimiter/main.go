@@ -0,0 +1,141 @@
+// Command ratelimiter inspects and operates the state of ratelimiter in Redis or PostgreSQL.
+// The DSN is of the GormDriver on PostgreSQL, the other databases of SQLDriver are not supported.
+//
+//	ratelimiter --redis redis://localhost:6379/0 scan login:
+//	ratelimiter --dsn postgres://localhost/app inspect login:user1 --rate 5/min --burst 5
+//	ratelimiter simulate --rate 5/s --burst 10 --requests 20 --interval 100ms
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"github.com/theplant/ratelimiter"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const usage = `usage: ratelimiter [--redis URL | --dsn POSTGRES_DSN] [--output table|json] <command> [flags] [args]
+
+commands:
+  inspect <key> [--rate 5/min --burst 5]   show the state of a key and the status of its GCRA bucket
+  scan <prefix> [--limit N]                list the keys with the prefix
+  reset <key>...                           reset the keys
+  simulate --rate 5/s --burst 10           simulate a sequence of requests against a GCRA bucket in memory
+  gc --prefix P --idle 24h [--dry-run]     delete the GCRA keys that have been full for the idle duration,
+     [--rate 5/min --burst 5]              which defaults to the burst duration of the rate and burst
+  export [--prefix P]                      write the GCRA buckets to stdout as JSON lines
+  import                                   import the GCRA buckets of the JSON lines of stdin
+  migrate --to-redis URL | --to-dsn DSN    copy the GCRA buckets to another storage
+          [--prefix P]
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if errors.Is(err, errUsage) {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+var errUsage = errors.New("invalid usage")
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("ratelimiter", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	redisURL := fs.String("redis", os.Getenv("RATELIMITER_REDIS_URL"), "redis URL, e.g. redis://localhost:6379/0")
+	dsn := fs.String("dsn", os.Getenv("RATELIMITER_DSN"), "PostgreSQL DSN of the GormDriver")
+	output := fs.String("output", "table", "output format, table or json")
+	if err := fs.Parse(args); err != nil {
+		return errors.Wrap(errUsage, err.Error())
+	}
+	if fs.NArg() == 0 {
+		return errors.Wrap(errUsage, "missing command")
+	}
+
+	out, err := newPrinter(*output, stdout)
+	if err != nil {
+		return err
+	}
+
+	command, commandArgs := fs.Arg(0), fs.Args()[1:]
+	if command == "simulate" {
+		return simulate(commandArgs, out)
+	}
+
+	driver, closeDriver, err := connect(ctx, *redisURL, *dsn)
+	if err != nil {
+		return err
+	}
+	defer closeDriver()
+
+	switch command {
+	case "inspect":
+		return inspect(ctx, driver, commandArgs, out)
+	case "scan":
+		return scan(ctx, driver, commandArgs, out)
+	case "reset":
+		return reset(ctx, driver, commandArgs, out)
+	case "gc":
+		return gc(ctx, driver, commandArgs, out)
+	case "export":
+		return export(ctx, driver, commandArgs, stdout)
+	case "import":
+		return importBuckets(ctx, driver, stdin, out)
+	case "migrate":
+		return migrate(ctx, driver, commandArgs, out)
+	default:
+		return errors.Wrapf(errUsage, "unknown command %q", command)
+	}
+}
+
+// driver is implemented by both RedisDriver and GormDriver.
+type driver interface {
+	ratelimiter.StateDriver
+	ratelimiter.ImportDriver
+}
+
+// connect returns the driver of the redis URL or the PostgreSQL DSN, exactly one of them must be provided.
+func connect(ctx context.Context, redisURL, dsn string) (driver, func(), error) {
+	switch {
+	case redisURL != "" && dsn != "":
+		return nil, nil, errors.Wrap(errUsage, "only one of --redis and --dsn can be provided")
+	case redisURL != "":
+		opts, err := redis.ParseURL(redisURL)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid redis URL")
+		}
+		client := redis.NewClient(opts)
+		d, err := ratelimiter.InitRedisDriver(ctx, client)
+		if err != nil {
+			client.Close()
+			return nil, nil, err
+		}
+		return d, func() { client.Close() }, nil
+	case dsn != "":
+		db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard})
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to connect database")
+		}
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to connect database")
+		}
+		return ratelimiter.NewGormDriver(db), func() { sqlDB.Close() }, nil
+	default:
+		return nil, nil, errors.Wrap(errUsage, "--redis or --dsn is required")
+	}
+}
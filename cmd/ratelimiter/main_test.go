@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/theplant/ratelimiter"
+)
+
+type memoryStateDriver struct {
+	values map[string]string
+}
+
+func (d *memoryStateDriver) ScanKeys(ctx context.Context, prefix string, cursor string, limit int) ([]string, string, error) {
+	var keys []string
+	for key := range d.values {
+		if strings.HasPrefix(key, prefix) && key > cursor {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) <= limit {
+		return keys, "", nil
+	}
+	return keys[:limit], keys[limit-1], nil
+}
+
+func (d *memoryStateDriver) GetKeyState(ctx context.Context, key string) (*ratelimiter.KeyState, error) {
+	value, ok := d.values[key]
+	if !ok {
+		return nil, nil
+	}
+	return &ratelimiter.KeyState{Key: key, Value: value}, nil
+}
+
+func (d *memoryStateDriver) ResetKey(ctx context.Context, key string) error {
+	delete(d.values, key)
+	return nil
+}
+
+func (d *memoryStateDriver) ResetKeyIf(ctx context.Context, key string, value string) (bool, error) {
+	if current, ok := d.values[key]; !ok || current != value {
+		return false, nil
+	}
+	delete(d.values, key)
+	return true, nil
+}
+
+// compactingStateDriver records the prefixes it is compacted with, like a GormDriver.
+type compactingStateDriver struct {
+	*memoryStateDriver
+	prefixes []string
+}
+
+func (d *compactingStateDriver) Compact(ctx context.Context, prefix string) (int, error) {
+	d.prefixes = append(d.prefixes, prefix)
+	return 2, nil
+}
+
+func (d *compactingStateDriver) CompactLeases(ctx context.Context, prefix string) (int, error) {
+	d.prefixes = append(d.prefixes, prefix)
+	return 1, nil
+}
+
+// racingStateDriver reserves the key right after it is read, like a reservation racing a garbage collection.
+type racingStateDriver struct {
+	*memoryStateDriver
+	key   string
+	value string
+}
+
+func (d *racingStateDriver) GetKeyState(ctx context.Context, key string) (*ratelimiter.KeyState, error) {
+	state, err := d.memoryStateDriver.GetKeyState(ctx, key)
+	if key == d.key {
+		d.values[key] = d.value
+	}
+	return state, err
+}
+
+func TestSimulate(t *testing.T) {
+	var buf bytes.Buffer
+	err := run(context.Background(), []string{"--output", "json", "simulate", "--rate", "1/s", "--burst", "2", "--offsets", "0,0,0,1s"}, nil, &buf)
+	require.NoError(t, err)
+
+	var results []simulateResult
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &results))
+	require.Equal(t, []simulateResult{
+		{Offset: 0, OK: true},
+		{Offset: 0, OK: true},
+		{Offset: 0, OK: false, RetryAfter: time.Second},
+		{Offset: time.Second, OK: true},
+	}, results)
+
+	buf.Reset()
+	err = run(context.Background(), []string{"simulate", "--rate", "10/s", "--requests", "2", "--interval", "50ms", "--max-future-reserve", "1s"}, nil, &buf)
+	require.NoError(t, err)
+	require.Equal(t, `#  OFFSET  OK    DELAY  RETRY AFTER
+1  0s      true  0s     0s
+2  50ms    true  0s     0s
+`, buf.String())
+
+	for _, args := range [][]string{
+		{},
+		{"--output", "yaml", "simulate", "--rate", "1/s"},
+		{"simulate"},
+		{"simulate", "--rate", "1/s", "--offsets", "0,x"},
+		{"unknown"},
+		{"scan"},
+		{"--redis", "redis://localhost", "--dsn", "postgres://localhost", "scan"},
+	} {
+		err := run(context.Background(), args, nil, &buf)
+		require.ErrorIs(t, err, errUsage, args)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	now := time.Now()
+	driver := &memoryStateDriver{values: map[string]string{
+		"login:active":  strconv.FormatInt(now.Add(time.Second).UnixMicro(), 10),
+		"login:idle":    strconv.FormatInt(now.Add(-48*time.Hour).UnixMicro(), 10),
+		"login:counter": "1:2",
+		"upload:idle":   strconv.FormatInt(now.Add(-48*time.Hour).UnixMicro(), 10),
+	}}
+	ctx := context.Background()
+	var buf bytes.Buffer
+	jsonOut := printer{format: "json", w: &buf}
+
+	t.Run("inspect", func(t *testing.T) {
+		buf.Reset()
+		require.NoError(t, inspect(ctx, driver, []string{"login:active", "--rate", "1/s", "--burst", "5"}, jsonOut))
+		var res inspectResult
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &res))
+		require.True(t, res.Found)
+		require.Equal(t, 0, res.Status.Available)
+
+		buf.Reset()
+		require.NoError(t, inspect(ctx, driver, []string{"login:none"}, printer{format: "table", w: &buf}))
+		require.Equal(t, "KEY         FOUND  VALUE\nlogin:none  false  \n", buf.String())
+	})
+
+	t.Run("scan", func(t *testing.T) {
+		buf.Reset()
+		require.NoError(t, scan(ctx, driver, []string{"login:"}, jsonOut))
+		var res []keyValue
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &res))
+		require.Len(t, res, 3)
+
+		buf.Reset()
+		require.NoError(t, scan(ctx, driver, []string{"--limit", "1", "login:"}, jsonOut))
+		res = nil
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &res))
+		require.Equal(t, []keyValue{{Key: "login:active", Value: driver.values["login:active"]}}, res)
+	})
+
+	t.Run("export", func(t *testing.T) {
+		buf.Reset()
+		require.NoError(t, export(ctx, driver, []string{"--prefix", "upload:"}, &buf))
+		require.Equal(t, `{"key":"upload:idle","timeBase":`+driver.values["upload:idle"]+"}\n", buf.String())
+	})
+
+	t.Run("gc", func(t *testing.T) {
+		buf.Reset()
+		require.ErrorIs(t, gc(ctx, driver, []string{"--idle", "24h"}, jsonOut), errUsage)
+		require.ErrorIs(t, gc(ctx, driver, []string{"--prefix", "login:"}, jsonOut), errUsage)
+		require.ErrorIs(t, gc(ctx, driver, []string{"--prefix", "login:", "--idle", "1h", "--rate", "1/h", "--burst", "2"}, jsonOut), errUsage)
+
+		// the idle duration defaults to the burst duration, 72h is not passed yet
+		buf.Reset()
+		require.NoError(t, gc(ctx, driver, []string{"--prefix", "login:", "--rate", "1/h", "--burst", "72"}, jsonOut))
+		require.JSONEq(t, `{"deleted": [], "compacted": 0, "dryRun": false}`, buf.String())
+
+		buf.Reset()
+		require.NoError(t, gc(ctx, driver, []string{"--prefix", "login:", "--idle", "24h", "--dry-run"}, jsonOut))
+		require.Contains(t, driver.values, "login:idle")
+
+		buf.Reset()
+		require.NoError(t, gc(ctx, driver, []string{"--prefix", "login:", "--rate", "1/h", "--burst", "24"}, jsonOut))
+		var res struct {
+			Deleted []keyValue `json:"deleted"`
+		}
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &res))
+		require.Len(t, res.Deleted, 1)
+		require.Equal(t, "login:idle", res.Deleted[0].Key)
+		require.NotContains(t, driver.values, "login:idle")
+		// the other prefixes and the keys of other algorithms are kept
+		require.Contains(t, driver.values, "upload:idle")
+		require.Contains(t, driver.values, "login:counter")
+	})
+
+	t.Run("gc keeps the values that are not plausible timeBases", func(t *testing.T) {
+		millis := &memoryStateDriver{values: map[string]string{"millis:idle": strconv.FormatInt(time.Now().Add(-48*time.Hour).UnixMilli(), 10)}}
+
+		buf.Reset()
+		require.NoError(t, gc(ctx, millis, []string{"--prefix", "millis:", "--idle", "24h"}, jsonOut))
+		require.Contains(t, millis.values, "millis:idle")
+	})
+
+	t.Run("gc keeps the keys reserved after they are read", func(t *testing.T) {
+		idle := strconv.FormatInt(time.Now().Add(-48*time.Hour).UnixMicro(), 10)
+		racing := &racingStateDriver{
+			memoryStateDriver: &memoryStateDriver{values: map[string]string{"race:idle": idle, "race:reserved": idle}},
+			key:               "race:reserved",
+			value:             strconv.FormatInt(time.Now().UnixMicro(), 10),
+		}
+
+		buf.Reset()
+		require.NoError(t, gc(ctx, racing, []string{"--prefix", "race:", "--idle", "24h"}, jsonOut))
+		var res struct {
+			Deleted []keyValue `json:"deleted"`
+		}
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &res))
+		require.Equal(t, []keyValue{{Key: "race:idle", Value: idle}}, res.Deleted)
+		require.Equal(t, map[string]string{"race:reserved": racing.value}, racing.values)
+	})
+
+	t.Run("gc compacts the logs, queues and leases", func(t *testing.T) {
+		compacting := &compactingStateDriver{memoryStateDriver: &memoryStateDriver{values: map[string]string{}}}
+
+		buf.Reset()
+		require.NoError(t, gc(ctx, compacting, []string{"--prefix", "login:", "--idle", "24h", "--dry-run"}, jsonOut))
+		require.Empty(t, compacting.prefixes)
+
+		buf.Reset()
+		require.NoError(t, gc(ctx, compacting, []string{"--prefix", "login:", "--idle", "24h"}, jsonOut))
+		var res struct {
+			Compacted int `json:"compacted"`
+		}
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &res))
+		require.Equal(t, 3, res.Compacted)
+		require.Equal(t, []string{"login:", "login:"}, compacting.prefixes)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		buf.Reset()
+		require.NoError(t, reset(ctx, driver, []string{"login:active", "upload:idle"}, jsonOut))
+		require.Equal(t, map[string]string{"login:counter": "1:2"}, driver.values)
+		require.ErrorIs(t, reset(ctx, driver, nil, jsonOut), errUsage)
+	})
+}
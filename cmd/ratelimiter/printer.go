@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+)
+
+// printer prints the results of a command as JSON or as a table.
+type printer struct {
+	format string
+	w      io.Writer
+}
+
+func newPrinter(format string, w io.Writer) (printer, error) {
+	if format != "table" && format != "json" {
+		return printer{}, errors.Wrapf(errUsage, "unknown output %q", format)
+	}
+	return printer{format: format, w: w}, nil
+}
+
+func (p printer) json() bool {
+	return p.format == "json"
+}
+
+func (p printer) print(v any) error {
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// table prints the rows, the first of which is the header.
+func (p printer) table(rows [][]string) error {
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,202 @@
+package ratelimiter
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucketName = []byte("ratelimiter")
+
+// boltCompactionBatchSize is the number of keys scanned in a transaction of `Compact`.
+var boltCompactionBatchSize = 1000
+
+// BoltDriver is a Driver that uses an embedded bbolt file as the storage,
+// which is suitable for single-node services that need to keep limits across restarts.
+//
+// Each key stores its timeBase and the time it expires at, which is the time the bucket is full again.
+// Expired keys behave the same as missing keys, so they can be removed by `Compact` at any time.
+type BoltDriver struct {
+	db *bolt.DB
+}
+
+// InitBoltDriver initializes a BoltDriver with the provided bbolt DB and creates the bucket if not exists.
+func InitBoltDriver(db *bolt.DB) (*BoltDriver, error) {
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucketName)
+		return err
+	}); err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to create bolt bucket")
+	}
+
+	return &BoltDriver{db: db}, nil
+}
+
+func encodeBoltValue(timeBase, expireAt time.Time) []byte {
+	v := make([]byte, 16)
+	binary.BigEndian.PutUint64(v[:8], uint64(timeBase.UnixMicro()))
+	binary.BigEndian.PutUint64(v[8:], uint64(expireAt.UnixMicro()))
+	return v
+}
+
+func decodeBoltValue(v []byte) (timeBase, expireAt time.Time, err error) {
+	if len(v) != 16 {
+		return time.Time{}, time.Time{}, errors.Errorf("ratelimiter: unexpected bolt value length %d", len(v))
+	}
+	timeBase = time.UnixMicro(int64(binary.BigEndian.Uint64(v[:8])))
+	expireAt = time.UnixMicro(int64(binary.BigEndian.Uint64(v[8:])))
+	return timeBase, expireAt, nil
+}
+
+func (d *BoltDriver) now(ctx context.Context) time.Time {
+	if Test {
+		nowFunc, exists := NowFuncFromContextForTest(ctx)
+		if exists {
+			return nowFunc().UTC() // stripMono
+		}
+	}
+	return time.Now().UTC() // stripMono
+}
+
+func (d *BoltDriver) Reserve(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
+	if err := validateReserveRequest(req, AlgorithmGCRA); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "ratelimiter: context done")
+	default:
+	}
+
+	now := d.now(ctx)
+	key := []byte(req.Key)
+
+	var timeToAct time.Time
+	var ok bool
+
+	// Batch coalesces concurrent reservations into a single write transaction,
+	// the function may be called more than once so it must not keep state across calls.
+	err := d.db.Batch(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucketName)
+		if b == nil {
+			return errors.New("ratelimiter: bolt bucket not found")
+		}
+
+		var timeBase time.Time
+		v := b.Get(key)
+		found := v != nil
+		if found {
+			var err error
+			timeBase, _, err = decodeBoltValue(v)
+			if err != nil {
+				return err
+			}
+		}
+
+		timeToAct, ok = reserveGCRA(req, now, timeBase, found)
+		if !ok {
+			return nil
+		}
+
+		expireAt := timeToAct.Add(time.Duration(req.Burst) * req.DurationPerToken)
+		if err := b.Put(key, encodeBoltValue(timeToAct, expireAt)); err != nil {
+			return errors.Wrap(err, "ratelimiter: failed to save time to act")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reservation{
+		ReserveRequest: req,
+		OK:             ok,
+		TimeToAct:      timeToAct,
+		Now:            now,
+	}, nil
+}
+
+// Compact removes the expired keys and returns the number of removed keys.
+// Keys are scanned and removed in small transactions, each resuming after the last scanned key,
+// so that reservations are not blocked for long.
+func (d *BoltDriver) Compact(ctx context.Context) (int, error) {
+	now := d.now(ctx)
+
+	deleted := 0
+	var start []byte
+	for {
+		select {
+		case <-ctx.Done():
+			return deleted, errors.Wrap(ctx.Err(), "ratelimiter: context done")
+		default:
+		}
+
+		var expired [][]byte
+		var next []byte
+		err := d.db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket(boltBucketName)
+			if b == nil {
+				return errors.New("ratelimiter: bolt bucket not found")
+			}
+
+			expired, next = expired[:0], nil
+			c := b.Cursor()
+			k, v := c.First()
+			if start != nil {
+				k, v = c.Seek(start)
+			}
+			for scanned := 0; k != nil; k, v = c.Next() {
+				if scanned >= boltCompactionBatchSize {
+					next = append([]byte(nil), k...)
+					break
+				}
+				scanned++
+
+				_, expireAt, err := decodeBoltValue(v)
+				if err != nil {
+					return err
+				}
+				if !expireAt.After(now) {
+					expired = append(expired, append([]byte(nil), k...))
+				}
+			}
+
+			for _, k := range expired {
+				if err := b.Delete(k); err != nil {
+					return errors.Wrap(err, "ratelimiter: failed to delete expired key")
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return deleted, err
+		}
+
+		deleted += len(expired)
+		if next == nil {
+			return deleted, nil
+		}
+		start = next
+	}
+}
+
+// RunCompaction calls `Compact` every interval until the context is done.
+func (d *BoltDriver) RunCompaction(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if _, err := d.Compact(ctx); err != nil && ctx.Err() == nil {
+				return err
+			}
+		}
+	}
+}
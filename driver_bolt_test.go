@@ -0,0 +1,118 @@
+package ratelimiter
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newBoltDriverForTest(t *testing.T) *BoltDriver {
+	// the shared tests expect the reservations to take no time, so neither sync nor wait for a batch
+	boltDB, err := bolt.Open(filepath.Join(t.TempDir(), "ratelimiter.db"), 0o600, &bolt.Options{NoSync: true})
+	require.NoError(t, err)
+	boltDB.MaxBatchDelay = 0
+	t.Cleanup(func() {
+		boltDB.Close()
+	})
+
+	d, err := InitBoltDriver(boltDB)
+	require.NoError(t, err)
+	return d
+}
+
+func TestReverseWithNowAdvanced_DriverBolt(t *testing.T) {
+	testReverseWithNowAdvanced(t, New(newBoltDriverForTest(t)), "TestReverseWithNowAdvanced_DriverBolt")
+}
+
+func TestAllowWithNowAdvanced_DriverBolt(t *testing.T) {
+	testAllowWithNowAdvanced(t, New(newBoltDriverForTest(t)), "TestAllowWithNowAdvanced_DriverBolt")
+}
+
+func TestReverse_DriverBolt(t *testing.T) {
+	testReverse(t, New(newBoltDriverForTest(t)), "TestReverse_DriverBolt")
+}
+
+func TestBoltCompact(t *testing.T) {
+	d := newBoltDriverForTest(t)
+	limiter := New(d)
+
+	now := time.Now()
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+
+	for _, key := range []string{"a", "b", "c"} {
+		r, err := limiter.Reserve(ctx, &ReserveRequest{
+			Key:              key,
+			DurationPerToken: time.Second,
+			Burst:            10,
+			Tokens:           1,
+		})
+		require.NoError(t, err)
+		require.True(t, r.OK)
+	}
+	r, err := limiter.Reserve(ctx, &ReserveRequest{
+		Key:              "long",
+		DurationPerToken: time.Minute,
+		Burst:            10,
+		Tokens:           1,
+	})
+	require.NoError(t, err)
+	require.True(t, r.OK)
+
+	// nothing is full again yet
+	deleted, err := d.Compact(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, deleted)
+
+	// the short buckets are full again after a token is refilled
+	deleted, err = d.Compact(WithNowFuncForTest(context.Background(), func() time.Time {
+		return now.Add(time.Second)
+	}))
+	require.NoError(t, err)
+	require.Equal(t, 3, deleted)
+
+	deleted, err = d.Compact(WithNowFuncForTest(context.Background(), func() time.Time {
+		return now.Add(time.Minute)
+	}))
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+}
+
+func TestBoltCompact_Batches(t *testing.T) {
+	d := newBoltDriverForTest(t)
+	limiter := New(d)
+
+	batchSize := boltCompactionBatchSize
+	boltCompactionBatchSize = 2
+	t.Cleanup(func() {
+		boltCompactionBatchSize = batchSize
+	})
+
+	now := time.Now()
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		r, err := limiter.Reserve(ctx, &ReserveRequest{
+			Key:              key,
+			DurationPerToken: time.Second,
+			Burst:            10,
+			Tokens:           1,
+		})
+		require.NoError(t, err)
+		require.True(t, r.OK)
+	}
+
+	// every batch scans two keys and the next one resumes after them
+	deleted, err := d.Compact(WithNowFuncForTest(context.Background(), func() time.Time {
+		return now.Add(time.Second)
+	}))
+	require.NoError(t, err)
+	require.Equal(t, 5, deleted)
+}
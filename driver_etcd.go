@@ -0,0 +1,164 @@
+package ratelimiter
+
+import (
+	"context"
+	"math/bits"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const etcdMaxRetries = 16
+
+// EtcdDriver is a Driver that uses etcd as the storage.
+// Reservations are saved by compare-and-swap transactions on the mod revision of the key,
+// and each key is attached to a lease so that it expires once the bucket is full again.
+//
+// The leases are shared by the keys whose buckets refill in the same power of two of seconds,
+// so a lease is granted at most once per that period instead of once per reservation.
+// A key may live up to twice as long as its bucket takes to refill.
+//
+// etcd has no server time, so the local time is used and the clocks of all instances should be synchronized.
+type EtcdDriver struct {
+	client *clientv3.Client
+
+	mu     sync.Mutex
+	leases map[int]*etcdLease
+}
+
+// etcdLease is a lease shared by the keys of a TTL class, it is granted for twice the TTL of the class
+// so it outlives every key written in the first half of its life.
+type etcdLease struct {
+	id      clientv3.LeaseID
+	renewAt time.Time
+}
+
+// NewEtcdDriver returns a Driver that uses etcd as the storage.
+func NewEtcdDriver(client *clientv3.Client) *EtcdDriver {
+	return &EtcdDriver{
+		client: client,
+		leases: map[int]*etcdLease{},
+	}
+}
+
+// lease returns a shared lease that outlives ttl from now.
+func (d *EtcdDriver) lease(ctx context.Context, ttl time.Duration) (clientv3.LeaseID, error) {
+	// the TTL class is the power of two of seconds just above ttl
+	class := bits.Len64(uint64((ttl + time.Second - 1) / time.Second))
+	classTTL := int64(1) << class
+
+	d.mu.Lock()
+	l := d.leases[class]
+	d.mu.Unlock()
+	if l != nil && time.Now().Before(l.renewAt) {
+		return l.id, nil
+	}
+
+	start := time.Now()
+	resp, err := d.client.Grant(ctx, 2*classTTL)
+	if err != nil {
+		return clientv3.NoLease, errors.Wrap(err, "ratelimiter: failed to grant lease")
+	}
+	d.mu.Lock()
+	d.leases[class] = &etcdLease{
+		id:      resp.ID,
+		renewAt: start.Add(time.Duration(classTTL) * time.Second),
+	}
+	d.mu.Unlock()
+	return resp.ID, nil
+}
+
+// dropLease forgets a lease that is no longer valid, e.g. it was revoked.
+func (d *EtcdDriver) dropLease(id clientv3.LeaseID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for class, l := range d.leases {
+		if l.id == id {
+			delete(d.leases, class)
+		}
+	}
+}
+
+func (d *EtcdDriver) Reserve(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
+	if err := validateReserveRequest(req, AlgorithmGCRA); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "ratelimiter: context done")
+	default:
+	}
+
+	// the key is not needed once the bucket is full again
+	ttl := req.MaxFutureReserve + time.Duration(req.Burst)*req.DurationPerToken
+
+	for i := 0; i <= etcdMaxRetries; i++ {
+		now := time.Now().UTC() // stripMono
+		if Test {
+			nowFunc, exists := NowFuncFromContextForTest(ctx)
+			if exists {
+				now = nowFunc().UTC() // stripMono
+			}
+		}
+
+		getResp, err := d.client.Get(ctx, req.Key)
+		if err != nil {
+			return nil, errors.Wrap(err, "ratelimiter: failed to get key")
+		}
+
+		var timeBase time.Time
+		var modRevision int64
+		found := len(getResp.Kvs) > 0
+		if found {
+			modRevision = getResp.Kvs[0].ModRevision
+			unixMicroBase, err := strconv.ParseInt(string(getResp.Kvs[0].Value), 10, 64)
+			if err != nil {
+				return nil, errors.Wrap(err, "ratelimiter: failed to parse base time")
+			}
+			timeBase = time.UnixMicro(unixMicroBase)
+		}
+
+		timeToAct, ok := reserveGCRA(req, now, timeBase, found)
+		if !ok {
+			return &Reservation{
+				ReserveRequest: req,
+				OK:             false,
+				TimeToAct:      timeToAct,
+				Now:            now,
+			}, nil
+		}
+
+		leaseID, err := d.lease(ctx, ttl)
+		if err != nil {
+			return nil, err
+		}
+
+		txnResp, err := d.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(req.Key), "=", modRevision)).
+			Then(clientv3.OpPut(req.Key, strconv.FormatInt(timeToAct.UnixMicro(), 10), clientv3.WithLease(leaseID))).
+			Commit()
+		if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			// the shared lease was revoked or expired in etcd, grant another one
+			d.dropLease(leaseID)
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "ratelimiter: failed to save time to act")
+		}
+		if txnResp.Succeeded {
+			return &Reservation{
+				ReserveRequest: req,
+				OK:             true,
+				TimeToAct:      timeToAct,
+				Now:            now,
+			}, nil
+		}
+	}
+
+	return nil, errors.Wrapf(ErrTooManyRetries, "etcd key %q", req.Key)
+}
@@ -0,0 +1,137 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+	"golang.org/x/sync/errgroup"
+)
+
+func freeURLForTest(t *testing.T) url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	u, err := url.Parse(fmt.Sprintf("http://%s", l.Addr().String()))
+	require.NoError(t, err)
+	return *u
+}
+
+func newEtcdDriverForTest(t *testing.T) *EtcdDriver {
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientURL, peerURL := freeURLForTest(t), freeURLForTest(t)
+	cfg.ListenClientUrls, cfg.AdvertiseClientUrls = []url.URL{clientURL}, []url.URL{clientURL}
+	cfg.ListenPeerUrls, cfg.AdvertisePeerUrls = []url.URL{peerURL}, []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	server, err := embed.StartEtcd(cfg)
+	require.NoError(t, err)
+	t.Cleanup(server.Close)
+
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd server took too long to start")
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{clientURL.String()},
+		DialTimeout: 5 * time.Second,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		client.Close()
+	})
+
+	return NewEtcdDriver(client)
+}
+
+func TestReverseWithNowAdvanced_DriverEtcd(t *testing.T) {
+	testReverseWithNowAdvanced(t, New(newEtcdDriverForTest(t)), "TestReverseWithNowAdvanced_DriverEtcd")
+}
+
+func TestAllowWithNowAdvanced_DriverEtcd(t *testing.T) {
+	testAllowWithNowAdvanced(t, New(newEtcdDriverForTest(t)), "TestAllowWithNowAdvanced_DriverEtcd")
+}
+
+func TestReverse_DriverEtcd(t *testing.T) {
+	testReverse(t, New(newEtcdDriverForTest(t)), "TestReverse_DriverEtcd")
+}
+
+func TestEtcdConcurrentReserve(t *testing.T) {
+	limiter := New(newEtcdDriverForTest(t))
+
+	now := time.Now()
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+
+	burst := 5
+	var allowed [10]bool
+	var errG errgroup.Group
+	for i := range allowed {
+		errG.Go(func() error {
+			ok, err := limiter.Allow(ctx, &AllowRequest{
+				Key:              "TestEtcdConcurrentReserve",
+				DurationPerToken: time.Hour,
+				Burst:            burst,
+				Tokens:           1,
+			})
+			allowed[i] = ok
+			return err
+		})
+	}
+	require.NoError(t, errG.Wait())
+
+	count := 0
+	for _, ok := range allowed {
+		if ok {
+			count++
+		}
+	}
+	require.Equal(t, burst, count)
+}
+
+func TestEtcdSharedLease(t *testing.T) {
+	d := newEtcdDriverForTest(t)
+	limiter := New(d)
+	ctx := context.Background()
+
+	for i := range 20 {
+		_, err := limiter.Reserve(ctx, &ReserveRequest{
+			Key:              fmt.Sprintf("TestEtcdSharedLease:%d", i%4),
+			DurationPerToken: time.Second,
+			Burst:            10,
+			Tokens:           1,
+		})
+		require.NoError(t, err)
+	}
+	resp, err := d.client.Leases(ctx)
+	require.NoError(t, err)
+	require.Len(t, resp.Leases, 1)
+
+	// the keys are written with another lease once the shared one is revoked
+	_, err = d.client.Revoke(ctx, resp.Leases[0].ID)
+	require.NoError(t, err)
+	r, err := limiter.Reserve(ctx, &ReserveRequest{
+		Key:              "TestEtcdSharedLease:0",
+		DurationPerToken: time.Second,
+		Burst:            10,
+		Tokens:           1,
+	})
+	require.NoError(t, err)
+	require.True(t, r.OK)
+	getResp, err := d.client.Get(ctx, "TestEtcdSharedLease:0")
+	require.NoError(t, err)
+	require.Len(t, getResp.Kvs, 1)
+	require.NotEqual(t, resp.Leases[0].ID, clientv3.LeaseID(getResp.Kvs[0].Lease))
+}
@@ -13,14 +13,29 @@ import (
 	"gorm.io/gorm"
 )
 
+// KV is the state of a key, the algorithms share the key namespace, so a key must only be used by one algorithm.
+// The kv of AlgorithmSlidingWindowLog and AlgorithmLeakyBucketQueue has an empty value and is only the lock
+// of the events of the key, it is removed along with the expired events by `Compact`.
 type KV struct {
 	Key   string `json:"key" gorm:"primaryKey;not null;"`
 	Value string `json:"value" gorm:"not null;"`
 }
 
+// KVEvent is an event in the log of a key, which is used by AlgorithmSlidingWindowLog,
+// and a reservation in the queue of a key, which is used by AlgorithmLeakyBucketQueue.
+type KVEvent struct {
+	ID        uint64 `json:"id" gorm:"primaryKey;autoIncrement;"`
+	Key       string `json:"key" gorm:"index:idx_kv_events_key_unix_micro;not null;"`
+	UnixMicro int64  `json:"unixMicro" gorm:"index:idx_kv_events_key_unix_micro;not null;"`
+	Tokens    int    `json:"tokens" gorm:"not null;"`
+	// ExpireAt is the time in unix microseconds after which the event no longer affects the reservations of the key.
+	ExpireAt int64 `json:"expireAt" gorm:"index;not null;default:0;"`
+}
+
 type GormDriver struct {
-	db       *gorm.DB
-	rawQuery string
+	db             *gorm.DB
+	rawQuery       string
+	semaphoreQuery string
 }
 
 // NewGormDriver returns a Driver that uses Gorm as the storage.
@@ -41,21 +56,27 @@ func NewGormDriver(db *gorm.DB) *GormDriver {
 		currentTimestampQuery = "CURRENT_TIMESTAMP"
 	}
 
-	d.rawQuery = fmt.Sprintf(`
+	d.rawQuery = lockQuery("kvs", currentTimestampQuery)
+	d.semaphoreQuery = lockQuery("kv_semaphores", currentTimestampQuery)
+	return d
+}
+
+// lockQuery selects the row of a key in the table for update along with the db time.
+func lockQuery(table string, currentTimestampQuery string) string {
+	return fmt.Sprintf(`
 	WITH kv_select AS (
-		SELECT * FROM kvs WHERE key = ? FOR UPDATE
+		SELECT * FROM %s WHERE key = ? FOR UPDATE
 	)
 	SELECT kv.*, %s AS now 
 	FROM (SELECT 1) AS dummy
 	LEFT JOIN kv_select AS kv ON kv.key = ?;
-	`, currentTimestampQuery)
-	return d
+	`, table, currentTimestampQuery)
 }
 
 // InitGormDriver initializes a GormDriver with the provided Gorm DB.
 // Sometimes you may not need to auto migrate the KV table, you can use `NewGormDriver` instead.
 func InitGormDriver(ctx context.Context, db *gorm.DB) (*GormDriver, error) {
-	if err := db.AutoMigrate(&KV{}); err != nil {
+	if err := db.AutoMigrate(&KV{}, &KVEvent{}, &KVSemaphore{}, &KVLease{}); err != nil {
 		return nil, errors.Wrap(err, "ratelimiter: failed to migrate kv")
 	}
 
@@ -85,7 +106,24 @@ func isDuplicateKeyError(err error) bool {
 	}
 
 	errMsg := err.Error()
-	return strings.Contains(errMsg, "SQLSTATE 23505")
+	return strings.Contains(errMsg, "SQLSTATE 23505") || strings.Contains(errMsg, "UNIQUE constraint failed")
+}
+
+// lockKV selects the kv of the key for update along with the db time,
+// the kv is empty if the key does not exist.
+func (d *GormDriver) lockKV(ctx context.Context, tx *gorm.DB, key string) (*kvWrapper, error) {
+	var kv kvWrapper
+	if err := tx.Raw(d.rawQuery, key, key).Scan(&kv).Error; err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to get kv")
+	}
+
+	if Test {
+		afterQuery, ok := ctx.Value(ctxKeyAfterQuery{}).(func(kv kvWrapper))
+		if ok {
+			afterQuery(kv)
+		}
+	}
+	return &kv, nil
 }
 
 func (d *GormDriver) Reserve(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
@@ -93,8 +131,8 @@ func (d *GormDriver) Reserve(ctx context.Context, req *ReserveRequest) (*Reserva
 }
 
 func (d *GormDriver) reserve(ctx context.Context, req *ReserveRequest, idx int) (*Reservation, error) {
-	if req.Key == "" || req.DurationPerToken <= 0 || req.Burst <= 0 || req.Tokens <= 0 || req.Tokens > req.Burst {
-		return nil, errors.Wrapf(ErrInvalidParameters, "%v", req)
+	if err := validateReserveRequest(req, AlgorithmGCRA, AlgorithmSlidingWindowLog, AlgorithmSlidingWindowCounter, AlgorithmQuota, AlgorithmLeakyBucketQueue); err != nil {
+		return nil, err
 	}
 
 	select {
@@ -111,77 +149,268 @@ func (d *GormDriver) reserve(ctx context.Context, req *ReserveRequest, idx int)
 		}
 	}
 
+	var r *Reservation
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		kv, err := d.lockKV(ctx, tx, req.Key)
+		if err != nil {
+			return err
+		}
+
+		if now.IsZero() {
+			now = kv.Now // use db time
+		}
+
+		switch req.Algorithm {
+		case AlgorithmSlidingWindowLog:
+			r, err = d.reserveSlidingWindowLogTx(tx, req, &kv.KV, now)
+		case AlgorithmSlidingWindowCounter:
+			r, err = d.reserveSlidingWindowCounterTx(tx, req, &kv.KV, now)
+		case AlgorithmQuota:
+			r, err = d.reserveQuotaTx(tx, req, &kv.KV, now)
+		case AlgorithmLeakyBucketQueue:
+			r, err = d.reserveLeakyBucketQueueTx(tx, req, &kv.KV, now)
+		default:
+			r, err = d.reserveGCRATx(tx, req, &kv.KV, now)
+		}
+		return err
+	})
+	if err != nil {
+		// retry once if duplicate key errorf
+		if idx == 0 && isDuplicateKeyError(err) {
+			return d.reserve(ctx, req, idx+1)
+		}
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (d *GormDriver) reserveGCRATx(tx *gorm.DB, req *ReserveRequest, kv *KV, now time.Time) (*Reservation, error) {
 	var timeBase time.Time
-	var timeToAct time.Time
-	var ok bool
+	found := kv.Key != ""
+	if found {
+		unixMicroBase, err := strconv.ParseInt(kv.Value, 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "ratelimiter: failed to parse base time")
+		}
+		timeBase = time.UnixMicro(unixMicroBase)
+	}
 
-	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		var kv kvWrapper
+	timeToAct, ok := reserveGCRA(req, now, timeBase, found)
+	if !ok {
+		return newReservation(req, now, timeToAct, false), nil
+	}
 
-		if err := tx.Raw(d.rawQuery, req.Key, req.Key).Scan(&kv).Error; err != nil {
-			return errors.Wrap(err, "ratelimiter: failed to get kv")
+	value := strconv.FormatInt(timeToAct.UnixMicro(), 10)
+	if !found {
+		if err := tx.Create(&KV{
+			Key:   req.Key,
+			Value: value,
+		}).Error; err != nil {
+			return nil, errors.Wrap(err, "ratelimiter: failed to create kv")
 		}
+		return newReservation(req, now, timeToAct, true), nil
+	}
+
+	if err := tx.Model(&KV{}).Where("key = ?", req.Key).Update(
+		"value", value,
+	).Error; err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to save time to act")
+	}
+	return newReservation(req, now, timeToAct, true), nil
+}
 
-		if Test {
-			afterQuery, ok := ctx.Value(ctxKeyAfterQuery{}).(func(kv kvWrapper))
-			if ok {
-				afterQuery(kv)
-			}
+func (d *GormDriver) reserveSlidingWindowLogTx(tx *gorm.DB, req *ReserveRequest, kv *KV, now time.Time) (*Reservation, error) {
+	// the kv of the key is only used as the lock of its log
+	if kv.Key == "" {
+		if err := tx.Create(&KV{
+			Key:   req.Key,
+			Value: "",
+		}).Error; err != nil {
+			return nil, errors.Wrap(err, "ratelimiter: failed to create kv")
 		}
+	}
 
-		if now.IsZero() {
-			now = kv.Now // use db time
+	window := time.Duration(req.Burst) * req.DurationPerToken
+	if err := tx.Where("key = ? AND unix_micro <= ?", req.Key, now.Add(-window).UnixMicro()).Delete(&KVEvent{}).Error; err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to delete expired events")
+	}
+
+	var events []*KVEvent
+	if err := tx.Where("key = ?", req.Key).Order("unix_micro DESC").Find(&events).Error; err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to get events")
+	}
+
+	entries := make([]slidingWindowLogEntry, len(events))
+	for i, event := range events {
+		entries[i] = slidingWindowLogEntry{
+			At:     time.UnixMicro(event.UnixMicro),
+			Tokens: event.Tokens,
+		}
+	}
+
+	timeToAct, ok := reserveSlidingWindowLog(req, now, entries)
+	if !ok {
+		return newReservation(req, now, timeToAct, false), nil
+	}
+
+	if err := tx.Create(&KVEvent{
+		Key:       req.Key,
+		UnixMicro: timeToAct.UnixMicro(),
+		Tokens:    req.Tokens,
+		ExpireAt:  timeToAct.Add(window).UnixMicro(),
+	}).Error; err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to create event")
+	}
+	return newReservation(req, now, timeToAct, true), nil
+}
+
+func (d *GormDriver) reserveSlidingWindowCounterTx(tx *gorm.DB, req *ReserveRequest, kv *KV, now time.Time) (*Reservation, error) {
+	var state slidingWindowCounterState
+	found := kv.Key != ""
+	if found {
+		var err error
+		state, err = parseSlidingWindowCounterState(kv.Value)
+		if err != nil {
+			return nil, err
 		}
-		resetValue := now.Add(-time.Duration(req.Burst) * req.DurationPerToken)
+	}
 
-		if kv.Key == "" { // not found
-			timeBase = resetValue
-			if err := tx.Create(&KV{
-				Key:   req.Key,
-				Value: strconv.FormatInt(timeBase.UnixMicro(), 10),
-			}).Error; err != nil {
-				return errors.Wrap(err, "ratelimiter: failed to create kv")
-			}
-		} else {
-			unixMicroBase, err := strconv.ParseInt(kv.Value, 10, 64)
-			if err != nil {
-				return errors.Wrap(err, "ratelimiter: failed to parse base time")
-			}
-			timeBase = time.UnixMicro(unixMicroBase)
+	timeToAct, next, ok := reserveSlidingWindowCounter(req, now, state, found)
+	if !ok {
+		return newReservation(req, now, timeToAct, false), nil
+	}
 
-			if timeBase.Before(resetValue) {
-				timeBase = resetValue
-			}
+	if !found {
+		if err := tx.Create(&KV{
+			Key:   req.Key,
+			Value: next.String(),
+		}).Error; err != nil {
+			return nil, errors.Wrap(err, "ratelimiter: failed to create kv")
 		}
+		return newReservation(req, now, timeToAct, true), nil
+	}
 
-		tokensDuration := req.DurationPerToken * time.Duration(req.Tokens)
-		timeToAct = timeBase.Add(tokensDuration).UTC()
+	if err := tx.Model(&KV{}).Where("key = ?", req.Key).Update(
+		"value", next.String(),
+	).Error; err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to save sliding window counter")
+	}
+	return newReservation(req, now, timeToAct, true), nil
+}
 
-		if timeToAct.After(now.Add(req.MaxFutureReserve)) {
-			ok = false
-			return nil
+func (d *GormDriver) reserveQuotaTx(tx *gorm.DB, req *ReserveRequest, kv *KV, now time.Time) (*Reservation, error) {
+	var state quotaState
+	found := kv.Key != ""
+	if found {
+		var err error
+		state, err = parseQuotaState(kv.Value)
+		if err != nil {
+			return nil, err
 		}
+	}
+
+	next, resetAt, ok := reserveQuota(req, now, state, found)
+	if !ok || req.Tokens == 0 {
+		return newQuotaReservation(req, now, next.Used, resetAt, ok), nil
+	}
 
-		if err := tx.Model(&KV{}).Where("key = ?", req.Key).Update(
-			"value", strconv.FormatInt(timeToAct.UnixMicro(), 10),
-		).Error; err != nil {
-			return errors.Wrap(err, "ratelimiter: failed to save time to act")
+	if !found {
+		if err := tx.Create(&KV{
+			Key:   req.Key,
+			Value: next.String(),
+		}).Error; err != nil {
+			return nil, errors.Wrap(err, "ratelimiter: failed to create kv")
 		}
-		ok = true
-		return nil
-	})
+		return newQuotaReservation(req, now, next.Used, resetAt, true), nil
+	}
+
+	if err := tx.Model(&KV{}).Where("key = ?", req.Key).Update(
+		"value", next.String(),
+	).Error; err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to save quota")
+	}
+	return newQuotaReservation(req, now, next.Used, resetAt, true), nil
+}
+
+func (d *GormDriver) reserveLeakyBucketQueueTx(tx *gorm.DB, req *ReserveRequest, kv *KV, now time.Time) (*Reservation, error) {
+	// the kv of the key is only used as the lock of its queue
+	if kv.Key == "" {
+		if err := tx.Create(&KV{
+			Key:   req.Key,
+			Value: "",
+		}).Error; err != nil {
+			return nil, errors.Wrap(err, "ratelimiter: failed to create kv")
+		}
+	}
+
+	burstDuration := time.Duration(req.Burst) * req.DurationPerToken
+	resetValue := now.Add(-burstDuration)
+	if err := tx.Where("key = ? AND unix_micro <= ?", req.Key, resetValue.UnixMicro()).Delete(&KVEvent{}).Error; err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to delete expired events")
+	}
+
+	var last KVEvent
+	found := true
+	if err := tx.Where("key = ?", req.Key).Order("unix_micro DESC").Take(&last).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.Wrap(err, "ratelimiter: failed to get last event")
+		}
+		found = false
+	}
+
+	var depth int64
+	if err := tx.Model(&KVEvent{}).Where("key = ? AND unix_micro > ?", req.Key, now.UnixMicro()).Count(&depth).Error; err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to count queue")
+	}
+
+	timeToAct, ok := reserveLeakyBucketQueue(req, now, time.UnixMicro(last.UnixMicro), found, int(depth))
+	if !ok {
+		return newQueueReservation(req, now, timeToAct, false, "", int(depth)), nil
+	}
+
+	event := &KVEvent{
+		Key:       req.Key,
+		UnixMicro: timeToAct.UnixMicro(),
+		Tokens:    req.Tokens,
+		ExpireAt:  timeToAct.Add(burstDuration).UnixMicro(),
+	}
+	if err := tx.Create(event).Error; err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to create event")
+	}
+	return newQueueReservation(req, now, timeToAct, true, strconv.FormatUint(event.ID, 10), int(depth)), nil
+}
+
+var _ QueueDriver = (*GormDriver)(nil)
+
+func (d *GormDriver) AbandonQueue(ctx context.Context, key string, id string) error {
+	eventID, err := strconv.ParseUint(id, 10, 64)
 	if err != nil {
-		// retry once if duplicate key errorf
-		if idx == 0 && isDuplicateKeyError(err) {
-			return d.reserve(ctx, req, idx+1)
+		return errors.Wrapf(ErrInvalidParameters, "queue id %q", id)
+	}
+
+	var now time.Time
+	if Test {
+		nowFunc, exists := NowFuncFromContextForTest(ctx)
+		if exists {
+			now = nowFunc().UTC() // stripMono
 		}
-		return nil, err
 	}
 
-	return &Reservation{
-		ReserveRequest: req,
-		OK:             ok,
-		TimeToAct:      timeToAct,
-		Now:            now,
-	}, nil
+	return d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		kv, err := d.lockKV(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		if now.IsZero() {
+			now = kv.Now // use db time
+		}
+
+		// only the waiting reservation can be abandoned, the ones that have acted still affect the time base
+		if err := tx.Where("key = ? AND id = ? AND unix_micro > ?", key, eventID, now.UnixMicro()).Delete(&KVEvent{}).Error; err != nil {
+			return errors.Wrap(err, "ratelimiter: failed to abandon queue")
+		}
+		return nil
+	})
 }
@@ -0,0 +1,122 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+var _ AdaptiveDriver = (*GormDriver)(nil)
+
+// ReserveAdaptive stores the bucket of the key in the kv along with its current DurationPerToken.
+func (d *GormDriver) ReserveAdaptive(ctx context.Context, req *AdaptiveRequest) (*Reservation, error) {
+	if err := validateAdaptiveRequest(req); err != nil {
+		return nil, err
+	}
+
+	var r *Reservation
+	err := d.updateAdaptive(ctx, req.Key, 0, func(state adaptiveState, found bool, now time.Time) (adaptiveState, bool) {
+		durationPerToken := req.durationPerToken(state, found)
+		reserveReq := req.reserveRequest(durationPerToken)
+
+		timeToAct, ok := reserveGCRA(reserveReq, now, time.UnixMicro(state.TimeBase), found)
+		r = newReservation(reserveReq, now, timeToAct, ok)
+		return adaptiveState{
+			TimeBase:         timeToAct.UnixMicro(),
+			DurationPerToken: durationPerToken.Microseconds(),
+		}, ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (d *GormDriver) ReportOutcome(ctx context.Context, req *AdaptiveRequest, outcome Outcome) (time.Duration, error) {
+	if err := validateAdaptiveRequest(req); err != nil {
+		return 0, err
+	}
+
+	var durationPerToken time.Duration
+	err := d.updateAdaptive(ctx, req.Key, 0, func(state adaptiveState, found bool, now time.Time) (adaptiveState, bool) {
+		durationPerToken = adjustAdaptive(req, req.durationPerToken(state, found), outcome)
+		// the zero time base of a new key means the bucket is full
+		return adaptiveState{
+			TimeBase:         state.TimeBase,
+			DurationPerToken: durationPerToken.Microseconds(),
+		}, true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return durationPerToken, nil
+}
+
+// updateAdaptive locks the adaptive state of the key and saves the state returned by fn if save is true.
+func (d *GormDriver) updateAdaptive(ctx context.Context, key string, idx int, fn func(state adaptiveState, found bool, now time.Time) (next adaptiveState, save bool)) error {
+	select {
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "ratelimiter: context done")
+	default:
+	}
+
+	var now time.Time
+	if Test {
+		nowFunc, exists := NowFuncFromContextForTest(ctx)
+		if exists {
+			now = nowFunc().UTC() // stripMono
+		}
+	}
+
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		kv, err := d.lockKV(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		if now.IsZero() {
+			now = kv.Now // use db time
+		}
+
+		var state adaptiveState
+		found := kv.Key != ""
+		if found {
+			state, err = parseAdaptiveState(kv.Value)
+			if err != nil {
+				return err
+			}
+		}
+
+		next, save := fn(state, found, now)
+		if !save {
+			return nil
+		}
+
+		if !found {
+			if err := tx.Create(&KV{
+				Key:   key,
+				Value: next.String(),
+			}).Error; err != nil {
+				return errors.Wrap(err, "ratelimiter: failed to create kv")
+			}
+			return nil
+		}
+
+		if err := tx.Model(&KV{}).Where("key = ?", key).Update(
+			"value", next.String(),
+		).Error; err != nil {
+			return errors.Wrap(err, "ratelimiter: failed to save adaptive state")
+		}
+		return nil
+	})
+	if err != nil {
+		// retry once if duplicate key error
+		if idx == 0 && isDuplicateKeyError(err) {
+			return d.updateAdaptive(ctx, key, idx+1, fn)
+		}
+		return err
+	}
+	return nil
+}
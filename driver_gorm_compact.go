@@ -0,0 +1,90 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// Compact removes the expired events of AlgorithmSlidingWindowLog and AlgorithmLeakyBucketQueue with the prefix,
+// along with the kvs that are only the locks of the removed events, and returns the number of removed kvs.
+// Each key is compacted in its own transaction so that reservations are not blocked for long.
+func (d *GormDriver) Compact(ctx context.Context, prefix string) (int, error) {
+	var now time.Time
+	if Test {
+		nowFunc, exists := NowFuncFromContextForTest(ctx)
+		if exists {
+			now = nowFunc().UTC() // stripMono
+		}
+	}
+
+	deleted := 0
+	cursor := ""
+	for {
+		var keys []string
+		if err := d.db.WithContext(ctx).Model(&KV{}).
+			Where(`key LIKE ? ESCAPE '!'`, escapeLike(prefix)+"%").
+			Where("key > ? AND value = ?", cursor, "").
+			Order("key").Limit(gormCompactionBatchSize).
+			Pluck("key", &keys).Error; err != nil {
+			return deleted, errors.Wrap(err, "ratelimiter: failed to scan keys")
+		}
+
+		for _, key := range keys {
+			ok, err := d.compactKey(ctx, key, now)
+			if err != nil {
+				return deleted, err
+			}
+			if ok {
+				deleted++
+			}
+		}
+
+		if len(keys) < gormCompactionBatchSize {
+			return deleted, nil
+		}
+		cursor = keys[len(keys)-1]
+	}
+}
+
+// compactKey removes the expired events of the key and reports whether its kv is removed as well.
+func (d *GormDriver) compactKey(ctx context.Context, key string, now time.Time) (bool, error) {
+	deleted := false
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		kv, err := d.lockKV(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+		if kv.Key == "" || kv.Value != "" {
+			return nil // removed or reused by another algorithm since it is scanned
+		}
+
+		if now.IsZero() {
+			now = kv.Now // use db time
+		}
+
+		if err := tx.Where("key = ? AND expire_at <= ?", key, now.UnixMicro()).Delete(&KVEvent{}).Error; err != nil {
+			return errors.Wrap(err, "ratelimiter: failed to delete expired events")
+		}
+
+		var count int64
+		if err := tx.Model(&KVEvent{}).Where("key = ?", key).Count(&count).Error; err != nil {
+			return errors.Wrap(err, "ratelimiter: failed to count events")
+		}
+		if count > 0 {
+			return nil
+		}
+
+		if err := tx.Where("key = ? AND value = ?", key, "").Delete(&KV{}).Error; err != nil {
+			return errors.Wrap(err, "ratelimiter: failed to delete kv")
+		}
+		deleted = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return deleted, nil
+}
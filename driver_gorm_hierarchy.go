@@ -0,0 +1,112 @@
+package ratelimiter
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+var _ HierarchicalDriver = (*GormDriver)(nil)
+
+// ReserveHierarchy evaluates all levels in one transaction, the kvs are locked in the order of their keys to avoid deadlocks.
+// The levels are stored the same as AlgorithmGCRA, so a key can also be reserved alone.
+func (d *GormDriver) ReserveHierarchy(ctx context.Context, reqs []*ReserveRequest) (*HierarchicalReservation, error) {
+	return d.reserveHierarchy(ctx, reqs, 0)
+}
+
+func (d *GormDriver) reserveHierarchy(ctx context.Context, reqs []*ReserveRequest, idx int) (*HierarchicalReservation, error) {
+	if err := validateHierarchy(reqs); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "ratelimiter: context done")
+	default:
+	}
+
+	var now time.Time
+	if Test {
+		nowFunc, exists := NowFuncFromContextForTest(ctx)
+		if exists {
+			now = nowFunc().UTC() // stripMono
+		}
+	}
+
+	keys := make([]string, len(reqs))
+	for i, req := range reqs {
+		keys[i] = req.Key
+	}
+	slices.Sort(keys)
+
+	var r *HierarchicalReservation
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		kvs := make(map[string]*kvWrapper, len(keys))
+		for _, key := range keys {
+			kv, err := d.lockKV(ctx, tx, key)
+			if err != nil {
+				return err
+			}
+			kvs[key] = kv
+
+			if now.IsZero() {
+				now = kv.Now // use db time
+			}
+		}
+
+		levels := make([]*Reservation, len(reqs))
+		for i, req := range reqs {
+			var timeBase time.Time
+			kv := kvs[req.Key]
+			found := kv.Key != ""
+			if found {
+				unixMicroBase, err := strconv.ParseInt(kv.Value, 10, 64)
+				if err != nil {
+					return errors.Wrap(err, "ratelimiter: failed to parse base time")
+				}
+				timeBase = time.UnixMicro(unixMicroBase)
+			}
+
+			timeToAct, ok := reserveGCRA(req, now, timeBase, found)
+			levels[i] = newReservation(req, now, timeToAct, ok)
+		}
+
+		r = newHierarchicalReservation(levels, now)
+		if !r.OK {
+			return nil
+		}
+
+		for _, level := range levels {
+			value := strconv.FormatInt(level.TimeToAct.UnixMicro(), 10)
+			if kvs[level.Key].Key == "" {
+				if err := tx.Create(&KV{
+					Key:   level.Key,
+					Value: value,
+				}).Error; err != nil {
+					return errors.Wrap(err, "ratelimiter: failed to create kv")
+				}
+				continue
+			}
+
+			if err := tx.Model(&KV{}).Where("key = ?", level.Key).Update(
+				"value", value,
+			).Error; err != nil {
+				return errors.Wrap(err, "ratelimiter: failed to save time to act")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// retry once if duplicate key error
+		if idx == 0 && isDuplicateKeyError(err) {
+			return d.reserveHierarchy(ctx, reqs, idx+1)
+		}
+		return nil, err
+	}
+
+	return r, nil
+}
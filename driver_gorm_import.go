@@ -0,0 +1,56 @@
+package ratelimiter
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+var _ ImportDriver = (*GormDriver)(nil)
+
+func (d *GormDriver) ImportBucket(ctx context.Context, b BucketState) error {
+	if b.Key == "" {
+		return errors.Wrap(ErrInvalidParameters, "key of bucket")
+	}
+	return d.importBucket(ctx, b, 0)
+}
+
+func (d *GormDriver) importBucket(ctx context.Context, b BucketState, idx int) error {
+	value := strconv.FormatInt(b.TimeBase, 10)
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		kv, err := d.lockKV(ctx, tx, b.Key)
+		if err != nil {
+			return err
+		}
+
+		if kv.Key == "" {
+			if err := tx.Create(&KV{Key: b.Key, Value: value}).Error; err != nil {
+				return errors.Wrap(err, "ratelimiter: failed to create kv")
+			}
+			return nil
+		}
+
+		current, err := strconv.ParseInt(kv.Value, 10, 64)
+		if err != nil {
+			return errors.Wrapf(ErrInvalidParameters, "key %q is not a GCRA bucket", b.Key)
+		}
+		// keep the later timeBase, which has less tokens
+		if current >= b.TimeBase {
+			return nil
+		}
+		if err := tx.Model(&KV{}).Where("key = ?", b.Key).Update("value", value).Error; err != nil {
+			return errors.Wrap(err, "ratelimiter: failed to import bucket")
+		}
+		return nil
+	})
+	if err != nil {
+		// retry once if duplicate key error
+		if idx == 0 && isDuplicateKeyError(err) {
+			return d.importBucket(ctx, b, idx+1)
+		}
+		return err
+	}
+	return nil
+}
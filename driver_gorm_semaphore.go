@@ -0,0 +1,248 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// KVSemaphore is the lock of the leases of a key, which is used by Semaphore.
+// It is separate from KV so that the semaphores do not share the key namespace of the rate limits,
+// and it is removed along with the last lease.
+type KVSemaphore struct {
+	Key string `json:"key" gorm:"primaryKey;not null;"`
+}
+
+// KVLease is a lease of a key, which is used by Semaphore.
+type KVLease struct {
+	Key       string `json:"key" gorm:"primaryKey;not null;"`
+	ID        string `json:"id" gorm:"primaryKey;not null;"`
+	ExpiresAt int64  `json:"expiresAt" gorm:"index;not null;"`
+}
+
+var _ SemaphoreDriver = (*GormDriver)(nil)
+
+const gormCompactionBatchSize = 1000
+
+func (d *GormDriver) leaseNow(ctx context.Context) time.Time {
+	if Test {
+		nowFunc, exists := NowFuncFromContextForTest(ctx)
+		if exists {
+			return nowFunc().UTC() // stripMono
+		}
+	}
+	return time.Time{}
+}
+
+type kvSemaphoreWrapper struct {
+	KVSemaphore
+	Now time.Time
+}
+
+// lockSemaphore selects the lock of the leases of the key for update along with the db time,
+// the lock is empty if the key has no leases.
+func (d *GormDriver) lockSemaphore(tx *gorm.DB, key string) (*kvSemaphoreWrapper, error) {
+	var sem kvSemaphoreWrapper
+	if err := tx.Raw(d.semaphoreQuery, key, key).Scan(&sem).Error; err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to get semaphore")
+	}
+	return &sem, nil
+}
+
+// deleteSemaphoreIfEmptyTx deletes the expired leases of the key, and the lock of the leases if none is left.
+func deleteSemaphoreIfEmptyTx(tx *gorm.DB, key string, now time.Time) (bool, error) {
+	if err := tx.Where("key = ? AND expires_at <= ?", key, now.UnixMicro()).Delete(&KVLease{}).Error; err != nil {
+		return false, errors.Wrap(err, "ratelimiter: failed to delete expired leases")
+	}
+
+	var count int64
+	if err := tx.Model(&KVLease{}).Where("key = ?", key).Count(&count).Error; err != nil {
+		return false, errors.Wrap(err, "ratelimiter: failed to count leases")
+	}
+	if count > 0 {
+		return false, nil
+	}
+
+	if err := tx.Where("key = ?", key).Delete(&KVSemaphore{}).Error; err != nil {
+		return false, errors.Wrap(err, "ratelimiter: failed to delete semaphore")
+	}
+	return true, nil
+}
+
+func (d *GormDriver) AcquireLease(ctx context.Context, req *LeaseRequest) (time.Time, error) {
+	return d.acquireLease(ctx, req, 0)
+}
+
+func (d *GormDriver) acquireLease(ctx context.Context, req *LeaseRequest, idx int) (time.Time, error) {
+	if req.Key == "" || req.ID == "" || req.Limit <= 0 || req.TTL < time.Microsecond {
+		return time.Time{}, errors.Wrapf(ErrInvalidParameters, "lease request: %+v", req)
+	}
+
+	now := d.leaseNow(ctx)
+
+	var expiresAt time.Time
+	var ok bool
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		sem, err := d.lockSemaphore(tx, req.Key)
+		if err != nil {
+			return err
+		}
+
+		if now.IsZero() {
+			now = sem.Now // use db time
+		}
+
+		if sem.Key == "" {
+			if err := tx.Create(&KVSemaphore{Key: req.Key}).Error; err != nil {
+				return errors.Wrap(err, "ratelimiter: failed to create semaphore")
+			}
+		}
+
+		if err := tx.Where("key = ? AND expires_at <= ?", req.Key, now.UnixMicro()).Delete(&KVLease{}).Error; err != nil {
+			return errors.Wrap(err, "ratelimiter: failed to delete expired leases")
+		}
+
+		var count int64
+		if err := tx.Model(&KVLease{}).Where("key = ?", req.Key).Count(&count).Error; err != nil {
+			return errors.Wrap(err, "ratelimiter: failed to count leases")
+		}
+		if count >= int64(req.Limit) {
+			return nil
+		}
+
+		expiresAt = time.UnixMicro(now.Add(req.TTL).UnixMicro()).UTC()
+		if err := tx.Create(&KVLease{
+			Key:       req.Key,
+			ID:        req.ID,
+			ExpiresAt: expiresAt.UnixMicro(),
+		}).Error; err != nil {
+			return errors.Wrap(err, "ratelimiter: failed to create lease")
+		}
+		ok = true
+		return nil
+	})
+	if err != nil {
+		// retry once if duplicate key error
+		if idx == 0 && isDuplicateKeyError(err) {
+			return d.acquireLease(ctx, req, idx+1)
+		}
+		return time.Time{}, err
+	}
+	if !ok {
+		return time.Time{}, errors.Wrapf(ErrConcurrencyLimitExceeded, "key %q", req.Key)
+	}
+	return expiresAt, nil
+}
+
+func (d *GormDriver) RenewLease(ctx context.Context, req *LeaseRequest) (time.Time, error) {
+	if req.Key == "" || req.ID == "" || req.TTL < time.Microsecond {
+		return time.Time{}, errors.Wrapf(ErrInvalidParameters, "lease request: %+v", req)
+	}
+
+	now := d.leaseNow(ctx)
+
+	var expiresAt time.Time
+	var ok bool
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		sem, err := d.lockSemaphore(tx, req.Key)
+		if err != nil {
+			return err
+		}
+
+		if now.IsZero() {
+			now = sem.Now // use db time
+		}
+
+		expiresAt = time.UnixMicro(now.Add(req.TTL).UnixMicro()).UTC()
+		result := tx.Model(&KVLease{}).
+			Where("key = ? AND id = ? AND expires_at > ?", req.Key, req.ID, now.UnixMicro()).
+			Update("expires_at", expiresAt.UnixMicro())
+		if result.Error != nil {
+			return errors.Wrap(result.Error, "ratelimiter: failed to renew lease")
+		}
+		ok = result.RowsAffected > 0
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !ok {
+		return time.Time{}, errors.Wrapf(ErrLeaseNotFound, "key %q, id %q", req.Key, req.ID)
+	}
+	return expiresAt, nil
+}
+
+// ReleaseLease releases the lease, and removes the lock of the leases of the key once none is left.
+func (d *GormDriver) ReleaseLease(ctx context.Context, key string, id string) error {
+	now := d.leaseNow(ctx)
+
+	return d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		sem, err := d.lockSemaphore(tx, key)
+		if err != nil {
+			return err
+		}
+		if sem.Key == "" {
+			return nil
+		}
+
+		if now.IsZero() {
+			now = sem.Now // use db time
+		}
+
+		if err := tx.Where("key = ? AND id = ?", key, id).Delete(&KVLease{}).Error; err != nil {
+			return errors.Wrap(err, "ratelimiter: failed to release lease")
+		}
+		_, err = deleteSemaphoreIfEmptyTx(tx, key, now)
+		return err
+	})
+}
+
+// CompactLeases removes the expired leases of the keys with the prefix, along with the locks of the keys
+// that have no leases left, and returns the number of removed locks.
+// Each key is compacted in its own transaction so that the semaphores are not blocked for long.
+func (d *GormDriver) CompactLeases(ctx context.Context, prefix string) (int, error) {
+	now := d.leaseNow(ctx)
+
+	deleted := 0
+	cursor := ""
+	for {
+		var keys []string
+		if err := d.db.WithContext(ctx).Model(&KVSemaphore{}).
+			Where(`key LIKE ? ESCAPE '!'`, escapeLike(prefix)+"%").
+			Where("key > ?", cursor).
+			Order("key").Limit(gormCompactionBatchSize).
+			Pluck("key", &keys).Error; err != nil {
+			return deleted, errors.Wrap(err, "ratelimiter: failed to scan semaphores")
+		}
+
+		for _, key := range keys {
+			err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				sem, err := d.lockSemaphore(tx, key)
+				if err != nil || sem.Key == "" {
+					return err
+				}
+
+				keyNow := now
+				if keyNow.IsZero() {
+					keyNow = sem.Now // use db time
+				}
+
+				ok, err := deleteSemaphoreIfEmptyTx(tx, key, keyNow)
+				if ok {
+					deleted++
+				}
+				return err
+			})
+			if err != nil {
+				return deleted, err
+			}
+		}
+
+		if len(keys) < gormCompactionBatchSize {
+			return deleted, nil
+		}
+		cursor = keys[len(keys)-1]
+	}
+}
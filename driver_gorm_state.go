@@ -0,0 +1,91 @@
+package ratelimiter
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+var _ StateDriver = (*GormDriver)(nil)
+
+// escapeLike escapes the wildcards of a LIKE pattern with the escape character !,
+// which is not special in the string literals of any database unlike \.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`).Replace(s)
+}
+
+// ScanKeys returns the keys in order, the cursor is the last key of the batch.
+func (d *GormDriver) ScanKeys(ctx context.Context, prefix string, cursor string, limit int) ([]string, string, error) {
+	if limit <= 0 {
+		return nil, "", errors.Wrapf(ErrInvalidParameters, "limit %d", limit)
+	}
+
+	var keys []string
+	if err := d.db.WithContext(ctx).Model(&KV{}).
+		Where(`key LIKE ? ESCAPE '!'`, escapeLike(prefix)+"%").
+		Where("key > ?", cursor).
+		Order("key").Limit(limit).
+		Pluck("key", &keys).Error; err != nil {
+		return nil, "", errors.Wrap(err, "ratelimiter: failed to scan keys")
+	}
+	if len(keys) < limit {
+		return keys, "", nil
+	}
+	return keys, keys[len(keys)-1], nil
+}
+
+func (d *GormDriver) GetKeyState(ctx context.Context, key string) (*KeyState, error) {
+	var kv KV
+	err := d.db.WithContext(ctx).Where("key = ?", key).First(&kv).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to get kv")
+	}
+	return &KeyState{Key: kv.Key, Value: kv.Value}, nil
+}
+
+// ResetKey deletes the kv and the events of the key, along with the leases of its Semaphore.
+func (d *GormDriver) ResetKey(ctx context.Context, key string) error {
+	return d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("key = ?", key).Delete(&KVEvent{}).Error; err != nil {
+			return errors.Wrap(err, "ratelimiter: failed to delete events")
+		}
+		if err := tx.Where("key = ?", key).Delete(&KV{}).Error; err != nil {
+			return errors.Wrap(err, "ratelimiter: failed to delete kv")
+		}
+		if err := tx.Where("key = ?", key).Delete(&KVLease{}).Error; err != nil {
+			return errors.Wrap(err, "ratelimiter: failed to delete leases")
+		}
+		if err := tx.Where("key = ?", key).Delete(&KVSemaphore{}).Error; err != nil {
+			return errors.Wrap(err, "ratelimiter: failed to delete semaphore")
+		}
+		return nil
+	})
+}
+
+// ResetKeyIf deletes the kv and the events of the key if the value of the kv is not changed.
+func (d *GormDriver) ResetKeyIf(ctx context.Context, key string, value string) (bool, error) {
+	deleted := false
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("key = ? AND value = ?", key, value).Delete(&KV{})
+		if result.Error != nil {
+			return errors.Wrap(result.Error, "ratelimiter: failed to delete kv")
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		if err := tx.Where("key = ?", key).Delete(&KVEvent{}).Error; err != nil {
+			return errors.Wrap(err, "ratelimiter: failed to delete events")
+		}
+		deleted = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return deleted, nil
+}
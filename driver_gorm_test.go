@@ -159,3 +159,60 @@ func TestGormDuplicateCreate(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestGormCompact(t *testing.T) {
+	d := NewGormDriver(db)
+	limiter := New(d)
+	prefix := "TestGormCompact:"
+
+	now := time.Now().Truncate(time.Microsecond)
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+
+	// a log with a 3s window, a queue that is drained after 2s and a GCRA bucket
+	for _, req := range []*ReserveRequest{
+		{Key: prefix + "log", DurationPerToken: time.Second, Burst: 3, Tokens: 1, Algorithm: AlgorithmSlidingWindowLog},
+		{Key: prefix + "queue", DurationPerToken: time.Second, Burst: 2, Tokens: 1, Algorithm: AlgorithmLeakyBucketQueue, MaxQueue: 1},
+		{Key: prefix + "bucket", DurationPerToken: time.Second, Burst: 3, Tokens: 1},
+	} {
+		r, err := limiter.Reserve(ctx, req)
+		require.NoError(t, err)
+		require.True(t, r.OK)
+	}
+
+	countRows := func(key string) (kvs int64, events int64) {
+		require.NoError(t, db.Model(&KV{}).Where("key = ?", key).Count(&kvs).Error)
+		require.NoError(t, db.Model(&KVEvent{}).Where("key = ?", key).Count(&events).Error)
+		return kvs, events
+	}
+
+	deleted, err := d.Compact(ctx, prefix)
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+
+	// the queue is drained
+	deleted, err = d.Compact(WithNowFuncForTest(context.Background(), func() time.Time {
+		return now.Add(2 * time.Second)
+	}), prefix)
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	kvs, events := countRows(prefix + "queue")
+	require.Zero(t, kvs)
+	require.Zero(t, events)
+	kvs, events = countRows(prefix + "log")
+	require.Equal(t, int64(1), kvs)
+	require.Equal(t, int64(1), events)
+
+	// the log leaves the window, while the bucket is left to gc
+	deleted, err = d.Compact(WithNowFuncForTest(context.Background(), func() time.Time {
+		return now.Add(3 * time.Second)
+	}), prefix)
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	kvs, events = countRows(prefix + "log")
+	require.Zero(t, kvs)
+	require.Zero(t, events)
+	kvs, _ = countRows(prefix + "bucket")
+	require.Equal(t, int64(1), kvs)
+}
@@ -0,0 +1,133 @@
+package ratelimiter
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/pkg/errors"
+)
+
+const memcachedMaxRetries = 16
+
+// memcachedMaxRelativeExpiration is the longest expiration memcached accepts as relative seconds,
+// longer expirations must be given as unix timestamps.
+const memcachedMaxRelativeExpiration = 30 * 24 * time.Hour
+
+// MemcachedDriver is a Driver that uses memcached as the storage.
+// Reservations are saved by gets/cas loops, and each key expires once the bucket is full again.
+//
+// memcached has no server time, so the local time is used and the clocks of all instances should be synchronized.
+// Keys must be at most 250 bytes without spaces or control characters.
+type MemcachedDriver struct {
+	client *memcache.Client
+}
+
+// NewMemcachedDriver returns a Driver that uses memcached as the storage.
+func NewMemcachedDriver(client *memcache.Client) *MemcachedDriver {
+	return &MemcachedDriver{
+		client: client,
+	}
+}
+
+func memcachedExpiration(now, expireAt time.Time) int32 {
+	ttl := expireAt.Sub(now)
+	if ttl > memcachedMaxRelativeExpiration {
+		return int32(expireAt.Unix() + 1)
+	}
+	return int32(ttl/time.Second) + 1
+}
+
+// memcachedKeyValid reports whether memcached accepts the key, the same as the check of gomemcache.
+func memcachedKeyValid(key string) bool {
+	if len(key) > 250 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *MemcachedDriver) Reserve(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
+	if err := validateReserveRequest(req, AlgorithmGCRA); err != nil {
+		return nil, err
+	}
+	if !memcachedKeyValid(req.Key) {
+		return nil, errors.Wrapf(ErrInvalidParameters, "memcached key %q is longer than 250 bytes or contains spaces or control characters", req.Key)
+	}
+
+	for i := 0; i <= memcachedMaxRetries; i++ {
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrap(ctx.Err(), "ratelimiter: context done")
+		default:
+		}
+
+		now := time.Now().UTC() // stripMono
+		if Test {
+			nowFunc, exists := NowFuncFromContextForTest(ctx)
+			if exists {
+				now = nowFunc().UTC() // stripMono
+			}
+		}
+
+		item, err := d.client.Get(req.Key)
+		if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+			return nil, errors.Wrap(err, "ratelimiter: failed to get key")
+		}
+
+		var timeBase time.Time
+		found := item != nil
+		if found {
+			unixMicroBase, err := strconv.ParseInt(string(item.Value), 10, 64)
+			if err != nil {
+				return nil, errors.Wrap(err, "ratelimiter: failed to parse base time")
+			}
+			timeBase = time.UnixMicro(unixMicroBase)
+		}
+
+		timeToAct, ok := reserveGCRA(req, now, timeBase, found)
+		if !ok {
+			return &Reservation{
+				ReserveRequest: req,
+				OK:             false,
+				TimeToAct:      timeToAct,
+				Now:            now,
+			}, nil
+		}
+
+		value := []byte(strconv.FormatInt(timeToAct.UnixMicro(), 10))
+		expiration := memcachedExpiration(now, timeToAct.Add(time.Duration(req.Burst)*req.DurationPerToken))
+		if found {
+			item.Value = value
+			item.Expiration = expiration
+			err = d.client.CompareAndSwap(item)
+		} else {
+			err = d.client.Add(&memcache.Item{
+				Key:        req.Key,
+				Value:      value,
+				Expiration: expiration,
+			})
+		}
+		if err != nil {
+			// the key is modified, added or evicted concurrently
+			if errors.Is(err, memcache.ErrCASConflict) || errors.Is(err, memcache.ErrNotStored) {
+				continue
+			}
+			return nil, errors.Wrap(err, "ratelimiter: failed to save time to act")
+		}
+
+		return &Reservation{
+			ReserveRequest: req,
+			OK:             true,
+			TimeToAct:      timeToAct,
+			Now:            now,
+		}, nil
+	}
+
+	return nil, errors.Wrapf(ErrTooManyRetries, "memcached key %q", req.Key)
+}
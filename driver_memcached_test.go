@@ -0,0 +1,109 @@
+package ratelimiter
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"golang.org/x/sync/errgroup"
+)
+
+func newMemcachedDriverForTest(t *testing.T) *MemcachedDriver {
+	ctx := context.Background()
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "memcached:1.6-alpine",
+			ExposedPorts: []string{"11211/tcp"},
+			WaitingFor:   wait.ForListeningPort("11211/tcp"),
+		},
+		Started: true,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		container.Terminate(context.Background())
+	})
+
+	endpoint, err := container.Endpoint(ctx, "")
+	require.NoError(t, err)
+
+	return NewMemcachedDriver(memcache.New(endpoint))
+}
+
+func TestMemcachedDriver(t *testing.T) {
+	d := newMemcachedDriverForTest(t)
+
+	t.Run("ReverseWithNowAdvanced", func(t *testing.T) {
+		testReverseWithNowAdvanced(t, New(d), "TestReverseWithNowAdvanced_DriverMemcached")
+	})
+	t.Run("AllowWithNowAdvanced", func(t *testing.T) {
+		testAllowWithNowAdvanced(t, New(d), "TestAllowWithNowAdvanced_DriverMemcached")
+	})
+	t.Run("Reverse", func(t *testing.T) {
+		testReverse(t, New(d), "TestReverse_DriverMemcached")
+	})
+	t.Run("ConcurrentReserve", func(t *testing.T) {
+		limiter := New(d)
+
+		now := time.Now()
+		ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+			return now
+		})
+
+		burst := 5
+		var allowed [10]bool
+		var errG errgroup.Group
+		for i := range allowed {
+			errG.Go(func() error {
+				ok, err := limiter.Allow(ctx, &AllowRequest{
+					Key:              "TestMemcachedConcurrentReserve",
+					DurationPerToken: time.Hour,
+					Burst:            burst,
+					Tokens:           1,
+				})
+				allowed[i] = ok
+				return err
+			})
+		}
+		require.NoError(t, errG.Wait())
+
+		count := 0
+		for _, ok := range allowed {
+			if ok {
+				count++
+			}
+		}
+		require.Equal(t, burst, count)
+	})
+}
+
+func TestMemcachedExpiration(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	require.Equal(t, int32(11), memcachedExpiration(now, now.Add(10*time.Second)))
+	require.Equal(t, int32(now.Add(31*24*time.Hour).Unix()+1), memcachedExpiration(now, now.Add(31*24*time.Hour)))
+}
+
+func TestMemcachedInvalidKey(t *testing.T) {
+	// the keys are validated before any call to memcached
+	limiter := New(NewMemcachedDriver(memcache.New("127.0.0.1:0")))
+	for _, key := range []string{
+		strings.Repeat("k", 251),
+		"TestMemcachedInvalidKey with space",
+		"TestMemcachedInvalidKey\n",
+		"TestMemcachedInvalidKey\x7f",
+	} {
+		_, err := limiter.Reserve(context.Background(), &ReserveRequest{
+			Key:              key,
+			DurationPerToken: time.Second,
+			Burst:            1,
+			Tokens:           1,
+		})
+		require.ErrorIs(t, err, ErrInvalidParameters, "key: %q", key)
+	}
+	require.True(t, memcachedKeyValid(strings.Repeat("k", 250)))
+	require.True(t, memcachedKeyValid("user:{id}:/login"))
+}
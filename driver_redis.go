@@ -3,6 +3,8 @@ package ratelimiter
 import (
 	"context"
 	_ "embed"
+	"math/rand/v2"
+	"strconv"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -14,26 +16,107 @@ var errUnexpectedScriptResultFormat = errors.New("ratelimiter: unexpected script
 //go:embed embed/redis.lua
 var redisScript string
 
+//go:embed embed/redis_sliding_window_log.lua
+var redisSlidingWindowLogScript string
+
+//go:embed embed/redis_sliding_window_counter.lua
+var redisSlidingWindowCounterScript string
+
+//go:embed embed/redis_quota.lua
+var redisQuotaScript string
+
+//go:embed embed/redis_leaky_bucket_queue.lua
+var redisLeakyBucketQueueScript string
+
+//go:embed embed/redis_abandon_queue.lua
+var redisAbandonQueueScript string
+
+//go:embed embed/redis_hierarchy.lua
+var redisHierarchyScript string
+
+//go:embed embed/redis_adaptive.lua
+var redisAdaptiveScript string
+
+//go:embed embed/redis_adaptive_report.lua
+var redisAdaptiveReportScript string
+
+//go:embed embed/redis_acquire_lease.lua
+var redisAcquireLeaseScript string
+
+//go:embed embed/redis_renew_lease.lua
+var redisRenewLeaseScript string
+
+//go:embed embed/redis_import_bucket.lua
+var redisImportBucketScript string
+
+//go:embed embed/redis_reset_key_if.lua
+var redisResetKeyIfScript string
+
 type RedisDriver struct {
-	client     *redis.Client
-	scriptSha1 string
+	client                         *redis.Client
+	scriptSha1                     string
+	slidingWindowLogScriptSha1     string
+	slidingWindowCounterScriptSha1 string
+	quotaScriptSha1                string
+	leakyBucketQueueScriptSha1     string
+	abandonQueueScriptSha1         string
+	hierarchyScriptSha1            string
+	adaptiveScriptSha1             string
+	adaptiveReportScriptSha1       string
+	acquireLeaseScriptSha1         string
+	renewLeaseScriptSha1           string
+	importBucketScriptSha1         string
+	resetKeyIfScriptSha1           string
 }
 
 func InitRedisDriver(ctx context.Context, client *redis.Client) (*RedisDriver, error) {
-	res, err := client.ScriptLoad(ctx, redisScript).Result()
-	if err != nil {
-		return nil, errors.Wrap(err, "ratelimiter: failed to load lua script")
+	d := &RedisDriver{
+		client: client,
 	}
 
-	return &RedisDriver{
-		client:     client,
-		scriptSha1: res,
-	}, nil
+	for _, script := range []struct {
+		name string
+		src  string
+		sha1 *string
+	}{
+		{"", redisScript, &d.scriptSha1},
+		{"sliding window log ", redisSlidingWindowLogScript, &d.slidingWindowLogScriptSha1},
+		{"sliding window counter ", redisSlidingWindowCounterScript, &d.slidingWindowCounterScriptSha1},
+		{"quota ", redisQuotaScript, &d.quotaScriptSha1},
+		{"leaky bucket queue ", redisLeakyBucketQueueScript, &d.leakyBucketQueueScriptSha1},
+		{"abandon queue ", redisAbandonQueueScript, &d.abandonQueueScriptSha1},
+		{"hierarchy ", redisHierarchyScript, &d.hierarchyScriptSha1},
+		{"adaptive ", redisAdaptiveScript, &d.adaptiveScriptSha1},
+		{"adaptive report ", redisAdaptiveReportScript, &d.adaptiveReportScriptSha1},
+		{"acquire lease ", redisAcquireLeaseScript, &d.acquireLeaseScriptSha1},
+		{"renew lease ", redisRenewLeaseScript, &d.renewLeaseScriptSha1},
+		{"import bucket ", redisImportBucketScript, &d.importBucketScriptSha1},
+		{"reset key if ", redisResetKeyIfScript, &d.resetKeyIfScriptSha1},
+	} {
+		res, err := client.ScriptLoad(ctx, script.src).Result()
+		if err != nil {
+			return nil, errors.Wrapf(err, "ratelimiter: failed to load %slua script", script.name)
+		}
+		*script.sha1 = res
+	}
+
+	return d, nil
+}
+
+// redisUnixMicroNow returns -1 to use the redis time unless the time is provided for test.
+func redisUnixMicroNow(ctx context.Context) int64 {
+	if Test {
+		nowFunc, exists := NowFuncFromContextForTest(ctx)
+		if exists {
+			return nowFunc().UTC().UnixMicro() // stripMono
+		}
+	}
+	return -1
 }
 
 func (d *RedisDriver) Reserve(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
-	if req.Key == "" || req.DurationPerToken <= 0 || req.Burst <= 0 || req.Tokens <= 0 || req.Tokens > req.Burst {
-		return nil, errors.Wrapf(ErrInvalidParameters, "%v", req)
+	if err := validateReserveRequest(req, AlgorithmGCRA, AlgorithmSlidingWindowLog, AlgorithmSlidingWindowCounter, AlgorithmQuota, AlgorithmLeakyBucketQueue); err != nil {
+		return nil, err
 	}
 
 	select {
@@ -42,12 +125,13 @@ func (d *RedisDriver) Reserve(ctx context.Context, req *ReserveRequest) (*Reserv
 	default:
 	}
 
-	unixMicroNow := int64(-1)
-	if Test {
-		nowFunc, exists := NowFuncFromContextForTest(ctx)
-		if exists {
-			unixMicroNow = nowFunc().UTC().UnixMicro() // stripMono
-		}
+	if req.Algorithm == AlgorithmQuota {
+		return d.reserveQuota(ctx, req)
+	}
+
+	unixMicroNow := redisUnixMicroNow(ctx)
+	if req.Algorithm == AlgorithmLeakyBucketQueue {
+		return d.reserveLeakyBucketQueue(ctx, req, unixMicroNow)
 	}
 
 	args := []any{
@@ -58,7 +142,18 @@ func (d *RedisDriver) Reserve(ctx context.Context, req *ReserveRequest) (*Reserv
 		req.MaxFutureReserve.Microseconds(),
 	}
 
-	result, err := d.client.EvalSha(ctx, d.scriptSha1, []string{req.Key}, args...).Result()
+	scriptSha1 := d.scriptSha1
+	switch req.Algorithm {
+	case AlgorithmSlidingWindowLog:
+		scriptSha1 = d.slidingWindowLogScriptSha1
+		args = append(args, strconv.FormatUint(rand.Uint64(), 36))
+	case AlgorithmSlidingWindowCounter:
+		scriptSha1 = d.slidingWindowCounterScriptSha1
+	default:
+		args = append(args, req.Floor)
+	}
+
+	result, err := d.client.EvalSha(ctx, scriptSha1, []string{req.Key}, args...).Result()
 	if err != nil {
 		return nil, errors.Wrap(err, "ratelimiter: failed to execute lua script")
 	}
@@ -90,3 +185,99 @@ func (d *RedisDriver) Reserve(ctx context.Context, req *ReserveRequest) (*Reserv
 		Now:            time.UnixMicro(unixMicroNow).UTC(),
 	}, nil
 }
+
+// reserveQuota uses the local time to compute the calendar-aligned window,
+// so the clocks of all instances should be synchronized.
+func (d *RedisDriver) reserveQuota(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
+	now := time.Now().UTC() // stripMono
+	if Test {
+		nowFunc, exists := NowFuncFromContextForTest(ctx)
+		if exists {
+			now = nowFunc().UTC() // stripMono
+		}
+	}
+	now = now.Truncate(time.Microsecond)
+
+	start, end := req.Period.Window(now, req.Location)
+	args := []any{
+		req.Burst,
+		req.Tokens,
+		start.UnixMicro(),
+		end.UnixMilli(),
+	}
+
+	result, err := d.client.EvalSha(ctx, d.quotaScriptSha1, []string{req.Key}, args...).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to execute quota lua script")
+	}
+
+	res, ok := result.([]any)
+	if !ok || len(res) != 2 {
+		return nil, errors.Wrap(errUnexpectedScriptResultFormat, "length of result")
+	}
+	status, ok := res[0].(int64)
+	if !ok {
+		return nil, errors.Wrap(errUnexpectedScriptResultFormat, "status")
+	}
+	used, ok := res[1].(int64)
+	if !ok {
+		return nil, errors.Wrap(errUnexpectedScriptResultFormat, "used")
+	}
+	if status == -2 {
+		return nil, errors.Wrap(ErrInvalidParameters, "quota lua script")
+	}
+
+	return newQuotaReservation(req, now, int(used), end.UTC(), status == 0), nil
+}
+
+func (d *RedisDriver) reserveLeakyBucketQueue(ctx context.Context, req *ReserveRequest, unixMicroNow int64) (*Reservation, error) {
+	id := strconv.FormatUint(rand.Uint64(), 36)
+	args := []any{
+		req.DurationPerToken.Microseconds(),
+		req.Burst,
+		req.Tokens,
+		unixMicroNow,
+		req.MaxQueue,
+		id,
+	}
+
+	result, err := d.client.EvalSha(ctx, d.leakyBucketQueueScriptSha1, []string{req.Key}, args...).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to execute leaky bucket queue lua script")
+	}
+
+	res, ok := result.([]any)
+	if !ok || len(res) != 4 {
+		return nil, errors.Wrap(errUnexpectedScriptResultFormat, "length of result")
+	}
+	status, ok := res[0].(int64)
+	if !ok {
+		return nil, errors.Wrap(errUnexpectedScriptResultFormat, "status")
+	}
+	unixMicroToAct, ok := res[1].(int64)
+	if !ok {
+		return nil, errors.Wrap(errUnexpectedScriptResultFormat, "unixMicroToAct")
+	}
+	unixMicroNow, ok = res[2].(int64)
+	if !ok {
+		return nil, errors.Wrap(errUnexpectedScriptResultFormat, "unixMicroNow")
+	}
+	depth, ok := res[3].(int64)
+	if !ok {
+		return nil, errors.Wrap(errUnexpectedScriptResultFormat, "depth")
+	}
+	if status == -2 {
+		return nil, errors.Wrap(ErrInvalidParameters, "leaky bucket queue lua script")
+	}
+
+	return newQueueReservation(req, time.UnixMicro(unixMicroNow).UTC(), time.UnixMicro(unixMicroToAct).UTC(), status == 0, id, int(depth)), nil
+}
+
+var _ QueueDriver = (*RedisDriver)(nil)
+
+func (d *RedisDriver) AbandonQueue(ctx context.Context, key string, id string) error {
+	if err := d.client.EvalSha(ctx, d.abandonQueueScriptSha1, []string{key}, id, redisUnixMicroNow(ctx)).Err(); err != nil {
+		return errors.Wrap(err, "ratelimiter: failed to execute abandon queue lua script")
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var _ AdaptiveDriver = (*RedisDriver)(nil)
+
+// ReserveAdaptive stores the bucket of the key in a hash along with its current DurationPerToken.
+func (d *RedisDriver) ReserveAdaptive(ctx context.Context, req *AdaptiveRequest) (*Reservation, error) {
+	if err := validateAdaptiveRequest(req); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "ratelimiter: context done")
+	default:
+	}
+
+	args := []any{
+		req.Burst,
+		req.Tokens,
+		redisUnixMicroNow(ctx),
+		req.MaxFutureReserve.Microseconds(),
+		req.MinDurationPerToken.Microseconds(),
+		req.MaxDurationPerToken.Microseconds(),
+	}
+
+	result, err := d.client.EvalSha(ctx, d.adaptiveScriptSha1, []string{req.Key}, args...).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to execute adaptive lua script")
+	}
+
+	res, ok := result.([]any)
+	if !ok || len(res) != 4 {
+		return nil, errors.Wrap(errUnexpectedScriptResultFormat, "length of result")
+	}
+	status, ok := res[0].(int64)
+	if !ok {
+		return nil, errors.Wrap(errUnexpectedScriptResultFormat, "status")
+	}
+	unixMicroToAct, ok := res[1].(int64)
+	if !ok {
+		return nil, errors.Wrap(errUnexpectedScriptResultFormat, "unixMicroToAct")
+	}
+	unixMicroNow, ok := res[2].(int64)
+	if !ok {
+		return nil, errors.Wrap(errUnexpectedScriptResultFormat, "unixMicroNow")
+	}
+	microDurationPerToken, ok := res[3].(int64)
+	if !ok {
+		return nil, errors.Wrap(errUnexpectedScriptResultFormat, "durationPerToken")
+	}
+	if status == -2 {
+		return nil, errors.Wrap(ErrInvalidParameters, "adaptive lua script")
+	}
+
+	return newReservation(
+		req.reserveRequest(time.Duration(microDurationPerToken)*time.Microsecond),
+		time.UnixMicro(unixMicroNow).UTC(),
+		time.UnixMicro(unixMicroToAct).UTC(),
+		status == 0,
+	), nil
+}
+
+func (d *RedisDriver) ReportOutcome(ctx context.Context, req *AdaptiveRequest, outcome Outcome) (time.Duration, error) {
+	if err := validateAdaptiveRequest(req); err != nil {
+		return 0, err
+	}
+
+	decrease := 0
+	if outcome != OutcomeSuccess {
+		decrease = 1
+	}
+	args := []any{
+		decrease,
+		req.AdditiveIncrease,
+		req.MultiplicativeDecrease,
+		req.MinDurationPerToken.Microseconds(),
+		req.MaxDurationPerToken.Microseconds(),
+	}
+
+	result, err := d.client.EvalSha(ctx, d.adaptiveReportScriptSha1, []string{req.Key}, args...).Result()
+	if err != nil {
+		return 0, errors.Wrap(err, "ratelimiter: failed to execute adaptive report lua script")
+	}
+
+	res, ok := result.([]any)
+	if !ok || len(res) != 2 {
+		return 0, errors.Wrap(errUnexpectedScriptResultFormat, "length of result")
+	}
+	status, ok := res[0].(int64)
+	if !ok {
+		return 0, errors.Wrap(errUnexpectedScriptResultFormat, "status")
+	}
+	microDurationPerToken, ok := res[1].(int64)
+	if !ok {
+		return 0, errors.Wrap(errUnexpectedScriptResultFormat, "durationPerToken")
+	}
+	if status == -2 {
+		return 0, errors.Wrap(ErrInvalidParameters, "adaptive report lua script")
+	}
+	return time.Duration(microDurationPerToken) * time.Microsecond, nil
+}
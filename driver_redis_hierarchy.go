@@ -0,0 +1,74 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var _ HierarchicalDriver = (*RedisDriver)(nil)
+
+// ReserveHierarchy evaluates all levels in one lua script, so with Redis Cluster
+// the keys of a hierarchy must be in the same hash slot, e.g. by a hash tag like "{tenant1}:user1".
+// The levels are stored the same as AlgorithmGCRA, so a key can also be reserved alone.
+func (d *RedisDriver) ReserveHierarchy(ctx context.Context, reqs []*ReserveRequest) (*HierarchicalReservation, error) {
+	if err := validateHierarchy(reqs); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "ratelimiter: context done")
+	default:
+	}
+
+	keys := make([]string, 0, len(reqs))
+	args := make([]any, 0, 2+len(reqs)*4)
+	args = append(args, reqs[0].Tokens, redisUnixMicroNow(ctx))
+	for _, req := range reqs {
+		keys = append(keys, req.Key)
+		args = append(args,
+			req.DurationPerToken.Microseconds(),
+			req.Burst,
+			req.MaxFutureReserve.Microseconds(),
+			req.Floor,
+		)
+	}
+
+	result, err := d.client.EvalSha(ctx, d.hierarchyScriptSha1, keys, args...).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to execute hierarchy lua script")
+	}
+
+	res, ok := result.([]any)
+	if !ok || len(res) < 2 {
+		return nil, errors.Wrap(errUnexpectedScriptResultFormat, "length of result")
+	}
+	status, ok := res[0].(int64)
+	if !ok {
+		return nil, errors.Wrap(errUnexpectedScriptResultFormat, "status")
+	}
+	if status == -2 {
+		return nil, errors.Wrap(ErrInvalidParameters, "hierarchy lua script")
+	}
+	if len(res) != 2+len(reqs) {
+		return nil, errors.Wrap(errUnexpectedScriptResultFormat, "length of result")
+	}
+	unixMicroNow, ok := res[1].(int64)
+	if !ok {
+		return nil, errors.Wrap(errUnexpectedScriptResultFormat, "unixMicroNow")
+	}
+	now := time.UnixMicro(unixMicroNow).UTC()
+
+	levels := make([]*Reservation, len(reqs))
+	for i, req := range reqs {
+		unixMicroToAct, ok := res[2+i].(int64)
+		if !ok {
+			return nil, errors.Wrap(errUnexpectedScriptResultFormat, "unixMicroToAct")
+		}
+		timeToAct := time.UnixMicro(unixMicroToAct).UTC()
+		levels[i] = newReservation(req, now, timeToAct, gcraAllowed(req, now, timeToAct))
+	}
+	return newHierarchicalReservation(levels, now), nil
+}
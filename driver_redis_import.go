@@ -0,0 +1,33 @@
+package ratelimiter
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+var _ ImportDriver = (*RedisDriver)(nil)
+
+func (d *RedisDriver) ImportBucket(ctx context.Context, b BucketState) error {
+	if b.Key == "" {
+		return errors.Wrap(ErrInvalidParameters, "key of bucket")
+	}
+
+	result, err := d.client.EvalSha(ctx, d.importBucketScriptSha1, []string{b.Key}, b.TimeBase).Result()
+	if err != nil {
+		return errors.Wrap(err, "ratelimiter: failed to execute import bucket lua script")
+	}
+
+	res, ok := result.([]any)
+	if !ok || len(res) != 1 {
+		return errors.Wrap(errUnexpectedScriptResultFormat, "length of result")
+	}
+	status, ok := res[0].(int64)
+	if !ok {
+		return errors.Wrap(errUnexpectedScriptResultFormat, "status")
+	}
+	if status == -2 {
+		return errors.Wrapf(ErrInvalidParameters, "key %q is not a GCRA bucket", b.Key)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var _ SemaphoreDriver = (*RedisDriver)(nil)
+
+// redisSemaphoreKeyPrefix separates the sorted sets of the leases from the keys of the rate limits,
+// so that a key can be used by both a Semaphore and a rate limit.
+const redisSemaphoreKeyPrefix = "ratelimiter:semaphore:"
+
+func redisSemaphoreKey(key string) string {
+	return redisSemaphoreKeyPrefix + key
+}
+
+// evalLeaseScript returns false if the lease can not be acquired or renewed.
+func (d *RedisDriver) evalLeaseScript(ctx context.Context, scriptSha1 string, key string, args ...any) (time.Time, bool, error) {
+	args = append(args, redisUnixMicroNow(ctx))
+
+	result, err := d.client.EvalSha(ctx, scriptSha1, []string{key}, args...).Result()
+	if err != nil {
+		return time.Time{}, false, errors.Wrap(err, "ratelimiter: failed to execute lease lua script")
+	}
+
+	res, ok := result.([]any)
+	if !ok || len(res) != 2 {
+		return time.Time{}, false, errors.Wrap(errUnexpectedScriptResultFormat, "length of result")
+	}
+	status, ok := res[0].(int64)
+	if !ok {
+		return time.Time{}, false, errors.Wrap(errUnexpectedScriptResultFormat, "status")
+	}
+	unixMicroExpiresAt, ok := res[1].(int64)
+	if !ok {
+		return time.Time{}, false, errors.Wrap(errUnexpectedScriptResultFormat, "unixMicroExpiresAt")
+	}
+	if status == -2 {
+		return time.Time{}, false, errors.Wrap(ErrInvalidParameters, "lease lua script")
+	}
+	return time.UnixMicro(unixMicroExpiresAt).UTC(), status == 0, nil
+}
+
+func (d *RedisDriver) AcquireLease(ctx context.Context, req *LeaseRequest) (time.Time, error) {
+	expiresAt, ok, err := d.evalLeaseScript(ctx, d.acquireLeaseScriptSha1, redisSemaphoreKey(req.Key), req.ID, req.Limit, req.TTL.Microseconds())
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !ok {
+		return time.Time{}, errors.Wrapf(ErrConcurrencyLimitExceeded, "key %q", req.Key)
+	}
+	return expiresAt, nil
+}
+
+func (d *RedisDriver) RenewLease(ctx context.Context, req *LeaseRequest) (time.Time, error) {
+	expiresAt, ok, err := d.evalLeaseScript(ctx, d.renewLeaseScriptSha1, redisSemaphoreKey(req.Key), req.ID, req.TTL.Microseconds())
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !ok {
+		return time.Time{}, errors.Wrapf(ErrLeaseNotFound, "key %q, id %q", req.Key, req.ID)
+	}
+	return expiresAt, nil
+}
+
+func (d *RedisDriver) ReleaseLease(ctx context.Context, key string, id string) error {
+	if err := d.client.ZRem(ctx, redisSemaphoreKey(key), id).Err(); err != nil {
+		return errors.Wrap(err, "ratelimiter: failed to release lease")
+	}
+	return nil
+}
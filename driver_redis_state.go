@@ -0,0 +1,91 @@
+package ratelimiter
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+var _ StateDriver = (*RedisDriver)(nil)
+
+// escapeRedisPattern escapes the glob characters of a SCAN pattern.
+func escapeRedisPattern(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func (d *RedisDriver) ScanKeys(ctx context.Context, prefix string, cursor string, limit int) ([]string, string, error) {
+	if limit <= 0 {
+		return nil, "", errors.Wrapf(ErrInvalidParameters, "limit %d", limit)
+	}
+
+	var c uint64
+	if cursor != "" {
+		var err error
+		if c, err = strconv.ParseUint(cursor, 10, 64); err != nil {
+			return nil, "", errors.Wrapf(ErrInvalidParameters, "cursor %q", cursor)
+		}
+	}
+
+	keys, next, err := d.client.Scan(ctx, c, escapeRedisPattern(prefix)+"*", int64(limit)).Result()
+	if err != nil {
+		return nil, "", errors.Wrap(err, "ratelimiter: failed to scan keys")
+	}
+	if next == 0 {
+		return keys, "", nil
+	}
+	return keys, strconv.FormatUint(next, 10), nil
+}
+
+func (d *RedisDriver) GetKeyState(ctx context.Context, key string) (*KeyState, error) {
+	typ, err := d.client.Type(ctx, key).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to get key type")
+	}
+
+	switch typ {
+	case "none":
+		return nil, nil
+	case "string":
+		value, err := d.client.Get(ctx, key).Result()
+		if err == redis.Nil {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "ratelimiter: failed to get key")
+		}
+		return &KeyState{Key: key, Value: value}, nil
+	default:
+		return &KeyState{Key: key}, nil
+	}
+}
+
+// ResetKey deletes the key along with the leases of its Semaphore,
+// which are deleted separately since they may be in another slot of a cluster.
+func (d *RedisDriver) ResetKey(ctx context.Context, key string) error {
+	if err := d.client.Del(ctx, key).Err(); err != nil {
+		return errors.Wrap(err, "ratelimiter: failed to reset key")
+	}
+	if err := d.client.Del(ctx, redisSemaphoreKey(key)).Err(); err != nil {
+		return errors.Wrap(err, "ratelimiter: failed to reset semaphore")
+	}
+	return nil
+}
+
+func (d *RedisDriver) ResetKeyIf(ctx context.Context, key string, value string) (bool, error) {
+	deleted, err := d.client.EvalSha(ctx, d.resetKeyIfScriptSha1, []string{key}, value).Int()
+	if err != nil {
+		return false, errors.Wrap(err, "ratelimiter: failed to execute reset key if lua script")
+	}
+	return deleted == 1, nil
+}
@@ -0,0 +1,410 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/pkg/errors"
+)
+
+// Shard is a named Driver in a ShardedDriver.
+// The name decides which keys are routed to the shard, so it should be stable across restarts.
+type Shard struct {
+	Name   string
+	Driver Driver
+
+	seed uint64
+}
+
+// ShardError is returned by ShardedDriver when the driver of a shard fails.
+type ShardError struct {
+	Shard string
+	Err   error
+}
+
+func (e *ShardError) Error() string {
+	return fmt.Sprintf("ratelimiter: shard %q: %v", e.Shard, e.Err)
+}
+
+func (e *ShardError) Unwrap() error {
+	return e.Err
+}
+
+// ShardedDriver is a Driver that routes each key to one of its shards by rendezvous hashing,
+// so that adding or removing a shard only moves the keys that belong to it.
+// It also implements the optional QueueDriver, HierarchicalDriver, StateDriver, ImportDriver, AdaptiveDriver
+// and SemaphoreDriver by routing each key to its shard, a call fails with ErrUnsupportedAlgorithm
+// unless the driver of the shard implements the interface.
+type ShardedDriver struct {
+	mu     sync.RWMutex
+	shards []*Shard
+}
+
+// NewShardedDriver returns a ShardedDriver with the provided shards.
+func NewShardedDriver(shards ...*Shard) (*ShardedDriver, error) {
+	d := &ShardedDriver{}
+	for _, shard := range shards {
+		if err := d.AddShard(shard); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// AddShard adds a shard, only the keys that are routed to the new shard are moved.
+func (d *ShardedDriver) AddShard(shard *Shard) error {
+	if shard == nil || shard.Name == "" || shard.Driver == nil {
+		return errors.Wrap(ErrInvalidParameters, "shard must have a name and a driver")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, s := range d.shards {
+		if s.Name == shard.Name {
+			return errors.Wrapf(ErrInvalidParameters, "duplicate shard %q", shard.Name)
+		}
+	}
+
+	shard.seed = xxhash.Sum64String(shard.Name)
+	// copy on write, so that the shards can be read without holding the lock
+	shards := make([]*Shard, 0, len(d.shards)+1)
+	shards = append(shards, d.shards...)
+	d.shards = append(shards, shard)
+	return nil
+}
+
+// RemoveShard removes the shard with the provided name, only the keys of the removed shard are moved.
+func (d *ShardedDriver) RemoveShard(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, s := range d.shards {
+		if s.Name == name {
+			shards := make([]*Shard, 0, len(d.shards)-1)
+			shards = append(shards, d.shards[:i]...)
+			d.shards = append(shards, d.shards[i+1:]...)
+			return nil
+		}
+	}
+	return errors.Errorf("ratelimiter: shard %q not found", name)
+}
+
+// ShardFor returns the shard the key is routed to, or nil if there are no shards.
+func (d *ShardedDriver) ShardFor(key string) *Shard {
+	d.mu.RLock()
+	shards := d.shards
+	d.mu.RUnlock()
+
+	keyHash := xxhash.Sum64String(key)
+
+	var selected *Shard
+	var maxWeight uint64
+	for _, shard := range shards {
+		weight := mix64(shard.seed ^ keyHash)
+		if selected == nil || weight > maxWeight {
+			selected, maxWeight = shard, weight
+		}
+	}
+	return selected
+}
+
+func (d *ShardedDriver) shardFor(key string) (*Shard, error) {
+	shard := d.ShardFor(key)
+	if shard == nil {
+		return nil, errors.New("ratelimiter: no shards available")
+	}
+	return shard, nil
+}
+
+func (d *ShardedDriver) Reserve(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
+	if err := validateReserveRequest(req); err != nil {
+		return nil, err
+	}
+
+	shard, err := d.shardFor(req.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := shard.Driver.Reserve(ctx, req)
+	if err != nil {
+		return nil, &ShardError{Shard: shard.Name, Err: err}
+	}
+	return r, nil
+}
+
+var (
+	_ QueueDriver        = (*ShardedDriver)(nil)
+	_ HierarchicalDriver = (*ShardedDriver)(nil)
+	_ StateDriver        = (*ShardedDriver)(nil)
+	_ ImportDriver       = (*ShardedDriver)(nil)
+	_ AdaptiveDriver     = (*ShardedDriver)(nil)
+	_ SemaphoreDriver    = (*ShardedDriver)(nil)
+)
+
+// ReserveHierarchy reserves the levels on their shard, all levels must be routed to the same shard
+// since a hierarchy is only atomic within a driver.
+func (d *ShardedDriver) ReserveHierarchy(ctx context.Context, reqs []*ReserveRequest) (*HierarchicalReservation, error) {
+	if err := validateHierarchy(reqs); err != nil {
+		return nil, err
+	}
+
+	shard, err := d.shardFor(reqs[0].Key)
+	if err != nil {
+		return nil, err
+	}
+	for _, req := range reqs[1:] {
+		if s := d.ShardFor(req.Key); s != shard {
+			return nil, errors.Wrapf(ErrInvalidParameters, "levels of a hierarchy are routed to shards %q and %q", shard.Name, s.Name)
+		}
+	}
+
+	hd, ok := shard.Driver.(HierarchicalDriver)
+	if !ok {
+		return nil, errors.Wrap(ErrUnsupportedAlgorithm, "driver does not support hierarchical limits")
+	}
+	hr, err := hd.ReserveHierarchy(ctx, reqs)
+	if err != nil {
+		return nil, &ShardError{Shard: shard.Name, Err: err}
+	}
+	return hr, nil
+}
+
+// AbandonQueue abandons the reservation on the shard of the key,
+// so it must not be called while the shards are changing.
+func (d *ShardedDriver) AbandonQueue(ctx context.Context, key string, id string) error {
+	shard, err := d.shardFor(key)
+	if err != nil {
+		return err
+	}
+
+	qd, ok := shard.Driver.(QueueDriver)
+	if !ok {
+		return errors.Wrapf(ErrUnsupportedAlgorithm, "%v", AlgorithmLeakyBucketQueue)
+	}
+	if err := qd.AbandonQueue(ctx, key, id); err != nil {
+		return &ShardError{Shard: shard.Name, Err: err}
+	}
+	return nil
+}
+
+// ScanKeys scans the shards one after another, the cursor is the quoted name of the shard followed by its own cursor.
+// The keys that are left on a shard they are no longer routed to are skipped,
+// so that the returned keys can be inspected and reset through the ShardedDriver.
+func (d *ShardedDriver) ScanKeys(ctx context.Context, prefix string, cursor string, limit int) ([]string, string, error) {
+	d.mu.RLock()
+	shards := d.shards
+	d.mu.RUnlock()
+
+	if len(shards) == 0 {
+		return nil, "", errors.New("ratelimiter: no shards available")
+	}
+
+	i, shardCursor := 0, ""
+	if cursor != "" {
+		quoted, err := strconv.QuotedPrefix(cursor)
+		if err != nil {
+			return nil, "", errors.Wrapf(ErrInvalidParameters, "invalid cursor %q", cursor)
+		}
+		name, _ := strconv.Unquote(quoted)
+		i = -1
+		for j, shard := range shards {
+			if shard.Name == name {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			return nil, "", errors.Wrapf(ErrInvalidParameters, "shard %q of the cursor not found", name)
+		}
+		shardCursor = cursor[len(quoted):]
+	}
+
+	shard := shards[i]
+	sd, ok := shard.Driver.(StateDriver)
+	if !ok {
+		return nil, "", &ShardError{Shard: shard.Name, Err: errors.Wrap(ErrUnsupportedAlgorithm, "driver does not support key state")}
+	}
+	keys, next, err := sd.ScanKeys(ctx, prefix, shardCursor, limit)
+	if err != nil {
+		return nil, "", &ShardError{Shard: shard.Name, Err: err}
+	}
+
+	routed := keys[:0]
+	for _, key := range keys {
+		if d.ShardFor(key) == shard {
+			routed = append(routed, key)
+		}
+	}
+
+	switch {
+	case next != "":
+		next = strconv.Quote(shard.Name) + next
+	case i+1 < len(shards):
+		next = strconv.Quote(shards[i+1].Name)
+	}
+	return routed, next, nil
+}
+
+func (d *ShardedDriver) stateDriverFor(key string) (*Shard, StateDriver, error) {
+	shard, err := d.shardFor(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	sd, ok := shard.Driver.(StateDriver)
+	if !ok {
+		return nil, nil, &ShardError{Shard: shard.Name, Err: errors.Wrap(ErrUnsupportedAlgorithm, "driver does not support key state")}
+	}
+	return shard, sd, nil
+}
+
+func (d *ShardedDriver) GetKeyState(ctx context.Context, key string) (*KeyState, error) {
+	shard, sd, err := d.stateDriverFor(key)
+	if err != nil {
+		return nil, err
+	}
+	state, err := sd.GetKeyState(ctx, key)
+	if err != nil {
+		return nil, &ShardError{Shard: shard.Name, Err: err}
+	}
+	return state, nil
+}
+
+func (d *ShardedDriver) ResetKey(ctx context.Context, key string) error {
+	shard, sd, err := d.stateDriverFor(key)
+	if err != nil {
+		return err
+	}
+	if err := sd.ResetKey(ctx, key); err != nil {
+		return &ShardError{Shard: shard.Name, Err: err}
+	}
+	return nil
+}
+
+func (d *ShardedDriver) ResetKeyIf(ctx context.Context, key string, value string) (bool, error) {
+	shard, sd, err := d.stateDriverFor(key)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := sd.ResetKeyIf(ctx, key, value)
+	if err != nil {
+		return false, &ShardError{Shard: shard.Name, Err: err}
+	}
+	return deleted, nil
+}
+
+// ImportBucket imports the bucket into the shard its key is routed to.
+func (d *ShardedDriver) ImportBucket(ctx context.Context, b BucketState) error {
+	shard, err := d.shardFor(b.Key)
+	if err != nil {
+		return err
+	}
+	id, ok := shard.Driver.(ImportDriver)
+	if !ok {
+		return &ShardError{Shard: shard.Name, Err: errors.Wrap(ErrUnsupportedAlgorithm, "driver does not support importing buckets")}
+	}
+	if err := id.ImportBucket(ctx, b); err != nil {
+		return &ShardError{Shard: shard.Name, Err: err}
+	}
+	return nil
+}
+
+func (d *ShardedDriver) adaptiveDriverFor(key string) (*Shard, AdaptiveDriver, error) {
+	shard, err := d.shardFor(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	ad, ok := shard.Driver.(AdaptiveDriver)
+	if !ok {
+		return nil, nil, &ShardError{Shard: shard.Name, Err: errors.Wrap(ErrUnsupportedAlgorithm, "driver does not support adaptive limits")}
+	}
+	return shard, ad, nil
+}
+
+func (d *ShardedDriver) ReserveAdaptive(ctx context.Context, req *AdaptiveRequest) (*Reservation, error) {
+	shard, ad, err := d.adaptiveDriverFor(req.Key)
+	if err != nil {
+		return nil, err
+	}
+	r, err := ad.ReserveAdaptive(ctx, req)
+	if err != nil {
+		return nil, &ShardError{Shard: shard.Name, Err: err}
+	}
+	return r, nil
+}
+
+func (d *ShardedDriver) ReportOutcome(ctx context.Context, req *AdaptiveRequest, outcome Outcome) (time.Duration, error) {
+	shard, ad, err := d.adaptiveDriverFor(req.Key)
+	if err != nil {
+		return 0, err
+	}
+	durationPerToken, err := ad.ReportOutcome(ctx, req, outcome)
+	if err != nil {
+		return 0, &ShardError{Shard: shard.Name, Err: err}
+	}
+	return durationPerToken, nil
+}
+
+func (d *ShardedDriver) semaphoreDriverFor(key string) (*Shard, SemaphoreDriver, error) {
+	shard, err := d.shardFor(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	sd, ok := shard.Driver.(SemaphoreDriver)
+	if !ok {
+		return nil, nil, &ShardError{Shard: shard.Name, Err: errors.Wrap(ErrUnsupportedAlgorithm, "driver does not support semaphores")}
+	}
+	return shard, sd, nil
+}
+
+func (d *ShardedDriver) AcquireLease(ctx context.Context, req *LeaseRequest) (time.Time, error) {
+	shard, sd, err := d.semaphoreDriverFor(req.Key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	expiresAt, err := sd.AcquireLease(ctx, req)
+	if err != nil {
+		return time.Time{}, &ShardError{Shard: shard.Name, Err: err}
+	}
+	return expiresAt, nil
+}
+
+func (d *ShardedDriver) RenewLease(ctx context.Context, req *LeaseRequest) (time.Time, error) {
+	shard, sd, err := d.semaphoreDriverFor(req.Key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	expiresAt, err := sd.RenewLease(ctx, req)
+	if err != nil {
+		return time.Time{}, &ShardError{Shard: shard.Name, Err: err}
+	}
+	return expiresAt, nil
+}
+
+// ReleaseLease releases the lease on the shard of the key,
+// so it must not be called while the shards are changing.
+func (d *ShardedDriver) ReleaseLease(ctx context.Context, key string, id string) error {
+	shard, sd, err := d.semaphoreDriverFor(key)
+	if err != nil {
+		return err
+	}
+	if err := sd.ReleaseLease(ctx, key, id); err != nil {
+		return &ShardError{Shard: shard.Name, Err: err}
+	}
+	return nil
+}
+
+// mix64 is the finalizer of MurmurHash3, it spreads the combined hashes of shard and key evenly.
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
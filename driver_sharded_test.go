@@ -0,0 +1,236 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func newShardForTest(name string, counts map[string]int) *Shard {
+	return &Shard{
+		Name: name,
+		Driver: DriverFunc(func(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
+			counts[name]++
+			return &Reservation{ReserveRequest: req, OK: true}, nil
+		}),
+	}
+}
+
+func TestShardedDriver(t *testing.T) {
+	counts := map[string]int{}
+	d, err := NewShardedDriver(
+		newShardForTest("a", counts),
+		newShardForTest("b", counts),
+		newShardForTest("c", counts),
+	)
+	require.NoError(t, err)
+
+	keys := make([]string, 3000)
+	before := map[string]string{}
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		before[keys[i]] = d.ShardFor(keys[i]).Name
+
+		_, err := d.Reserve(context.Background(), &ReserveRequest{
+			Key:              keys[i],
+			DurationPerToken: time.Second,
+			Burst:            1,
+			Tokens:           1,
+		})
+		require.NoError(t, err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		require.InDelta(t, 1000, counts[name], 150, "shard %s", name)
+	}
+
+	require.ErrorContains(t, d.AddShard(newShardForTest("a", counts)), `duplicate shard "a"`)
+	require.NoError(t, d.AddShard(newShardForTest("d", counts)))
+
+	moved := 0
+	for _, key := range keys {
+		after := d.ShardFor(key).Name
+		if after != before[key] {
+			require.Equal(t, "d", after, "keys should only move to the new shard")
+			moved++
+		}
+	}
+	require.InDelta(t, 750, moved, 150)
+
+	require.NoError(t, d.RemoveShard("d"))
+	for _, key := range keys {
+		require.Equal(t, before[key], d.ShardFor(key).Name)
+	}
+	require.ErrorContains(t, d.RemoveShard("d"), `shard "d" not found`)
+}
+
+func TestShardedDriver_ShardError(t *testing.T) {
+	errBackend := errors.New("backend down")
+	d, err := NewShardedDriver(&Shard{
+		Name: "broken",
+		Driver: DriverFunc(func(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
+			return nil, errBackend
+		}),
+	})
+	require.NoError(t, err)
+
+	_, err = d.Reserve(context.Background(), &ReserveRequest{
+		Key:              "TestShardedDriver_ShardError",
+		DurationPerToken: time.Second,
+		Burst:            1,
+		Tokens:           1,
+	})
+	var shardErr *ShardError
+	require.ErrorAs(t, err, &shardErr)
+	require.Equal(t, "broken", shardErr.Shard)
+	require.ErrorIs(t, err, errBackend)
+	require.EqualError(t, err, `ratelimiter: shard "broken": backend down`)
+}
+
+func TestShardedDriver_OptionalInterfaces(t *testing.T) {
+	first, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+	d, err := NewShardedDriver(
+		&Shard{Name: "first", Driver: first},
+		&Shard{Name: "second", Driver: secondRedisDriver(t)},
+	)
+	require.NoError(t, err)
+	limiter := New(d)
+
+	// the reservations are abandoned on the shard of their key
+	for i := range 4 {
+		testLeakyBucketQueue(t, limiter, fmt.Sprintf("TestShardedDriver_OptionalInterfaces:queue:%d", i))
+	}
+
+	// find a level routed to the same shard as the global level and another routed to the other shard
+	global := &ReserveRequest{Key: "TestShardedDriver_OptionalInterfaces:global", DurationPerToken: time.Second, Burst: 2, Tokens: 1}
+	var same, other *ReserveRequest
+	for i := 0; same == nil || other == nil; i++ {
+		req := &ReserveRequest{Key: fmt.Sprintf("TestShardedDriver_OptionalInterfaces:user:%d", i), DurationPerToken: time.Second, Burst: 1, Tokens: 1}
+		if d.ShardFor(req.Key) == d.ShardFor(global.Key) {
+			same = req
+		} else {
+			other = req
+		}
+	}
+
+	hr, err := limiter.ReserveHierarchy(context.Background(), global, same)
+	require.NoError(t, err)
+	require.True(t, hr.OK)
+	hr, err = limiter.ReserveHierarchy(context.Background(), global, same)
+	require.NoError(t, err)
+	require.Equal(t, 1, hr.DeniedLevel)
+
+	_, err = limiter.ReserveHierarchy(context.Background(), global, other)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+
+	counts := map[string]int{}
+	unsupported, err := NewShardedDriver(newShardForTest("a", counts))
+	require.NoError(t, err)
+	_, err = New(unsupported).ReserveHierarchy(context.Background(), global)
+	require.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+	err = New(unsupported).Abandon(context.Background(), &Reservation{ReserveRequest: &ReserveRequest{Key: "k", Algorithm: AlgorithmLeakyBucketQueue}, OK: true, QueueID: "1"})
+	require.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+}
+
+func TestShardedDriver_PerKeyInterfaces(t *testing.T) {
+	ctx := context.Background()
+	first, err := InitRedisDriver(ctx, redisCli)
+	require.NoError(t, err)
+	second := secondRedisDriver(t)
+	d, err := NewShardedDriver(
+		&Shard{Name: "first", Driver: first},
+		&Shard{Name: "second", Driver: second},
+	)
+	require.NoError(t, err)
+	limiter := New(d)
+
+	prefix := "TestShardedDriver_PerKeyInterfaces:"
+	want := []string{}
+	for i := range 10 {
+		key := fmt.Sprintf("%sbucket:%d", prefix, i)
+		want = append(want, key)
+		_, err := limiter.Reserve(ctx, &ReserveRequest{Key: key, DurationPerToken: time.Minute, Burst: 10, Tokens: 1})
+		require.NoError(t, err)
+	}
+
+	// a key left on a shard it is no longer routed to is not listed
+	stale := prefix + "stale"
+	staleDriver := Driver(first)
+	if d.ShardFor(stale).Name == "first" {
+		staleDriver = second
+	}
+	_, err = staleDriver.Reserve(ctx, &ReserveRequest{Key: stale, DurationPerToken: time.Minute, Burst: 10, Tokens: 1})
+	require.NoError(t, err)
+
+	var keys []string
+	cursor := ""
+	for {
+		batch, next, err := d.ScanKeys(ctx, prefix, cursor, 3)
+		require.NoError(t, err)
+		keys = append(keys, batch...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	require.ElementsMatch(t, want, keys)
+
+	_, _, err = d.ScanKeys(ctx, prefix, strconv.Quote("removed"), 3)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+
+	state, err := d.GetKeyState(ctx, want[0])
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	require.NoError(t, d.ResetKey(ctx, want[0]))
+	state, err = d.GetKeyState(ctx, want[0])
+	require.NoError(t, err)
+	require.Nil(t, state)
+
+	state, err = d.GetKeyState(ctx, want[1])
+	require.NoError(t, err)
+	deleted, err := d.ResetKeyIf(ctx, want[1], state.Value)
+	require.NoError(t, err)
+	require.True(t, deleted)
+
+	timeBase := time.Now().Add(time.Hour).UnixMicro()
+	imported := prefix + "imported"
+	require.NoError(t, d.ImportBucket(ctx, BucketState{Key: imported, TimeBase: timeBase}))
+	state, err = d.ShardFor(imported).Driver.(StateDriver).GetKeyState(ctx, imported)
+	require.NoError(t, err)
+	require.Equal(t, strconv.FormatInt(timeBase, 10), state.Value)
+
+	sem := NewSemaphore(d)
+	lease, err := sem.Acquire(ctx, prefix+"semaphore", 1, time.Minute)
+	require.NoError(t, err)
+	_, err = sem.Acquire(ctx, prefix+"semaphore", 1, time.Minute)
+	require.ErrorIs(t, err, ErrConcurrencyLimitExceeded)
+	require.NoError(t, lease.Renew(ctx, time.Minute))
+	require.NoError(t, lease.Release(ctx))
+
+	adaptive := NewAdaptiveLimiter(d)
+	req := &AdaptiveRequest{
+		Key:                    prefix + "adaptive",
+		Burst:                  1,
+		Tokens:                 1,
+		MinDurationPerToken:    time.Millisecond,
+		MaxDurationPerToken:    time.Second,
+		AdditiveIncrease:       1,
+		MultiplicativeDecrease: 0.5,
+	}
+	r, err := adaptive.Reserve(ctx, req)
+	require.NoError(t, err)
+	require.True(t, r.OK)
+
+	counts := map[string]int{}
+	unsupported, err := NewShardedDriver(newShardForTest("a", counts))
+	require.NoError(t, err)
+	_, err = unsupported.GetKeyState(ctx, "k")
+	require.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+	_, err = NewSemaphore(unsupported).Acquire(ctx, "k", 1, time.Minute)
+	require.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+}
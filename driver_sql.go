@@ -0,0 +1,197 @@
+package ratelimiter
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type SQLDialect string
+
+const (
+	SQLDialectPostgres SQLDialect = "postgres"
+	SQLDialectMySQL    SQLDialect = "mysql"
+	SQLDialectSQLite   SQLDialect = "sqlite"
+)
+
+type sqlQueries struct {
+	createTable string
+	selectKV    string
+	selectNow   string // empty if the local time is used
+	insertKV    string
+	updateKV    string
+}
+
+var sqlDialectQueries = map[SQLDialect]sqlQueries{
+	SQLDialectPostgres: {
+		createTable: `CREATE TABLE IF NOT EXISTS kvs ("key" text NOT NULL, "value" text NOT NULL, PRIMARY KEY ("key"))`,
+		selectKV:    `SELECT "value" FROM kvs WHERE "key" = $1 FOR UPDATE`,
+		selectNow:   `SELECT (EXTRACT(EPOCH FROM clock_timestamp()) * 1000000)::BIGINT`,
+		insertKV:    `INSERT INTO kvs ("key", "value") VALUES ($1, $2)`,
+		updateKV:    `UPDATE kvs SET "value" = $1 WHERE "key" = $2`,
+	},
+	SQLDialectMySQL: {
+		createTable: "CREATE TABLE IF NOT EXISTS kvs (`key` varchar(191) NOT NULL, `value` longtext NOT NULL, PRIMARY KEY (`key`))",
+		selectKV:    "SELECT `value` FROM kvs WHERE `key` = ? FOR UPDATE",
+		selectNow:   "SELECT CAST(UNIX_TIMESTAMP(SYSDATE(6)) * 1000000 AS SIGNED)",
+		insertKV:    "INSERT INTO kvs (`key`, `value`) VALUES (?, ?)",
+		updateKV:    "UPDATE kvs SET `value` = ? WHERE `key` = ?",
+	},
+	// SQLite does not support row locks, writers are serialized by the database lock instead.
+	// Its time is of milliseconds and it shares the clock of the host, so the local time is used instead.
+	// It is recommended to open the database with immediate transactions (e.g. `_txlock=immediate`)
+	// to avoid SQLITE_BUSY errors under contention.
+	SQLDialectSQLite: {
+		createTable: `CREATE TABLE IF NOT EXISTS kvs ("key" text NOT NULL, "value" text NOT NULL, PRIMARY KEY ("key"))`,
+		selectKV:    `SELECT "value" FROM kvs WHERE "key" = ?`,
+		insertKV:    `INSERT INTO kvs ("key", "value") VALUES (?, ?)`,
+		updateKV:    `UPDATE kvs SET "value" = ? WHERE "key" = ?`,
+	},
+}
+
+// SQLDriver is a Driver that uses database/sql as the storage.
+// It shares the kvs table layout with GormDriver.
+type SQLDriver struct {
+	db      *sql.DB
+	queries sqlQueries
+}
+
+// NewSQLDriver returns a Driver that uses database/sql as the storage.
+// Sometimes you may need to create the kvs table, you can use `InitSQLDriver` instead.
+func NewSQLDriver(db *sql.DB, dialect SQLDialect) (*SQLDriver, error) {
+	queries, ok := sqlDialectQueries[dialect]
+	if !ok {
+		return nil, errors.Errorf("ratelimiter: unsupported sql dialect %q", dialect)
+	}
+
+	return &SQLDriver{
+		db:      db,
+		queries: queries,
+	}, nil
+}
+
+// InitSQLDriver initializes a SQLDriver with the provided DB and creates the kvs table if not exists.
+// Sometimes you may not need to create the kvs table, you can use `NewSQLDriver` instead.
+func InitSQLDriver(ctx context.Context, db *sql.DB, dialect SQLDialect) (*SQLDriver, error) {
+	d, err := NewSQLDriver(db, dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.ExecContext(ctx, d.queries.createTable); err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to create kvs table")
+	}
+
+	return d, nil
+}
+
+func (d *SQLDriver) Reserve(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
+	return d.reserve(ctx, req, 0)
+}
+
+func (d *SQLDriver) reserve(ctx context.Context, req *ReserveRequest, idx int) (*Reservation, error) {
+	if err := validateReserveRequest(req, AlgorithmGCRA); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "ratelimiter: context done")
+	default:
+	}
+
+	var now time.Time
+	if Test {
+		nowFunc, exists := NowFuncFromContextForTest(ctx)
+		if exists {
+			now = nowFunc().UTC() // stripMono
+		}
+	}
+
+	var timeToAct time.Time
+	var ok bool
+
+	err := d.transaction(ctx, func(tx *sql.Tx) error {
+		var value sql.NullString
+		if err := tx.QueryRowContext(ctx, d.queries.selectKV, req.Key).Scan(&value); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return errors.Wrap(err, "ratelimiter: failed to get kv")
+		}
+
+		// query the db time after the row is locked
+		if now.IsZero() && d.queries.selectNow == "" {
+			now = time.Now().UTC().Truncate(time.Microsecond)
+		} else if now.IsZero() {
+			var unixMicroNow int64
+			if err := tx.QueryRowContext(ctx, d.queries.selectNow).Scan(&unixMicroNow); err != nil {
+				return errors.Wrap(err, "ratelimiter: failed to get db time")
+			}
+			now = time.UnixMicro(unixMicroNow).UTC()
+		}
+
+		var timeBase time.Time
+		found := value.Valid
+		if found {
+			unixMicroBase, err := strconv.ParseInt(value.String, 10, 64)
+			if err != nil {
+				return errors.Wrap(err, "ratelimiter: failed to parse base time")
+			}
+			timeBase = time.UnixMicro(unixMicroBase)
+		}
+
+		timeToAct, ok = reserveGCRA(req, now, timeBase, found)
+		if !ok {
+			return nil
+		}
+
+		value.String = strconv.FormatInt(timeToAct.UnixMicro(), 10)
+		if !found {
+			if _, err := tx.ExecContext(ctx, d.queries.insertKV, req.Key, value.String); err != nil {
+				return errors.Wrap(err, "ratelimiter: failed to create kv")
+			}
+			return nil
+		}
+
+		if _, err := tx.ExecContext(ctx, d.queries.updateKV, value.String, req.Key); err != nil {
+			return errors.Wrap(err, "ratelimiter: failed to save time to act")
+		}
+		return nil
+	})
+	if err != nil {
+		// retry once if duplicate key error
+		if idx == 0 && isDuplicateKeyError(err) {
+			return d.reserve(ctx, req, idx+1)
+		}
+		return nil, err
+	}
+
+	return &Reservation{
+		ReserveRequest: req,
+		OK:             ok,
+		TimeToAct:      timeToAct,
+		Now:            now,
+	}, nil
+}
+
+func (d *SQLDriver) transaction(ctx context.Context, fc func(tx *sql.Tx) error) (xerr error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "ratelimiter: failed to begin transaction")
+	}
+	defer func() {
+		if xerr != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err := fc(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "ratelimiter: failed to commit transaction")
+	}
+	return nil
+}
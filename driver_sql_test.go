@@ -0,0 +1,147 @@
+package ratelimiter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+	testmysql "github.com/testcontainers/testcontainers-go/modules/mysql"
+	"golang.org/x/sync/errgroup"
+	_ "modernc.org/sqlite"
+)
+
+func newSQLDriverForTest(t testing.TB) *SQLDriver {
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+
+	d, err := InitSQLDriver(context.Background(), sqlDB, SQLDialectPostgres)
+	require.NoError(t, err)
+	return d
+}
+
+func newSQLiteDriverForTest(t testing.TB) *SQLDriver {
+	dsn := fmt.Sprintf("file:%s?_txlock=immediate&_pragma=busy_timeout(5000)&_pragma=synchronous(off)", filepath.Join(t.TempDir(), "ratelimiter.db"))
+	sqlDB, err := sql.Open("sqlite", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		sqlDB.Close()
+	})
+
+	d, err := InitSQLDriver(context.Background(), sqlDB, SQLDialectSQLite)
+	require.NoError(t, err)
+	return d
+}
+
+func newMySQLDriverForTest(t testing.TB) *SQLDriver {
+	ctx := context.Background()
+	container, err := testmysql.Run(ctx, "mysql:8.0.36")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		container.Terminate(context.Background())
+	})
+
+	dsn, err := container.ConnectionString(ctx)
+	require.NoError(t, err)
+	sqlDB, err := sql.Open("mysql", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		sqlDB.Close()
+	})
+
+	d, err := InitSQLDriver(ctx, sqlDB, SQLDialectMySQL)
+	require.NoError(t, err)
+	return d
+}
+
+func TestNewSQLDriver_UnsupportedDialect(t *testing.T) {
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+
+	_, err = NewSQLDriver(sqlDB, SQLDialect("oracle"))
+	require.ErrorContains(t, err, `unsupported sql dialect "oracle"`)
+}
+
+func TestReverseWithNowAdvanced_DriverSQL(t *testing.T) {
+	testReverseWithNowAdvanced(t, New(newSQLDriverForTest(t)), "TestReverseWithNowAdvanced_DriverSQL")
+}
+
+func TestAllowWithNowAdvanced_DriverSQL(t *testing.T) {
+	testAllowWithNowAdvanced(t, New(newSQLDriverForTest(t)), "TestAllowWithNowAdvanced_DriverSQL")
+}
+
+func TestReverse_DriverSQL(t *testing.T) {
+	testReverse(t, New(newSQLDriverForTest(t)), "TestReverse_DriverSQL")
+}
+
+func TestSQLDriver_SQLite(t *testing.T) {
+	t.Run("ReverseWithNowAdvanced", func(t *testing.T) {
+		testReverseWithNowAdvanced(t, New(newSQLiteDriverForTest(t)), "TestReverseWithNowAdvanced_DriverSQLite")
+	})
+	t.Run("AllowWithNowAdvanced", func(t *testing.T) {
+		testAllowWithNowAdvanced(t, New(newSQLiteDriverForTest(t)), "TestAllowWithNowAdvanced_DriverSQLite")
+	})
+	t.Run("Reverse", func(t *testing.T) {
+		testReverse(t, New(newSQLiteDriverForTest(t)), "TestReverse_DriverSQLite")
+	})
+	t.Run("ConcurrentReserve", func(t *testing.T) {
+		testSQLConcurrentReserve(t, newSQLiteDriverForTest(t), "TestSQLConcurrentReserve_DriverSQLite")
+	})
+}
+
+func TestSQLDriver_MySQL(t *testing.T) {
+	d := newMySQLDriverForTest(t)
+
+	t.Run("ReverseWithNowAdvanced", func(t *testing.T) {
+		testReverseWithNowAdvanced(t, New(d), "TestReverseWithNowAdvanced_DriverMySQL")
+	})
+	t.Run("AllowWithNowAdvanced", func(t *testing.T) {
+		testAllowWithNowAdvanced(t, New(d), "TestAllowWithNowAdvanced_DriverMySQL")
+	})
+	t.Run("Reverse", func(t *testing.T) {
+		testReverse(t, New(d), "TestReverse_DriverMySQL")
+	})
+	t.Run("ConcurrentReserve", func(t *testing.T) {
+		testSQLConcurrentReserve(t, d, "TestSQLConcurrentReserve_DriverMySQL")
+	})
+}
+
+// testSQLConcurrentReserve checks that the row lock, or the database lock of SQLite,
+// serializes the reservations of a new key.
+func testSQLConcurrentReserve(t *testing.T, d *SQLDriver, key string) {
+	limiter := New(d)
+
+	now := time.Now()
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+
+	burst := 5
+	var allowed [10]bool
+	var errG errgroup.Group
+	for i := range allowed {
+		errG.Go(func() error {
+			ok, err := limiter.Allow(ctx, &AllowRequest{
+				Key:              key,
+				DurationPerToken: time.Hour,
+				Burst:            burst,
+				Tokens:           1,
+			})
+			allowed[i] = ok
+			return err
+		})
+	}
+	require.NoError(t, errG.Wait())
+
+	count := 0
+	for _, ok := range allowed {
+		if ok {
+			count++
+		}
+	}
+	require.Equal(t, burst, count)
+}
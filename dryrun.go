@@ -0,0 +1,108 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ShadowKeyPrefix prefixes the keys of the shadow buckets of the dry-run calls,
+// so that a limit is evaluated without consuming the real tokens.
+const ShadowKeyPrefix = "shadow:"
+
+// WithDryRun evaluates every call of the RateLimiter against a shadow bucket and always allows it,
+// the would-be denials are reported to the observers with Observation.DryRun set.
+func WithDryRun() Option {
+	return func(lim *RateLimiter) {
+		lim.dryRun = true
+	}
+}
+
+func shadowRequest(req *ReserveRequest) *ReserveRequest {
+	shadow := *req
+	shadow.Key = ShadowKeyPrefix + req.Key
+	return &shadow
+}
+
+// dryRunReservation returns the allowed reservation of a dry-run call from the reservation of the shadow bucket,
+// which is nil if the shadow bucket failed.
+func dryRunReservation(ctx context.Context, req *ReserveRequest, shadow *Reservation) *Reservation {
+	if shadow == nil {
+		now := time.Now().UTC() // stripMono
+		if Test {
+			nowFunc, exists := NowFuncFromContextForTest(ctx)
+			if exists {
+				now = nowFunc().UTC() // stripMono
+			}
+		}
+		return newReservation(req, now, now, true)
+	}
+
+	r := *shadow
+	r.ReserveRequest = req
+	r.OK = true
+	r.TimeToAct = r.Now
+	// nothing is queued for the caller
+	r.QueueID = ""
+	return &r
+}
+
+// reserveDryRun reserves the request against the shadow bucket, the call is allowed even if the driver fails,
+// but the invalid requests still fail, since they would fail once the limit is enforced.
+func (lim *RateLimiter) reserveDryRun(ctx context.Context, policy string, req *ReserveRequest) (*Reservation, error) {
+	shadowReq := shadowRequest(req)
+	start := time.Now()
+	shadow, err := lim.driver.Reserve(ctx, shadowReq)
+	lim.observe(ctx, &Observation{
+		Policy:      policy,
+		Request:     shadowReq,
+		Reservation: shadow,
+		Err:         err,
+		Driver:      lim.driver,
+		Latency:     time.Since(start),
+		DryRun:      true,
+	})
+	if errors.Is(err, ErrInvalidParameters) {
+		return nil, err
+	}
+	return dryRunReservation(ctx, req, shadow), nil
+}
+
+// reserveHierarchyDryRun reserves the levels against their shadow buckets and always allows them.
+func (lim *RateLimiter) reserveHierarchyDryRun(ctx context.Context, d HierarchicalDriver, reqs []*ReserveRequest) (*HierarchicalReservation, error) {
+	shadowReqs := make([]*ReserveRequest, len(reqs))
+	for i, req := range reqs {
+		shadowReqs[i] = shadowRequest(req)
+	}
+
+	start := time.Now()
+	shadow, err := d.ReserveHierarchy(ctx, shadowReqs)
+	latency := time.Since(start)
+	for i, req := range shadowReqs {
+		o := &Observation{
+			Request: req,
+			Err:     err,
+			Driver:  lim.driver,
+			Latency: latency,
+			DryRun:  true,
+		}
+		if err == nil {
+			o.Reservation = shadow.Levels[i]
+		}
+		lim.observe(ctx, o)
+	}
+	if errors.Is(err, ErrInvalidParameters) {
+		return nil, err
+	}
+
+	levels := make([]*Reservation, len(reqs))
+	for i, req := range reqs {
+		var r *Reservation
+		if err == nil {
+			r = shadow.Levels[i]
+		}
+		levels[i] = dryRunReservation(ctx, req, r)
+	}
+	return newHierarchicalReservation(levels, levels[0].Now), nil
+}
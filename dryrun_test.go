@@ -0,0 +1,135 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRun(t *testing.T) {
+	d, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+
+	now := time.Now().Truncate(time.Microsecond)
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+
+	observer := &recordingObserver{}
+	dryRunLimiter := New(d, WithDryRun(), WithObserver(observer))
+	limiter := New(d)
+
+	req := &ReserveRequest{
+		Key:              "TestDryRun:k1",
+		DurationPerToken: time.Second,
+		Burst:            1,
+		Tokens:           1,
+	}
+	for range 3 {
+		r, err := dryRunLimiter.Reserve(ctx, req)
+		require.NoError(t, err)
+		require.True(t, r.OK)
+		require.Equal(t, req.Key, r.Key)
+		require.Equal(t, time.Duration(0), r.DelayFrom(now))
+	}
+
+	// the would-be denials are observed
+	observations := observer.take()
+	require.Len(t, observations, 3)
+	for i, o := range observations {
+		require.True(t, o.DryRun)
+		require.Equal(t, ShadowKeyPrefix+req.Key, o.Request.Key)
+		require.Equal(t, i == 0, o.Reservation.OK)
+	}
+
+	// the real tokens are not consumed
+	r, err := limiter.Reserve(ctx, req)
+	require.NoError(t, err)
+	require.True(t, r.OK)
+	r, err = limiter.Reserve(ctx, req)
+	require.NoError(t, err)
+	require.False(t, r.OK)
+
+	// the invalid requests still fail
+	_, err = dryRunLimiter.Reserve(ctx, &ReserveRequest{Key: "TestDryRun:invalid"})
+	require.ErrorIs(t, err, ErrInvalidParameters)
+	observer.take()
+
+	hr, err := dryRunLimiter.ReserveHierarchy(ctx,
+		&ReserveRequest{Key: "TestDryRun:{h}:global", DurationPerToken: time.Second, Burst: 1, Tokens: 1},
+		&ReserveRequest{Key: "TestDryRun:{h}:user", DurationPerToken: time.Second, Burst: 1, Tokens: 1},
+	)
+	require.NoError(t, err)
+	require.True(t, hr.OK)
+	hr, err = dryRunLimiter.ReserveHierarchy(ctx,
+		&ReserveRequest{Key: "TestDryRun:{h}:global", DurationPerToken: time.Second, Burst: 1, Tokens: 1},
+		&ReserveRequest{Key: "TestDryRun:{h}:user", DurationPerToken: time.Second, Burst: 1, Tokens: 1},
+	)
+	require.NoError(t, err)
+	require.True(t, hr.OK)
+	require.Equal(t, -1, hr.DeniedLevel)
+	observations = observer.take()
+	require.Len(t, observations, 4)
+	require.False(t, observations[2].Reservation.OK)
+}
+
+func TestDryRun_DriverError(t *testing.T) {
+	errBackend := errors.New("connection refused")
+	observer := &recordingObserver{}
+	limiter := New(DriverFunc(func(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
+		return nil, errBackend
+	}), WithDryRun(), WithObserver(observer))
+
+	// the allowed reservation uses the clock of the test
+	now := time.Now().Add(-time.Hour).Truncate(time.Microsecond)
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+	r, err := limiter.Reserve(ctx, &ReserveRequest{Key: "k", DurationPerToken: time.Second, Burst: 1, Tokens: 1})
+	require.NoError(t, err)
+	require.True(t, r.OK)
+	require.True(t, now.Equal(r.Now), "now: %v", r.Now)
+	require.True(t, now.Equal(r.TimeToAct), "timeToAct: %v", r.TimeToAct)
+
+	observations := observer.take()
+	require.Len(t, observations, 1)
+	require.ErrorIs(t, observations[0].Err, errBackend)
+}
+
+func TestPolicyLimiter_DryRun(t *testing.T) {
+	d, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+
+	registry := NewPolicyRegistry()
+	require.NoError(t, registry.Register(
+		Policy{Name: "login", Rate: Rate{Tokens: 1, Per: time.Minute}, Burst: 1, KeyTemplate: "TestPolicyLimiter_DryRun:login:{userID}"},
+		Policy{Name: "login_v2", Rate: Rate{Tokens: 1, Per: time.Minute}, Burst: 1, KeyTemplate: "TestPolicyLimiter_DryRun:login_v2:{userID}", DryRun: true},
+	))
+	observer := &recordingObserver{}
+	limiter := NewPolicyLimiter(d, registry, WithObserver(observer))
+
+	now := time.Now().Truncate(time.Microsecond)
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+
+	for i := range 2 {
+		allowed, err := limiter.Allow(ctx, "login", "u1")
+		require.NoError(t, err)
+		require.Equal(t, i == 0, allowed)
+
+		allowed, err = limiter.Allow(ctx, "login_v2", "u1")
+		require.NoError(t, err)
+		require.True(t, allowed)
+	}
+
+	observations := observer.take()
+	require.Len(t, observations, 4)
+	require.False(t, observations[2].DryRun)
+	require.True(t, observations[3].DryRun)
+	require.Equal(t, "login_v2", observations[3].Policy)
+	require.False(t, observations[3].Reservation.OK)
+}
@@ -4,8 +4,9 @@ local burst = tonumber(ARGV[2]) -- Burst capacity
 local tokens = tonumber(ARGV[3]) -- Number of tokens requested
 local now = tonumber(ARGV[4]) -- Current timestamp, in microseconds
 local maxFutureReserve = tonumber(ARGV[5]) -- Maximum reservation duration, in microseconds
+local floor = tonumber(ARGV[6]) or 0 -- Number of tokens reserved for higher priorities
 
-if durationPerToken <= 0 or burst <= 0 or tokens <= 0 or tokens > burst then
+if durationPerToken <= 0 or burst <= 0 or tokens <= 0 or floor < 0 or tokens + floor > burst then
 	return {-2, 0, 0} -- Indicates invalid parameters
 end
 
@@ -34,8 +35,9 @@ end
 local tokensDuration = tokens * durationPerToken
 local timeToAct = timeBase + tokensDuration
 
--- If timeToAct exceeds the maximum reservation timeout, do not update timeBase and return an error
-if timeToAct > now + maxFutureReserve then
+-- If timeToAct exceeds the maximum reservation timeout, do not update timeBase and return an error,
+-- the requests of lower priorities must leave the floor tokens
+if timeToAct + floor * durationPerToken > now + maxFutureReserve then
 	return {-1, timeToAct, now} -- Error indicator and returns timeToAct
 else
 	-- Update timeBase to the execution time of the next request
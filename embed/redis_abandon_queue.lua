@@ -0,0 +1,18 @@
+local key = KEYS[1]
+local id = ARGV[1] -- ID of the reservation in the queue
+local now = tonumber(ARGV[2]) -- Current timestamp, in microseconds
+
+if now <= 0 then
+	local time = redis.call("TIME")
+	local time_seconds = tonumber(time[1])
+	local time_microseconds = tonumber(time[2])
+	now = time_seconds * 1000000 + time_microseconds
+end
+
+-- Only the waiting reservation can be abandoned, the ones that have acted still affect the time base
+local timeToAct = redis.call("ZSCORE", key, id)
+if timeToAct and tonumber(timeToAct) > now then
+	redis.call("ZREM", key, id)
+	return 1
+end
+return 0
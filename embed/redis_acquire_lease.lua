@@ -0,0 +1,31 @@
+local key = KEYS[1]
+local id = ARGV[1] -- ID of the lease
+local limit = tonumber(ARGV[2]) -- Maximum number of unexpired leases
+local ttl = tonumber(ARGV[3]) -- Time to live of the lease, in microseconds
+local now = tonumber(ARGV[4]) -- Current timestamp, in microseconds
+
+if limit <= 0 or ttl <= 0 then
+	return {-2, 0} -- Indicates invalid parameters
+end
+
+if now <= 0 then
+	local time = redis.call("TIME")
+	local time_seconds = tonumber(time[1])
+	local time_microseconds = tonumber(time[2])
+	now = time_seconds * 1000000 + time_microseconds
+end
+
+-- Remove the expired leases
+redis.call("ZREMRANGEBYSCORE", key, "-inf", now)
+
+if redis.call("ZCARD", key) >= limit then
+	return {-1, 0} -- Error indicator
+end
+
+local expiresAt = now + ttl
+redis.call("ZADD", key, expiresAt, id)
+
+-- The set is useless once the last lease expires
+local last = redis.call("ZRANGE", key, -1, -1, "WITHSCORES")
+redis.call("PEXPIRE", key, math.ceil((tonumber(last[2]) - now) / 1000) + 1)
+return {0, expiresAt} -- Success indicator and returns expiresAt
@@ -0,0 +1,38 @@
+local key = KEYS[1]
+local burst = tonumber(ARGV[1]) -- Burst capacity
+local tokens = tonumber(ARGV[2]) -- Number of tokens requested
+local now = tonumber(ARGV[3]) -- Current timestamp, in microseconds
+local maxFutureReserve = tonumber(ARGV[4]) -- Maximum reservation duration, in microseconds
+local minDurationPerToken = tonumber(ARGV[5]) -- The fastest time interval of each token, in microseconds
+local maxDurationPerToken = tonumber(ARGV[6]) -- The slowest time interval of each token, in microseconds
+
+if burst <= 0 or tokens <= 0 or tokens > burst or minDurationPerToken <= 0 or maxDurationPerToken < minDurationPerToken then
+	return {-2, 0, 0, 0} -- Indicates invalid parameters
+end
+
+if now <= 0 then
+	local time = redis.call("TIME")
+	local time_seconds = tonumber(time[1])
+	local time_microseconds = tonumber(time[2])
+	now = time_seconds * 1000000 + time_microseconds
+end
+
+-- The current time interval of each token, which starts at the fastest
+local state = redis.call("HMGET", key, "tb", "dpt")
+local durationPerToken = tonumber(state[2]) or minDurationPerToken
+durationPerToken = math.min(math.max(durationPerToken, minDurationPerToken), maxDurationPerToken)
+
+-- Same as redis.lua at the current time interval
+local resetValue = now - (burst * durationPerToken)
+local timeBase = tonumber(state[1])
+if not timeBase or timeBase < resetValue then
+	timeBase = resetValue
+end
+
+local timeToAct = timeBase + tokens * durationPerToken
+if timeToAct > now + maxFutureReserve then
+	return {-1, timeToAct, now, durationPerToken} -- Error indicator and returns timeToAct
+end
+
+redis.call("HSET", key, "tb", timeToAct, "dpt", durationPerToken)
+return {0, timeToAct, now, durationPerToken} -- Success indicator and returns timeToAct
@@ -0,0 +1,26 @@
+local key = KEYS[1]
+local decrease = tonumber(ARGV[1]) -- 0 to increase the rate additively, 1 to decrease it multiplicatively
+local additiveIncrease = tonumber(ARGV[2]) -- Tokens per second added to the rate
+local multiplicativeDecrease = tonumber(ARGV[3]) -- Factor the rate is multiplied by
+local minDurationPerToken = tonumber(ARGV[4]) -- The fastest time interval of each token, in microseconds
+local maxDurationPerToken = tonumber(ARGV[5]) -- The slowest time interval of each token, in microseconds
+
+if additiveIncrease < 0 or multiplicativeDecrease <= 0 or multiplicativeDecrease >= 1 or minDurationPerToken <= 0 or maxDurationPerToken < minDurationPerToken then
+	return {-2, 0} -- Indicates invalid parameters
+end
+
+local durationPerToken = tonumber(redis.call("HGET", key, "dpt")) or minDurationPerToken
+durationPerToken = math.min(math.max(durationPerToken, minDurationPerToken), maxDurationPerToken)
+
+-- Same as adjustAdaptive in adaptive.go
+local rate = 1000000 / durationPerToken -- Tokens per second
+if decrease == 0 then
+	rate = rate + additiveIncrease
+else
+	rate = rate * multiplicativeDecrease
+end
+durationPerToken = math.floor(1000000 / rate + 0.5)
+durationPerToken = math.min(math.max(durationPerToken, minDurationPerToken), maxDurationPerToken)
+
+redis.call("HSET", key, "dpt", durationPerToken)
+return {0, durationPerToken} -- Success indicator and returns the adjusted durationPerToken
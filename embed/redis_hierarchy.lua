@@ -0,0 +1,53 @@
+local tokens = tonumber(ARGV[1]) -- Number of tokens requested at every level
+local now = tonumber(ARGV[2]) -- Current timestamp, in microseconds
+-- ARGV[3 + (i - 1) * 4] to ARGV[6 + (i - 1) * 4] are durationPerToken, burst, maxFutureReserve and floor of KEYS[i]
+
+if tokens <= 0 or #ARGV ~= 2 + #KEYS * 4 then
+	return {-2, 0} -- Indicates invalid parameters
+end
+
+if now <= 0 then
+	local time = redis.call("TIME")
+	local time_seconds = tonumber(time[1])
+	local time_microseconds = tonumber(time[2])
+	now = time_seconds * 1000000 + time_microseconds
+end
+
+local status = 0
+local timeToActs = {}
+
+for i, key in ipairs(KEYS) do
+	local durationPerToken = tonumber(ARGV[3 + (i - 1) * 4])
+	local burst = tonumber(ARGV[4 + (i - 1) * 4])
+	local maxFutureReserve = tonumber(ARGV[5 + (i - 1) * 4])
+	local floor = tonumber(ARGV[6 + (i - 1) * 4])
+
+	if durationPerToken <= 0 or burst <= 0 or floor < 0 or tokens + floor > burst then
+		return {-2, 0} -- Indicates invalid parameters
+	end
+
+	-- Same as redis.lua, but nothing is saved until all levels are checked
+	local resetValue = now - (burst * durationPerToken)
+	local timeBase = tonumber(redis.call("get", key))
+	if not timeBase or timeBase < resetValue then
+		timeBase = resetValue
+	end
+
+	local timeToAct = timeBase + tokens * durationPerToken
+	if timeToAct + floor * durationPerToken > now + maxFutureReserve then
+		status = -1
+	end
+	timeToActs[i] = timeToAct
+end
+
+if status == 0 then
+	for i, key in ipairs(KEYS) do
+		redis.call("set", key, timeToActs[i])
+	end
+end
+
+local result = {status, now}
+for i = 1, #timeToActs do
+	result[#result + 1] = timeToActs[i]
+end
+return result -- Status, now and the timeToAct of every level
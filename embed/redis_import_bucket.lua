@@ -0,0 +1,19 @@
+local key = KEYS[1]
+local timeBase = tonumber(ARGV[1]) -- timeBase of the imported bucket, in microseconds
+
+local keyType = redis.call("TYPE", key).ok
+if keyType ~= "none" and keyType ~= "string" then
+	return {-2} -- Indicates the key is not a GCRA bucket
+end
+
+local current = tonumber(redis.call("GET", key))
+if keyType == "string" and not current then
+	return {-2}
+end
+
+-- Keep the later timeBase, which has less tokens, so importing twice or along with the reservations is safe
+if current and current >= timeBase then
+	return {1}
+end
+redis.call("SET", key, timeBase)
+return {0}
@@ -0,0 +1,44 @@
+local key = KEYS[1]
+local durationPerToken = tonumber(ARGV[1]) -- The time interval required for each token, in microseconds
+local burst = tonumber(ARGV[2]) -- Burst capacity
+local tokens = tonumber(ARGV[3]) -- Number of tokens requested
+local now = tonumber(ARGV[4]) -- Current timestamp, in microseconds
+local maxQueue = tonumber(ARGV[5]) -- Maximum number of waiting reservations
+local id = ARGV[6] -- ID of the reservation in the queue
+
+if durationPerToken <= 0 or burst <= 0 or tokens <= 0 or tokens > burst or maxQueue <= 0 then
+	return {-2, 0, 0, 0} -- Indicates invalid parameters
+end
+
+if now <= 0 then
+	local time = redis.call("TIME")
+	local time_seconds = tonumber(time[1])
+	local time_microseconds = tonumber(time[2])
+	now = time_seconds * 1000000 + time_microseconds
+end
+
+-- Calculate the reset value based on the current time and burst duration
+local resetValue = now - (burst * durationPerToken)
+
+-- Remove the reservations that no longer affect the time base
+redis.call("ZREMRANGEBYSCORE", key, "-inf", resetValue)
+
+-- The time base is the time to act of the last reservation
+local timeBase = resetValue
+local last = redis.call("ZRANGE", key, -1, -1, "WITHSCORES")
+if #last > 0 and tonumber(last[2]) > timeBase then
+	timeBase = tonumber(last[2])
+end
+
+-- The waiting reservations are the ones to act after now
+local depth = redis.call("ZCOUNT", key, "(" .. now, "+inf")
+
+local timeToAct = timeBase + tokens * durationPerToken
+if timeToAct > now and depth >= maxQueue then
+	return {-1, timeToAct, now, depth} -- Error indicator and returns timeToAct
+end
+
+redis.call("ZADD", key, timeToAct, id)
+-- The queue is useless once the last reservation no longer affects the time base
+redis.call("PEXPIRE", key, math.ceil((timeToAct + burst * durationPerToken - now) / 1000) + 1)
+return {0, timeToAct, now, depth} -- Success indicator and returns timeToAct
@@ -0,0 +1,27 @@
+local key = KEYS[1]
+local limit = tonumber(ARGV[1]) -- Maximum number of tokens in a window
+local tokens = tonumber(ARGV[2]) -- Number of tokens requested, 0 to query the usage only
+local start = tonumber(ARGV[3]) -- Start of the current window, in microseconds
+local expireAt = tonumber(ARGV[4]) -- End of the current window, in milliseconds
+
+if limit <= 0 or tokens < 0 or tokens > limit then
+	return {-2, 0} -- Indicates invalid parameters
+end
+
+-- The usage is reset when a new window starts
+local used = 0
+local state = redis.call("HMGET", key, "w", "n")
+if tonumber(state[1]) == start then
+	used = tonumber(state[2])
+end
+
+if used + tokens > limit then
+	return {-1, used} -- Error indicator and returns the usage
+end
+
+if tokens > 0 then
+	used = used + tokens
+	redis.call("HSET", key, "w", start, "n", used)
+	redis.call("PEXPIREAT", key, expireAt)
+end
+return {0, used} -- Success indicator and returns the usage
@@ -0,0 +1,30 @@
+local key = KEYS[1]
+local id = ARGV[1] -- ID of the lease
+local ttl = tonumber(ARGV[2]) -- Time to live of the lease, in microseconds
+local now = tonumber(ARGV[3]) -- Current timestamp, in microseconds
+
+if ttl <= 0 then
+	return {-2, 0} -- Indicates invalid parameters
+end
+
+if now <= 0 then
+	local time = redis.call("TIME")
+	local time_seconds = tonumber(time[1])
+	local time_microseconds = tonumber(time[2])
+	now = time_seconds * 1000000 + time_microseconds
+end
+
+-- Remove the expired leases
+redis.call("ZREMRANGEBYSCORE", key, "-inf", now)
+
+if not redis.call("ZSCORE", key, id) then
+	return {-1, 0} -- Error indicator
+end
+
+local expiresAt = now + ttl
+redis.call("ZADD", key, "XX", expiresAt, id)
+
+-- The set is useless once the last lease expires
+local last = redis.call("ZRANGE", key, -1, -1, "WITHSCORES")
+redis.call("PEXPIRE", key, math.ceil((tonumber(last[2]) - now) / 1000) + 1)
+return {0, expiresAt} -- Success indicator and returns expiresAt
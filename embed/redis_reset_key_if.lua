@@ -0,0 +1,14 @@
+local key = KEYS[1]
+local value = ARGV[1] -- value the key is expected to have
+
+-- Only the keys of a single value can be compared
+if redis.call("TYPE", key).ok ~= "string" then
+	return 0
+end
+
+-- Delete the key only if it is not changed since it is read, e.g. by a reservation
+if redis.call("GET", key) ~= value then
+	return 0
+end
+redis.call("DEL", key)
+return 1
@@ -0,0 +1,52 @@
+local key = KEYS[1]
+local durationPerToken = tonumber(ARGV[1]) -- The time interval required for each token, in microseconds
+local burst = tonumber(ARGV[2]) -- Maximum number of tokens in a window
+local tokens = tonumber(ARGV[3]) -- Number of tokens requested
+local now = tonumber(ARGV[4]) -- Current timestamp, in microseconds
+
+if durationPerToken <= 0 or burst <= 0 or tokens <= 0 or tokens > burst then
+	return {-2, 0, 0} -- Indicates invalid parameters
+end
+
+if now <= 0 then
+	local time = redis.call("TIME")
+	local time_seconds = tonumber(time[1])
+	local time_microseconds = tonumber(time[2])
+	now = time_seconds * 1000000 + time_microseconds
+end
+
+local window = burst * durationPerToken
+local index = math.floor(now / window)
+local start = index * window
+
+-- Counts of the current fixed window and the previous one
+local prev = 0
+local curr = 0
+local state = redis.call("HMGET", key, "w", "p", "c")
+local storedIndex = tonumber(state[1])
+if storedIndex == index then
+	prev = tonumber(state[2])
+	curr = tonumber(state[3])
+elseif storedIndex == index - 1 then
+	prev = tonumber(state[3])
+end
+
+-- Estimate the count of the rolling window by weighting the previous window by its overlap
+local estimated = prev * (window - (now - start)) / window + curr
+if estimated + tokens <= burst then
+	redis.call("HSET", key, "w", index, "p", prev, "c", curr + tokens)
+	-- The counts are useless once the current window becomes older than the previous window
+	redis.call("PEXPIRE", key, math.ceil((start + 2 * window - now) / 1000) + 1)
+	return {0, now, now} -- Success indicator and returns timeToAct
+end
+
+-- The earliest time the estimated count leaves room for the tokens
+local timeToAct
+if curr + tokens <= burst then
+	-- The weight of the previous window decreases enough in the current window
+	timeToAct = start + math.ceil(window * (1 - (burst - curr - tokens) / prev))
+else
+	-- The current window becomes the previous window
+	timeToAct = start + window + math.ceil(window * math.max(0, 1 - (burst - tokens) / curr))
+end
+return {-1, timeToAct, now} -- Error indicator and returns timeToAct
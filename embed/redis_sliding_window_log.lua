@@ -0,0 +1,56 @@
+local key = KEYS[1]
+local durationPerToken = tonumber(ARGV[1]) -- The time interval required for each token, in microseconds
+local burst = tonumber(ARGV[2]) -- Maximum number of tokens in a window
+local tokens = tonumber(ARGV[3]) -- Number of tokens requested
+local now = tonumber(ARGV[4]) -- Current timestamp, in microseconds
+local maxFutureReserve = tonumber(ARGV[5]) -- Maximum reservation duration, in microseconds
+local nonce = ARGV[6] -- Unique value of this request, used to build the members of the log
+
+if durationPerToken <= 0 or burst <= 0 or tokens <= 0 or tokens > burst then
+	return {-2, 0, 0} -- Indicates invalid parameters
+end
+
+if now <= 0 then
+	local time = redis.call("TIME")
+	local time_seconds = tonumber(time[1])
+	local time_microseconds = tonumber(time[2])
+	now = time_seconds * 1000000 + time_microseconds
+end
+
+local window = burst * durationPerToken
+
+-- Remove the tokens out of the window, a token logged at t is counted in the windows ending before t + window
+redis.call("ZREMRANGEBYSCORE", key, "-inf", now - window)
+
+local count = redis.call("ZCARD", key)
+local timeToAct = now
+
+-- Keep the log in order if there are reservations in the future
+if count > 0 then
+	local last = redis.call("ZRANGE", key, -1, -1, "WITHSCORES")
+	local lastScore = tonumber(last[2])
+	if lastScore > timeToAct then
+		timeToAct = lastScore
+	end
+end
+
+-- The k-th most recent token must be out of the window to leave room for the requested tokens
+local k = burst - tokens + 1
+if count >= k then
+	local entry = redis.call("ZRANGE", key, count - k, count - k, "WITHSCORES")
+	local leaveAt = tonumber(entry[2]) + window
+	if leaveAt > timeToAct then
+		timeToAct = leaveAt
+	end
+end
+
+if timeToAct > now + maxFutureReserve then
+	return {-1, timeToAct, now} -- Error indicator and returns timeToAct
+end
+
+for i = 1, tokens do
+	redis.call("ZADD", key, timeToAct, nonce .. ":" .. i)
+end
+-- The log is useless once the last token is out of the window
+redis.call("PEXPIRE", key, math.ceil((timeToAct + window - now) / 1000) + 1)
+return {0, timeToAct, now} -- Success indicator and returns timeToAct
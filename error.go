@@ -3,3 +3,18 @@ package ratelimiter
 import "github.com/pkg/errors"
 
 var ErrInvalidParameters = errors.New("ratelimiter: invalid parameters")
+
+// ErrTooManyRetries is returned by the drivers based on compare-and-swap when the key is modified concurrently too many times.
+var ErrTooManyRetries = errors.New("ratelimiter: too many retries")
+
+// ErrUnsupportedAlgorithm is returned when the driver does not support the algorithm of the request.
+var ErrUnsupportedAlgorithm = errors.New("ratelimiter: unsupported algorithm")
+
+// ErrConcurrencyLimitExceeded is returned by Semaphore when the key already has the limited number of leases.
+var ErrConcurrencyLimitExceeded = errors.New("ratelimiter: concurrency limit exceeded")
+
+// ErrLeaseNotFound is returned when renewing a lease that is expired or released.
+var ErrLeaseNotFound = errors.New("ratelimiter: lease not found")
+
+// ErrPolicyNotFound is returned when the policy is not registered.
+var ErrPolicyNotFound = errors.New("ratelimiter: policy not found")
@@ -0,0 +1,56 @@
+package ratelimiter
+
+import (
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// validateReserveRequest validates the request against the algorithms supported by the driver,
+// all algorithms are accepted if none is provided.
+func validateReserveRequest(req *ReserveRequest, algorithms ...Algorithm) error {
+	if req.Algorithm == AlgorithmQuota {
+		if req.Key == "" || req.Burst <= 0 || req.Tokens < 0 || req.Tokens > req.Burst || !req.Period.valid() {
+			return errors.Wrapf(ErrInvalidParameters, "%v", req)
+		}
+	} else if req.Key == "" || req.DurationPerToken <= 0 || req.Burst <= 0 || req.Tokens <= 0 || req.Tokens > req.Burst {
+		return errors.Wrapf(ErrInvalidParameters, "%v", req)
+	}
+	if (req.Algorithm == AlgorithmSlidingWindowCounter || req.Algorithm == AlgorithmQuota || req.Algorithm == AlgorithmLeakyBucketQueue) && req.MaxFutureReserve > 0 {
+		return errors.Wrapf(ErrInvalidParameters, "MaxFutureReserve is not supported by %v", req.Algorithm)
+	}
+	if req.Floor < 0 || req.Tokens+req.Floor > req.Burst {
+		return errors.Wrapf(ErrInvalidParameters, "Floor %d leaves no room for %d tokens of %d", req.Floor, req.Tokens, req.Burst)
+	}
+	if req.Algorithm != AlgorithmGCRA && req.Floor > 0 {
+		return errors.Wrapf(ErrInvalidParameters, "Floor is not supported by %v", req.Algorithm)
+	}
+	if req.Algorithm == AlgorithmLeakyBucketQueue && req.MaxQueue <= 0 {
+		return errors.Wrapf(ErrInvalidParameters, "MaxQueue is required by %v", req.Algorithm)
+	}
+	if len(algorithms) > 0 && !slices.Contains(algorithms, req.Algorithm) {
+		return errors.Wrapf(ErrUnsupportedAlgorithm, "%v", req.Algorithm)
+	}
+	return nil
+}
+
+// reserveGCRA applies the GCRA to the stored timeBase of a key.
+// If ok is true, timeToAct should be stored as the new timeBase.
+// The request with a Floor is only OK if the reservation leaves Floor tokens for higher priorities.
+func reserveGCRA(req *ReserveRequest, now time.Time, timeBase time.Time, found bool) (timeToAct time.Time, ok bool) {
+	resetValue := now.Add(-time.Duration(req.Burst) * req.DurationPerToken)
+	if !found || timeBase.Before(resetValue) {
+		timeBase = resetValue
+	}
+
+	tokensDuration := req.DurationPerToken * time.Duration(req.Tokens)
+	timeToAct = timeBase.Add(tokensDuration).UTC()
+
+	return timeToAct, gcraAllowed(req, now, timeToAct)
+}
+
+func gcraAllowed(req *ReserveRequest, now time.Time, timeToAct time.Time) bool {
+	floorDuration := time.Duration(req.Floor) * req.DurationPerToken
+	return !timeToAct.Add(floorDuration).After(now.Add(req.MaxFutureReserve))
+}
@@ -0,0 +1,107 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HierarchicalDriver is implemented by the drivers that support hierarchical limits.
+type HierarchicalDriver interface {
+	// ReserveHierarchy reserves the tokens at all levels atomically, nothing is consumed unless all levels allow it.
+	ReserveHierarchy(ctx context.Context, reqs []*ReserveRequest) (*HierarchicalReservation, error)
+}
+
+// HierarchicalReservation is the reservation of a chain of levels, e.g. global > tenant > user.
+type HierarchicalReservation struct {
+	// Levels are the reservations of each level in the order of the requests.
+	Levels []*Reservation
+	OK     bool
+	// DeniedLevel is the index of the first level that denies the request, or -1 if it is OK.
+	DeniedLevel int
+	// TimeToAct is the latest time to act of the levels.
+	TimeToAct time.Time
+	Now       time.Time
+}
+
+func newHierarchicalReservation(levels []*Reservation, now time.Time) *HierarchicalReservation {
+	r := &HierarchicalReservation{
+		Levels:      levels,
+		OK:          true,
+		DeniedLevel: -1,
+		Now:         now,
+	}
+	for i, level := range levels {
+		if !level.OK && r.OK {
+			r.OK = false
+			r.DeniedLevel = i
+		}
+		if level.TimeToAct.After(r.TimeToAct) {
+			r.TimeToAct = level.TimeToAct
+		}
+	}
+	return r
+}
+
+func (r *HierarchicalReservation) DelayFrom(t time.Time) time.Duration {
+	if !r.OK {
+		panic("ratelimiter: cannot get delay from non-OK reservation")
+	}
+
+	delay := r.TimeToAct.Sub(t)
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+func (r *HierarchicalReservation) Delay() time.Duration {
+	return r.DelayFrom(time.Now())
+}
+
+// RetryAfterFrom returns the retry after of the denied level,
+// the other levels may still deny the request when it is retried.
+func (r *HierarchicalReservation) RetryAfterFrom(t time.Time) time.Duration {
+	if r.OK {
+		panic("ratelimiter: cannot get retry after from OK reservation")
+	}
+	return r.Levels[r.DeniedLevel].RetryAfterFrom(t)
+}
+
+func (r *HierarchicalReservation) RetryAfter() time.Duration {
+	return r.RetryAfterFrom(time.Now())
+}
+
+// validateHierarchy validates the levels of a hierarchy, which must be AlgorithmGCRA requests
+// of distinct keys and the same tokens, since the tokens consumed at a child are also consumed at its parents.
+func validateHierarchy(reqs []*ReserveRequest) error {
+	if len(reqs) == 0 {
+		return errors.Wrap(ErrInvalidParameters, "hierarchy must have at least one level")
+	}
+
+	keys := make(map[string]bool, len(reqs))
+	for _, req := range reqs {
+		if err := validateReserveRequest(req, AlgorithmGCRA); err != nil {
+			return err
+		}
+		if req.Tokens != reqs[0].Tokens {
+			return errors.Wrapf(ErrInvalidParameters, "tokens of all levels must be the same, %d != %d", req.Tokens, reqs[0].Tokens)
+		}
+		if keys[req.Key] {
+			return errors.Wrapf(ErrInvalidParameters, "duplicate key %q in hierarchy", req.Key)
+		}
+		keys[req.Key] = true
+	}
+	return nil
+}
+
+// ReserveHierarchy reserves the tokens at a chain of levels from the parent to the child, e.g. global > tenant > user,
+// it is OK only if all levels allow it, and then the tokens are consumed at every level.
+func (lim *RateLimiter) ReserveHierarchy(ctx context.Context, reqs ...*ReserveRequest) (*HierarchicalReservation, error) {
+	d, ok := lim.driver.(HierarchicalDriver)
+	if !ok {
+		return nil, errors.Wrap(ErrUnsupportedAlgorithm, "driver does not support hierarchical limits")
+	}
+	return lim.reserveHierarchy(ctx, d, reqs)
+}
@@ -0,0 +1,102 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testHierarchy(t *testing.T, limiter *RateLimiter, key string) {
+	now := time.Now().Truncate(time.Microsecond)
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+
+	global := &ReserveRequest{Key: key + ":global", DurationPerToken: time.Second, Burst: 3, Tokens: 1}
+	tenant := func(tenantID string) *ReserveRequest {
+		return &ReserveRequest{Key: key + ":" + tenantID, DurationPerToken: time.Second, Burst: 2, Tokens: 1}
+	}
+	user := func(tenantID, userID string) *ReserveRequest {
+		return &ReserveRequest{Key: key + ":" + tenantID + ":" + userID, DurationPerToken: time.Second, Burst: 1, Tokens: 1}
+	}
+
+	testCases := []struct {
+		name                string
+		tenantID            string
+		userID              string
+		expectedDeniedLevel int
+	}{
+		{"first", "t1", "u1", -1},
+		{"user denied", "t1", "u1", 2},
+		{"another user", "t1", "u2", -1},
+		{"tenant denied", "t1", "u3", 1},
+		{"another tenant", "t2", "u1", -1},
+		{"global denied", "t3", "u1", 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := limiter.ReserveHierarchy(ctx, global, tenant(tc.tenantID), user(tc.tenantID, tc.userID))
+			require.NoError(t, err)
+			require.Len(t, r.Levels, 3)
+			require.Equal(t, tc.expectedDeniedLevel, r.DeniedLevel)
+			require.Equal(t, tc.expectedDeniedLevel == -1, r.OK)
+			require.True(t, now.Equal(r.Now))
+			if r.OK {
+				require.Equal(t, time.Duration(0), r.DelayFrom(now))
+			} else {
+				require.False(t, r.Levels[r.DeniedLevel].OK)
+				require.Equal(t, time.Second, r.RetryAfterFrom(now))
+			}
+		})
+	}
+
+	// the tokens consumed by the users are also consumed at their tenant
+	r, err := limiter.Reserve(ctx, tenant("t1"))
+	require.NoError(t, err)
+	require.False(t, r.OK)
+
+	// the denied requests consume nothing, so u3 is allowed once the tenant refills
+	laterCtx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now.Add(time.Second)
+	})
+	hr, err := limiter.ReserveHierarchy(laterCtx, global, tenant("t1"), user("t1", "u3"))
+	require.NoError(t, err)
+	require.True(t, hr.OK)
+
+	invalid := tenant("t1")
+	invalid.Tokens = 2
+	_, err = limiter.ReserveHierarchy(ctx, global, invalid)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+	_, err = limiter.ReserveHierarchy(ctx, global, global)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+	_, err = limiter.ReserveHierarchy(ctx)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+	invalid = tenant("t1")
+	invalid.Algorithm = AlgorithmSlidingWindowLog
+	_, err = limiter.ReserveHierarchy(ctx, global, invalid)
+	require.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+}
+
+func TestHierarchy_DriverGORM(t *testing.T) {
+	testHierarchy(t, New(NewGormDriver(db)), "TestHierarchy_DriverGORM")
+}
+
+func TestHierarchy_DriverRedis(t *testing.T) {
+	d, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+	testHierarchy(t, New(d), "TestHierarchy_DriverRedis")
+}
+
+func TestHierarchy_UnsupportedDriver(t *testing.T) {
+	limiter := New(NewMemcachedDriver(nil))
+	_, err := limiter.ReserveHierarchy(context.Background(), &ReserveRequest{
+		Key:              "TestHierarchy_UnsupportedDriver",
+		DurationPerToken: time.Second,
+		Burst:            1,
+		Tokens:           1,
+	})
+	require.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+}
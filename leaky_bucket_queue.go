@@ -0,0 +1,35 @@
+package ratelimiter
+
+import "time"
+
+// reserveLeakyBucketQueue applies the GCRA to the last reservation in the queue of a key,
+// depth is the number of reservations whose time to act is after now.
+// If ok is true and timeToAct is after now, the reservation joins the tail of the queue.
+func reserveLeakyBucketQueue(req *ReserveRequest, now time.Time, last time.Time, found bool, depth int) (timeToAct time.Time, ok bool) {
+	resetValue := now.Add(-time.Duration(req.Burst) * req.DurationPerToken)
+	timeBase := last
+	if !found || timeBase.Before(resetValue) {
+		timeBase = resetValue
+	}
+
+	timeToAct = timeBase.Add(req.DurationPerToken * time.Duration(req.Tokens)).UTC()
+	if timeToAct.After(now) && depth >= req.MaxQueue {
+		return timeToAct, false
+	}
+	return timeToAct, true
+}
+
+// newQueueReservation returns a reservation of AlgorithmLeakyBucketQueue,
+// depth is the number of waiting reservations before this one joins the queue.
+func newQueueReservation(req *ReserveRequest, now time.Time, timeToAct time.Time, ok bool, id string, depth int) *Reservation {
+	r := newReservation(req, now, timeToAct, ok)
+	r.QueuePosition = depth
+	r.QueueDepth = depth
+	if ok {
+		r.QueueID = id
+		if timeToAct.After(now) {
+			r.QueueDepth++
+		}
+	}
+	return r
+}
@@ -0,0 +1,113 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testLeakyBucketQueue(t *testing.T, limiter *RateLimiter, key string) {
+	now := time.Now().Truncate(time.Microsecond)
+	later := now.Add(2 * time.Second)
+
+	req := &ReserveRequest{
+		Key:              key,
+		DurationPerToken: time.Second,
+		Burst:            2,
+		Tokens:           1,
+		Algorithm:        AlgorithmLeakyBucketQueue,
+		MaxQueue:         2,
+	}
+
+	reserve := func(t *testing.T, now time.Time) *Reservation {
+		ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+			return now
+		})
+		r, err := limiter.Reserve(ctx, req)
+		require.NoError(t, err)
+		return r
+	}
+	abandon := func(t *testing.T, now time.Time, r *Reservation) {
+		ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+			return now
+		})
+		require.NoError(t, limiter.Abandon(ctx, r))
+	}
+	requireQueue := func(t *testing.T, r *Reservation, ok bool, timeToAct time.Time, position, depth int) {
+		require.Equal(t, ok, r.OK)
+		require.True(t, timeToAct.Equal(r.TimeToAct), "timeToAct: %v", r.TimeToAct.Sub(now))
+		require.Equal(t, position, r.QueuePosition)
+		require.Equal(t, depth, r.QueueDepth)
+		require.Equal(t, ok, r.QueueID != "")
+	}
+
+	// the burst acts immediately
+	requireQueue(t, reserve(t, now), true, now.Add(-time.Second), 0, 0)
+	requireQueue(t, reserve(t, now), true, now, 0, 0)
+
+	// the rest waits in the queue
+	r3 := reserve(t, now)
+	requireQueue(t, r3, true, now.Add(time.Second), 0, 1)
+	r4 := reserve(t, now)
+	requireQueue(t, r4, true, now.Add(2*time.Second), 1, 2)
+	r5 := reserve(t, now)
+	requireQueue(t, r5, false, now.Add(3*time.Second), 2, 2)
+	require.ErrorIs(t, limiter.Abandon(context.Background(), r5), ErrInvalidParameters)
+
+	// abandoning a reservation in the middle shortens the queue, but the order is kept
+	abandon(t, now, r3)
+	r6 := reserve(t, now)
+	requireQueue(t, r6, true, now.Add(3*time.Second), 1, 2)
+
+	// abandoning the last reservation gives its tokens back
+	abandon(t, now, r6)
+	abandon(t, now, r6)
+	requireQueue(t, reserve(t, now), true, now.Add(3*time.Second), 1, 2)
+
+	// r4 has acted, so abandoning it is a no-op
+	abandon(t, later, r4)
+	requireQueue(t, reserve(t, later), true, now.Add(4*time.Second), 1, 2)
+	requireQueue(t, reserve(t, later), false, now.Add(5*time.Second), 2, 2)
+
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+	invalid := *req
+	invalid.MaxQueue = 0
+	_, err := limiter.Reserve(ctx, &invalid)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+
+	invalid = *req
+	invalid.MaxFutureReserve = time.Second
+	_, err = limiter.Reserve(ctx, &invalid)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+}
+
+func TestLeakyBucketQueue_DriverGORM(t *testing.T) {
+	testLeakyBucketQueue(t, New(NewGormDriver(db)), "TestLeakyBucketQueue_DriverGORM")
+}
+
+func TestLeakyBucketQueue_DriverRedis(t *testing.T) {
+	d, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+	testLeakyBucketQueue(t, New(d), "TestLeakyBucketQueue_DriverRedis")
+}
+
+func TestLeakyBucketQueue_UnsupportedDriver(t *testing.T) {
+	limiter := New(NewMemcachedDriver(nil))
+	req := &ReserveRequest{
+		Key:              "TestLeakyBucketQueue_UnsupportedDriver",
+		DurationPerToken: time.Second,
+		Burst:            1,
+		Tokens:           1,
+		Algorithm:        AlgorithmLeakyBucketQueue,
+		MaxQueue:         1,
+	}
+	_, err := limiter.Reserve(context.Background(), req)
+	require.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+
+	err = limiter.Abandon(context.Background(), &Reservation{ReserveRequest: req, OK: true, QueueID: "1"})
+	require.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+}
@@ -0,0 +1,96 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+type LoggerOptions struct {
+	// DenialLevel is the level of the denials, the default is slog.LevelInfo.
+	DenialLevel slog.Level
+	// DenialSampleRate logs one of every DenialSampleRate denials, 0 and 1 log all of them.
+	DenialSampleRate int
+	// SlowThreshold logs the driver calls slower than it at slog.LevelWarn, 0 disables it.
+	SlowThreshold time.Duration
+}
+
+// LogObserver is an Observer that logs the denials, the driver failures at slog.LevelError and the slow driver calls.
+type LogObserver struct {
+	logger *slog.Logger
+	opts   LoggerOptions
+
+	denials atomic.Uint64
+}
+
+func NewLogObserver(logger *slog.Logger, opts LoggerOptions) *LogObserver {
+	if opts.DenialSampleRate < 1 {
+		opts.DenialSampleRate = 1
+	}
+	return &LogObserver{
+		logger: logger,
+		opts:   opts,
+	}
+}
+
+// WithLogger logs the decisions of the RateLimiter by a LogObserver.
+func WithLogger(logger *slog.Logger, opts LoggerOptions) Option {
+	return WithObserver(NewLogObserver(logger, opts))
+}
+
+func (l *LogObserver) Observe(ctx context.Context, o *Observation) {
+	if l.opts.SlowThreshold > 0 && o.Latency >= l.opts.SlowThreshold {
+		l.logger.LogAttrs(ctx, slog.LevelWarn, "ratelimiter: slow driver call",
+			append(l.attrs(o),
+				slog.Duration("latency", o.Latency),
+				slog.Duration("threshold", l.opts.SlowThreshold),
+			)...,
+		)
+	}
+
+	if o.Err != nil {
+		l.logger.LogAttrs(ctx, slog.LevelError, "ratelimiter: driver failed",
+			append(l.attrs(o),
+				// the error itself keeps the context the drivers wrap it with
+				slog.Any("error", o.Err),
+			)...,
+		)
+		return
+	}
+
+	if o.Reservation.OK || !l.logger.Enabled(ctx, l.opts.DenialLevel) {
+		return
+	}
+	// the first denial is always logged
+	if (l.denials.Add(1)-1)%uint64(l.opts.DenialSampleRate) != 0 {
+		return
+	}
+	attrs := append(l.attrs(o), slog.Duration("retryAfter", o.Reservation.RetryAfterFrom(o.Reservation.Now)))
+	if l.opts.DenialSampleRate > 1 {
+		attrs = append(attrs, slog.Int("sampleRate", l.opts.DenialSampleRate))
+	}
+	msg := "ratelimiter: denied"
+	if o.DryRun {
+		msg = "ratelimiter: would deny"
+	}
+	l.logger.LogAttrs(ctx, l.opts.DenialLevel, msg, attrs...)
+}
+
+func (l *LogObserver) attrs(o *Observation) []slog.Attr {
+	attrs := make([]slog.Attr, 0, 8)
+	if o.Policy != "" {
+		attrs = append(attrs, slog.String("policy", o.Policy))
+	}
+	if o.DryRun {
+		attrs = append(attrs, slog.Bool("dryRun", true))
+	}
+	return append(attrs,
+		slog.String("key", o.Request.Key),
+		slog.String("algorithm", o.Request.Algorithm.String()),
+		slog.Int("tokens", o.Request.Tokens),
+		slog.Int("burst", o.Request.Burst),
+		slog.String("driver", fmt.Sprintf("%T", o.Driver)),
+	)
+}
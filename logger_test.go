@@ -0,0 +1,81 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogObserver(t *testing.T) {
+	errBackend := errors.New("connection refused")
+	driver := DriverFunc(func(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
+		now := time.Now()
+		switch {
+		case strings.HasPrefix(req.Key, "denied"):
+			return newReservation(req, now, now.Add(time.Second), false), nil
+		case strings.HasPrefix(req.Key, "error"):
+			return nil, errors.Wrap(errBackend, "ratelimiter: failed to reserve")
+		case strings.HasPrefix(req.Key, "slow"):
+			time.Sleep(20 * time.Millisecond)
+		}
+		return newReservation(req, now, now, true), nil
+	})
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	limiter := New(driver, WithLogger(logger, LoggerOptions{
+		DenialLevel:      slog.LevelDebug,
+		DenialSampleRate: 2,
+		SlowThreshold:    10 * time.Millisecond,
+	}))
+
+	reserve := func(key string) {
+		_, _ = limiter.Reserve(context.Background(), &ReserveRequest{Key: key, DurationPerToken: time.Second, Burst: 1, Tokens: 1})
+	}
+	reserve("allowed")
+	for range 3 {
+		reserve("denied")
+	}
+	reserve("error")
+	reserve("slow")
+
+	var logs []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var log map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &log))
+		logs = append(logs, log)
+	}
+
+	// 2 of 3 denials are logged with the sample rate 2
+	require.Len(t, logs, 4)
+	for _, log := range logs[:2] {
+		require.Equal(t, "DEBUG", log["level"])
+		require.Equal(t, "ratelimiter: denied", log["msg"])
+		require.Equal(t, "denied", log["key"])
+		require.Equal(t, float64(time.Second), log["retryAfter"])
+		require.Equal(t, float64(2), log["sampleRate"])
+	}
+
+	require.Equal(t, "ERROR", logs[2]["level"])
+	require.Equal(t, "ratelimiter: driver failed", logs[2]["msg"])
+	require.Equal(t, "ratelimiter: failed to reserve: connection refused", logs[2]["error"])
+	require.Equal(t, "ratelimiter.DriverFunc", logs[2]["driver"])
+
+	require.Equal(t, "WARN", logs[3]["level"])
+	require.Equal(t, "ratelimiter: slow driver call", logs[3]["msg"])
+	require.Equal(t, "slow", logs[3]["key"])
+	require.GreaterOrEqual(t, logs[3]["latency"], float64(10*time.Millisecond))
+
+	// the denials below the level of the logger are not logged
+	buf.Reset()
+	limiter = New(driver, WithLogger(slog.New(slog.NewJSONHandler(&buf, nil)), LoggerOptions{DenialLevel: slog.LevelDebug}))
+	reserve("denied")
+	require.Empty(t, buf.String())
+}
@@ -0,0 +1,175 @@
+package ratelimiter
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// BucketState is the portable state of an AlgorithmGCRA bucket, which is exported and imported as a line of JSON.
+type BucketState struct {
+	Key string `json:"key"`
+	// TimeBase is the time the tokens are reserved until, in unix microseconds.
+	TimeBase int64 `json:"timeBase"`
+}
+
+// ImportDriver is implemented by the drivers that can import the state of AlgorithmGCRA buckets.
+type ImportDriver interface {
+	Driver
+	// ImportBucket sets the timeBase of the bucket unless the stored one is later, which has less tokens,
+	// so the buckets can be imported repeatedly and along with the reservations.
+	ImportBucket(ctx context.Context, b BucketState) error
+}
+
+// MigrationStats are the numbers of the buckets of an export, an import or a migration.
+type MigrationStats struct {
+	Buckets int `json:"buckets"`
+	// Skipped is the number of the keys that are not AlgorithmGCRA buckets, e.g. the logs of AlgorithmSlidingWindowLog.
+	Skipped int `json:"skipped"`
+}
+
+const migrationBatchSize = 1000
+
+// scanBuckets calls fn with the state of every AlgorithmGCRA bucket with the prefix batch by batch.
+func scanBuckets(ctx context.Context, d StateDriver, prefix string, fn func(b BucketState) error) (MigrationStats, error) {
+	var stats MigrationStats
+	cursor := ""
+	for {
+		keys, next, err := d.ScanKeys(ctx, prefix, cursor, migrationBatchSize)
+		if err != nil {
+			return stats, err
+		}
+		for _, key := range keys {
+			state, err := d.GetKeyState(ctx, key)
+			if err != nil {
+				return stats, err
+			}
+			if state == nil {
+				continue // deleted during the scan
+			}
+			timeBase, err := strconv.ParseInt(state.Value, 10, 64)
+			if err != nil {
+				stats.Skipped++
+				continue
+			}
+			if err := fn(BucketState{Key: key, TimeBase: timeBase}); err != nil {
+				return stats, err
+			}
+			stats.Buckets++
+		}
+		if next == "" {
+			return stats, nil
+		}
+		cursor = next
+	}
+}
+
+// ExportBuckets writes the AlgorithmGCRA buckets with the prefix to w as JSON lines.
+func ExportBuckets(ctx context.Context, d StateDriver, prefix string, w io.Writer) (MigrationStats, error) {
+	enc := json.NewEncoder(w)
+	return scanBuckets(ctx, d, prefix, func(b BucketState) error {
+		if err := enc.Encode(b); err != nil {
+			return errors.Wrap(err, "ratelimiter: failed to export bucket")
+		}
+		return nil
+	})
+}
+
+// ImportBuckets imports the buckets of the JSON lines written by ExportBuckets.
+func ImportBuckets(ctx context.Context, d ImportDriver, r io.Reader) (MigrationStats, error) {
+	var stats MigrationStats
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	for {
+		var b BucketState
+		err := dec.Decode(&b)
+		if err == io.EOF {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, errors.Wrapf(ErrInvalidParameters, "invalid bucket %d: %v", stats.Buckets+1, err)
+		}
+		if err := d.ImportBucket(ctx, b); err != nil {
+			return stats, err
+		}
+		stats.Buckets++
+	}
+}
+
+// MigrateBuckets copies the AlgorithmGCRA buckets with the prefix from one driver to another batch by batch,
+// e.g. from a GormDriver to a RedisDriver. Use it along with a DualWriteDriver to migrate without downtime.
+func MigrateBuckets(ctx context.Context, from StateDriver, to ImportDriver, prefix string) (MigrationStats, error) {
+	return scanBuckets(ctx, from, prefix, func(b BucketState) error {
+		return to.ImportBucket(ctx, b)
+	})
+}
+
+// DualWriteDriver serves the reservations from the primary driver and writes the resulting state to the secondary,
+// so the secondary can take over once the existing buckets are migrated by MigrateBuckets:
+//
+//  1. serve by NewDualWriteDriver(gormDriver, redisDriver, onError)
+//  2. run MigrateBuckets(ctx, gormDriver, redisDriver, "")
+//  3. serve by redisDriver
+//
+// Only the state of AlgorithmGCRA can be copied, so the other algorithms fail with ErrUnsupportedAlgorithm
+// rather than drifting apart on the two drivers. It also implements HierarchicalDriver, whose levels are copied
+// one by one, if the primary implements it. Adaptive limits and semaphores are not dual-written, since
+// ImportBucket cannot carry their rates and leases, so serve them from the old driver until the switch.
+// It does not implement StateDriver either, MigrateBuckets scans the old driver directly.
+type DualWriteDriver struct {
+	primary   Driver
+	secondary ImportDriver
+	onError   func(ctx context.Context, req *ReserveRequest, err error)
+}
+
+var _ HierarchicalDriver = (*DualWriteDriver)(nil)
+
+// NewDualWriteDriver returns a dual-write driver, the failures of the secondary do not fail the reservations
+// and are reported to onError, which may be nil.
+func NewDualWriteDriver(primary Driver, secondary ImportDriver, onError func(ctx context.Context, req *ReserveRequest, err error)) *DualWriteDriver {
+	return &DualWriteDriver{
+		primary:   primary,
+		secondary: secondary,
+		onError:   onError,
+	}
+}
+
+func (d *DualWriteDriver) Reserve(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
+	if err := validateReserveRequest(req, AlgorithmGCRA); err != nil {
+		return nil, err
+	}
+
+	r, err := d.primary.Reserve(ctx, req)
+	if err != nil || !r.OK {
+		return r, err
+	}
+	d.write(ctx, req, r)
+	return r, nil
+}
+
+func (d *DualWriteDriver) ReserveHierarchy(ctx context.Context, reqs []*ReserveRequest) (*HierarchicalReservation, error) {
+	hd, ok := d.primary.(HierarchicalDriver)
+	if !ok {
+		return nil, errors.Wrap(ErrUnsupportedAlgorithm, "driver does not support hierarchical limits")
+	}
+
+	hr, err := hd.ReserveHierarchy(ctx, reqs)
+	if err != nil || !hr.OK {
+		return hr, err
+	}
+	for i, req := range reqs {
+		d.write(ctx, req, hr.Levels[i])
+	}
+	return hr, nil
+}
+
+// write copies the state of an OK reservation to the secondary.
+func (d *DualWriteDriver) write(ctx context.Context, req *ReserveRequest, r *Reservation) {
+	err := d.secondary.ImportBucket(ctx, BucketState{Key: req.Key, TimeBase: r.TimeToAct.UnixMicro()})
+	if err != nil && d.onError != nil {
+		d.onError(ctx, req, err)
+	}
+}
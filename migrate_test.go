@@ -0,0 +1,181 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+)
+
+type stateImportDriver interface {
+	StateDriver
+	ImportDriver
+}
+
+func testImportBucket(t *testing.T, d stateImportDriver, key string) {
+	ctx := context.Background()
+	timeBase := time.Now().UnixMicro()
+
+	require.NoError(t, d.ImportBucket(ctx, BucketState{Key: key, TimeBase: timeBase}))
+	state, err := d.GetKeyState(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, strconv.FormatInt(timeBase, 10), state.Value)
+
+	// the earlier timeBase has more tokens, so it is ignored
+	require.NoError(t, d.ImportBucket(ctx, BucketState{Key: key, TimeBase: timeBase - 1}))
+	state, err = d.GetKeyState(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, strconv.FormatInt(timeBase, 10), state.Value)
+
+	require.NoError(t, d.ImportBucket(ctx, BucketState{Key: key, TimeBase: timeBase + 1}))
+	state, err = d.GetKeyState(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, strconv.FormatInt(timeBase+1, 10), state.Value)
+
+	// the keys of other algorithms are not overwritten
+	_, err = d.Reserve(ctx, &ReserveRequest{Key: key + ":log", DurationPerToken: time.Second, Burst: 1, Tokens: 1, Algorithm: AlgorithmSlidingWindowLog})
+	require.NoError(t, err)
+	err = d.ImportBucket(ctx, BucketState{Key: key + ":log", TimeBase: timeBase})
+	require.ErrorIs(t, err, ErrInvalidParameters)
+
+	require.ErrorIs(t, d.ImportBucket(ctx, BucketState{TimeBase: timeBase}), ErrInvalidParameters)
+}
+
+func TestImportBucket_DriverRedis(t *testing.T) {
+	d, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+	testImportBucket(t, d, "TestImportBucket_DriverRedis")
+}
+
+func TestImportBucket_DriverGORM(t *testing.T) {
+	testImportBucket(t, NewGormDriver(db), "TestImportBucket_DriverGORM")
+}
+
+// secondRedisDriver returns a driver of another database of the test redis.
+func secondRedisDriver(t *testing.T) *RedisDriver {
+	opts := *redisCli.Options()
+	opts.DB = 1
+	client := redis.NewClient(&opts)
+	t.Cleanup(func() { client.Close() })
+	d, err := InitRedisDriver(context.Background(), client)
+	require.NoError(t, err)
+	return d
+}
+
+func testMigrateBuckets(t *testing.T, from interface {
+	Driver
+	StateDriver
+}, prefix string) {
+	ctx := context.Background()
+	to := secondRedisDriver(t)
+
+	for _, key := range []string{"u1", "u2", "u3"} {
+		r, err := from.Reserve(ctx, &ReserveRequest{Key: prefix + key, DurationPerToken: time.Minute, Burst: 2, Tokens: 2})
+		require.NoError(t, err)
+		require.True(t, r.OK)
+	}
+	_, err := from.Reserve(ctx, &ReserveRequest{Key: prefix + "log", DurationPerToken: time.Minute, Burst: 2, Tokens: 1, Algorithm: AlgorithmSlidingWindowLog})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	stats, err := ExportBuckets(ctx, from, prefix, &buf)
+	require.NoError(t, err)
+	require.Equal(t, MigrationStats{Buckets: 3, Skipped: 1}, stats)
+
+	stats, err = ImportBuckets(ctx, to, &buf)
+	require.NoError(t, err)
+	require.Equal(t, MigrationStats{Buckets: 3}, stats)
+
+	// the migrated buckets are exhausted
+	r, err := to.Reserve(ctx, &ReserveRequest{Key: prefix + "u1", DurationPerToken: time.Minute, Burst: 2, Tokens: 1})
+	require.NoError(t, err)
+	require.False(t, r.OK)
+
+	require.NoError(t, to.ResetKey(ctx, prefix+"u2"))
+	stats, err = MigrateBuckets(ctx, from, to, prefix)
+	require.NoError(t, err)
+	require.Equal(t, MigrationStats{Buckets: 3, Skipped: 1}, stats)
+	r, err = to.Reserve(ctx, &ReserveRequest{Key: prefix + "u2", DurationPerToken: time.Minute, Burst: 2, Tokens: 1})
+	require.NoError(t, err)
+	require.False(t, r.OK)
+
+	_, err = ImportBuckets(ctx, to, bytes.NewBufferString(`{"key": "k", "tokens": 1}`))
+	require.ErrorIs(t, err, ErrInvalidParameters)
+}
+
+func TestMigrateBuckets_DriverRedis(t *testing.T) {
+	d, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+	testMigrateBuckets(t, d, "TestMigrateBuckets_DriverRedis:")
+}
+
+func TestMigrateBuckets_DriverGORM(t *testing.T) {
+	testMigrateBuckets(t, NewGormDriver(db), "TestMigrateBuckets_DriverGORM:")
+}
+
+func TestDualWriteDriver(t *testing.T) {
+	ctx := context.Background()
+	primary, err := InitRedisDriver(ctx, redisCli)
+	require.NoError(t, err)
+	secondary := secondRedisDriver(t)
+
+	var secondaryErrs []error
+	d := NewDualWriteDriver(primary, secondary, func(ctx context.Context, req *ReserveRequest, err error) {
+		secondaryErrs = append(secondaryErrs, err)
+	})
+
+	req := &ReserveRequest{Key: "TestDualWriteDriver:gcra", DurationPerToken: time.Minute, Burst: 3, Tokens: 1}
+	for i := range 4 {
+		r, err := d.Reserve(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, i < 3, r.OK)
+	}
+	primaryState, err := primary.GetKeyState(ctx, req.Key)
+	require.NoError(t, err)
+	secondaryState, err := secondary.GetKeyState(ctx, req.Key)
+	require.NoError(t, err)
+	require.Equal(t, primaryState, secondaryState)
+
+	// the state of the other algorithms cannot be copied
+	for _, algorithm := range []Algorithm{AlgorithmSlidingWindowLog, AlgorithmSlidingWindowCounter, AlgorithmLeakyBucketQueue} {
+		_, err := d.Reserve(ctx, &ReserveRequest{Key: "TestDualWriteDriver:other", DurationPerToken: time.Minute, Burst: 1, Tokens: 1, Algorithm: algorithm, MaxQueue: 1})
+		require.ErrorIs(t, err, ErrUnsupportedAlgorithm, "algorithm: %v", algorithm)
+	}
+	for _, driver := range []StateDriver{primary, secondary} {
+		state, err := driver.GetKeyState(ctx, "TestDualWriteDriver:other")
+		require.NoError(t, err)
+		require.Nil(t, state)
+	}
+
+	// the levels of a hierarchy are copied one by one
+	levels := []*ReserveRequest{
+		{Key: "TestDualWriteDriver:{h}:global", DurationPerToken: time.Minute, Burst: 3, Tokens: 1},
+		{Key: "TestDualWriteDriver:{h}:user", DurationPerToken: time.Minute, Burst: 1, Tokens: 1},
+	}
+	for i := range 2 {
+		hr, err := New(d).ReserveHierarchy(ctx, levels...)
+		require.NoError(t, err)
+		require.Equal(t, i == 0, hr.OK)
+	}
+	for _, level := range levels {
+		primaryState, err := primary.GetKeyState(ctx, level.Key)
+		require.NoError(t, err)
+		secondaryState, err := secondary.GetKeyState(ctx, level.Key)
+		require.NoError(t, err)
+		require.Equal(t, primaryState, secondaryState)
+	}
+
+	// the failures of the secondary are reported only
+	require.NoError(t, secondary.ResetKey(ctx, "TestDualWriteDriver:conflict"))
+	_, err = secondary.Reserve(ctx, &ReserveRequest{Key: "TestDualWriteDriver:conflict", DurationPerToken: time.Minute, Burst: 1, Tokens: 1, Algorithm: AlgorithmSlidingWindowLog})
+	require.NoError(t, err)
+	r, err := d.Reserve(ctx, &ReserveRequest{Key: "TestDualWriteDriver:conflict", DurationPerToken: time.Minute, Burst: 1, Tokens: 1})
+	require.NoError(t, err)
+	require.True(t, r.OK)
+	require.Len(t, secondaryErrs, 1)
+	require.ErrorIs(t, secondaryErrs[0], ErrInvalidParameters)
+}
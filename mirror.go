@@ -0,0 +1,239 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MirrorResult is the result of comparing the decisions of the primary and the secondary of a MirrorDriver.
+type MirrorResult int
+
+const (
+	// MirrorAgreed means both drivers allowed or both denied the request.
+	MirrorAgreed MirrorResult = iota
+	// MirrorPrimaryOnly means only the primary allowed the request.
+	MirrorPrimaryOnly
+	// MirrorSecondaryOnly means only the secondary allowed the request.
+	MirrorSecondaryOnly
+	// MirrorSecondaryFailed means the secondary failed.
+	MirrorSecondaryFailed
+)
+
+func (r MirrorResult) String() string {
+	switch r {
+	case MirrorAgreed:
+		return "agreed"
+	case MirrorPrimaryOnly:
+		return "primary_only"
+	case MirrorSecondaryOnly:
+		return "secondary_only"
+	case MirrorSecondaryFailed:
+		return "secondary_failed"
+	default:
+		return fmt.Sprintf("MirrorResult(%d)", int(r))
+	}
+}
+
+// MirrorComparison is the comparison of the decisions of a request.
+type MirrorComparison struct {
+	Request         *ReserveRequest
+	PrimaryDriver   Driver
+	SecondaryDriver Driver
+	Primary         *Reservation
+	// Secondary is nil if SecondaryErr is not nil.
+	Secondary    *Reservation
+	SecondaryErr error
+	Result       MirrorResult
+	// Drift is the TimeToAct of the secondary minus the one of the primary if both allowed the request,
+	// e.g. a positive drift means the secondary has less tokens. It includes the skew between the clocks of the storages.
+	Drift time.Duration
+}
+
+// MirrorStats are the numbers of the comparisons of a MirrorDriver by result.
+type MirrorStats struct {
+	Agreed          uint64
+	PrimaryOnly     uint64
+	SecondaryOnly   uint64
+	SecondaryFailed uint64
+}
+
+type mirrorDrivers struct {
+	primary   Driver
+	secondary Driver
+}
+
+// DefaultMirrorSecondaryTimeout is the default timeout of the reservations on the secondary of a MirrorDriver.
+const DefaultMirrorSecondaryTimeout = time.Second
+
+// MirrorOption configures a MirrorDriver.
+type MirrorOption func(d *MirrorDriver)
+
+// WithSecondaryTimeout sets the timeout of the reservations on the secondary, after which they are compared as failed.
+func WithSecondaryTimeout(timeout time.Duration) MirrorOption {
+	return func(d *MirrorDriver) {
+		d.secondaryTimeout = timeout
+	}
+}
+
+// MirrorDriver reserves every request on both a primary and a secondary driver and serves the decision of the primary,
+// e.g. to verify a new storage before switching to it. The disagreements are counted and reported to onCompare.
+// Unlike DualWriteDriver, the state is not copied, so migrate the buckets first for the drivers to agree.
+//
+// The decision of the primary is returned as soon as it is made, the secondary is reserved concurrently
+// with its own timeout, which is not canceled along with the call, and the comparison is done in the background.
+// So a slow or hanging secondary does not slow down the calls.
+//
+// Only AlgorithmGCRA and the other algorithms without a queue are mirrored. It also implements HierarchicalDriver
+// and QueueDriver, which are served by the current primary without mirroring, so a reservation of
+// AlgorithmLeakyBucketQueue cannot be abandoned after a Flip. Adaptive limits and semaphores are not mirrored,
+// reporting an outcome or renewing a lease on a secondary that never granted the reservation would only drift,
+// and StateDriver is not implemented since the keys of the two drivers differ until the migration is done.
+type MirrorDriver struct {
+	drivers          atomic.Pointer[mirrorDrivers]
+	onCompare        func(ctx context.Context, c *MirrorComparison)
+	secondaryTimeout time.Duration
+
+	agreed          atomic.Uint64
+	primaryOnly     atomic.Uint64
+	secondaryOnly   atomic.Uint64
+	secondaryFailed atomic.Uint64
+}
+
+var (
+	_ HierarchicalDriver = (*MirrorDriver)(nil)
+	_ QueueDriver        = (*MirrorDriver)(nil)
+)
+
+// NewMirrorDriver returns a mirroring driver, onCompare is called in the background with every comparison and may be nil.
+func NewMirrorDriver(primary, secondary Driver, onCompare func(ctx context.Context, c *MirrorComparison), opts ...MirrorOption) *MirrorDriver {
+	d := &MirrorDriver{
+		onCompare:        onCompare,
+		secondaryTimeout: DefaultMirrorSecondaryTimeout,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.drivers.Store(&mirrorDrivers{primary: primary, secondary: secondary})
+	return d
+}
+
+// Primary returns the driver whose decisions are served.
+func (d *MirrorDriver) Primary() Driver {
+	return d.drivers.Load().primary
+}
+
+// Secondary returns the mirrored driver.
+func (d *MirrorDriver) Secondary() Driver {
+	return d.drivers.Load().secondary
+}
+
+// Flip swaps the primary and the secondary atomically, the calls in flight finish with the previous primary.
+func (d *MirrorDriver) Flip() {
+	for {
+		current := d.drivers.Load()
+		if d.drivers.CompareAndSwap(current, &mirrorDrivers{primary: current.secondary, secondary: current.primary}) {
+			return
+		}
+	}
+}
+
+// Stats returns the numbers of the comparisons so far.
+func (d *MirrorDriver) Stats() MirrorStats {
+	return MirrorStats{
+		Agreed:          d.agreed.Load(),
+		PrimaryOnly:     d.primaryOnly.Load(),
+		SecondaryOnly:   d.secondaryOnly.Load(),
+		SecondaryFailed: d.secondaryFailed.Load(),
+	}
+}
+
+// Reserve reserves the request on both drivers concurrently and returns the decision of the primary,
+// the failures of the secondary do not fail the reservation.
+func (d *MirrorDriver) Reserve(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
+	drivers := d.drivers.Load()
+	if req.Algorithm == AlgorithmLeakyBucketQueue {
+		return drivers.primary.Reserve(ctx, req)
+	}
+
+	// the secondary is not bound to the call, so it is compared even if the call returns first
+	primaryCh := make(chan *Reservation, 1)
+	go d.mirror(context.WithoutCancel(ctx), drivers, req, primaryCh)
+
+	r, err := drivers.primary.Reserve(ctx, req)
+	if err != nil {
+		close(primaryCh)
+		return nil, err
+	}
+	primaryCh <- r
+	return r, nil
+}
+
+// mirror reserves the request on the secondary and compares it with the reservation of the primary,
+// which is not compared if primaryCh is closed.
+func (d *MirrorDriver) mirror(ctx context.Context, drivers *mirrorDrivers, req *ReserveRequest, primaryCh <-chan *Reservation) {
+	secondaryCtx, cancel := context.WithTimeout(ctx, d.secondaryTimeout)
+	defer cancel()
+
+	secondary, secondaryErr := drivers.secondary.Reserve(secondaryCtx, req)
+	if secondaryErr == nil && secondaryCtx.Err() != nil {
+		secondaryErr = errors.Wrap(secondaryCtx.Err(), "ratelimiter: secondary timed out")
+	}
+	r, ok := <-primaryCh
+	if !ok {
+		return
+	}
+
+	c := &MirrorComparison{
+		Request:         req,
+		PrimaryDriver:   drivers.primary,
+		SecondaryDriver: drivers.secondary,
+		Primary:         r,
+		SecondaryErr:    secondaryErr,
+	}
+	if secondaryErr == nil {
+		c.Secondary = secondary
+	}
+	switch {
+	case secondaryErr != nil:
+		c.Result = MirrorSecondaryFailed
+		d.secondaryFailed.Add(1)
+	case r.OK == secondary.OK:
+		c.Result = MirrorAgreed
+		if r.OK {
+			c.Drift = secondary.TimeToAct.Sub(r.TimeToAct)
+		}
+		d.agreed.Add(1)
+	case r.OK:
+		c.Result = MirrorPrimaryOnly
+		d.primaryOnly.Add(1)
+	default:
+		c.Result = MirrorSecondaryOnly
+		d.secondaryOnly.Add(1)
+	}
+
+	if d.onCompare != nil {
+		d.onCompare(ctx, c)
+	}
+}
+
+// ReserveHierarchy reserves the levels on the primary only.
+func (d *MirrorDriver) ReserveHierarchy(ctx context.Context, reqs []*ReserveRequest) (*HierarchicalReservation, error) {
+	hd, ok := d.Primary().(HierarchicalDriver)
+	if !ok {
+		return nil, errors.Wrap(ErrUnsupportedAlgorithm, "driver does not support hierarchical limits")
+	}
+	return hd.ReserveHierarchy(ctx, reqs)
+}
+
+// AbandonQueue abandons the reservation on the current primary.
+func (d *MirrorDriver) AbandonQueue(ctx context.Context, key string, id string) error {
+	qd, ok := d.Primary().(QueueDriver)
+	if !ok {
+		return errors.Wrapf(ErrUnsupportedAlgorithm, "%v", AlgorithmLeakyBucketQueue)
+	}
+	return qd.AbandonQueue(ctx, key, id)
+}
@@ -0,0 +1,123 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirrorDriver(t *testing.T) {
+	oldDriver, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+	newDriver := secondRedisDriver(t)
+
+	now := time.Now().Truncate(time.Microsecond)
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+
+	// the comparisons are done in the background
+	comparisons := make(chan *MirrorComparison, 16)
+	d := NewMirrorDriver(oldDriver, newDriver, func(ctx context.Context, c *MirrorComparison) {
+		comparisons <- c
+	})
+	require.Equal(t, Driver(oldDriver), d.Primary())
+
+	req := &ReserveRequest{Key: "TestMirrorDriver:k1", DurationPerToken: time.Second, Burst: 2, Tokens: 1}
+	// the new driver has less tokens, e.g. the bucket is not migrated
+	_, err = newDriver.Reserve(ctx, req)
+	require.NoError(t, err)
+
+	var results []MirrorResult
+	for i := range 3 {
+		r, err := d.Reserve(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, i < 2, r.OK)
+
+		c := <-comparisons
+		results = append(results, c.Result)
+		if i == 0 {
+			require.Equal(t, time.Second, c.Drift)
+		}
+	}
+	require.Equal(t, []MirrorResult{MirrorAgreed, MirrorPrimaryOnly, MirrorAgreed}, results)
+	require.Equal(t, MirrorStats{Agreed: 2, PrimaryOnly: 1}, d.Stats())
+
+	// the decisions are served by the new driver after the flip
+	d.Flip()
+	require.Equal(t, Driver(newDriver), d.Primary())
+	require.Equal(t, Driver(oldDriver), d.Secondary())
+	require.NoError(t, oldDriver.ResetKey(ctx, req.Key))
+	r, err := d.Reserve(ctx, req)
+	require.NoError(t, err)
+	require.False(t, r.OK)
+	c := <-comparisons
+	require.Equal(t, MirrorSecondaryOnly, c.Result)
+	require.Equal(t, Driver(newDriver), c.PrimaryDriver)
+
+	// the failures of the secondary do not fail the reservations
+	errBackend := errors.New("connection refused")
+	d = NewMirrorDriver(oldDriver, DriverFunc(func(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
+		return nil, errBackend
+	}), nil)
+	r, err = d.Reserve(ctx, &ReserveRequest{Key: "TestMirrorDriver:k2", DurationPerToken: time.Second, Burst: 1, Tokens: 1})
+	require.NoError(t, err)
+	require.True(t, r.OK)
+	require.Eventually(t, func() bool {
+		return d.Stats() == MirrorStats{SecondaryFailed: 1}
+	}, time.Second, time.Millisecond)
+
+	// the failures of the primary do
+	d.Flip()
+	_, err = d.Reserve(ctx, &ReserveRequest{Key: "TestMirrorDriver:k2", DurationPerToken: time.Second, Burst: 1, Tokens: 1})
+	require.ErrorIs(t, err, errBackend)
+}
+
+func TestMirrorDriver_SlowSecondary(t *testing.T) {
+	primary, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+
+	release := make(chan struct{})
+	defer close(release)
+	hanging := DriverFunc(func(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
+		select {
+		case <-release:
+			return nil, errors.New("released")
+		case <-ctx.Done():
+			return nil, errors.Wrap(ctx.Err(), "context done")
+		}
+	})
+
+	comparisons := make(chan *MirrorComparison, 1)
+	d := NewMirrorDriver(primary, hanging, func(ctx context.Context, c *MirrorComparison) {
+		comparisons <- c
+	}, WithSecondaryTimeout(200*time.Millisecond))
+
+	// the decision of the primary is served without waiting for the secondary
+	start := time.Now()
+	r, err := d.Reserve(context.Background(), &ReserveRequest{Key: "TestMirrorDriver_SlowSecondary", DurationPerToken: time.Second, Burst: 1, Tokens: 1})
+	require.NoError(t, err)
+	require.True(t, r.OK)
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+
+	// the secondary is compared as failed once it times out
+	c := <-comparisons
+	require.Equal(t, MirrorSecondaryFailed, c.Result)
+	require.ErrorIs(t, c.SecondaryErr, context.DeadlineExceeded)
+	require.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestMirrorDriver_LeakyBucketQueue(t *testing.T) {
+	primary, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+	secondary := DriverFunc(func(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
+		t.Error("the queue should not be mirrored")
+		return nil, errors.New("mirrored")
+	})
+
+	// the queue is served and abandoned by the primary only
+	testLeakyBucketQueue(t, New(NewMirrorDriver(primary, secondary, nil)), "TestMirrorDriver_LeakyBucketQueue")
+}
@@ -0,0 +1,100 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+)
+
+// Observation is a decision of a RateLimiter.
+type Observation struct {
+	// Policy is the name of the policy of a PolicyLimiter, it is empty for the calls of RateLimiter.
+	Policy  string
+	Request *ReserveRequest
+	// Reservation is nil if Err is not nil.
+	Reservation *Reservation
+	Err         error
+	// Driver is the driver that made the decision and Latency is the duration of its call.
+	Driver  Driver
+	Latency time.Duration
+	// DryRun reports that the call is a dry run, the Request and the Reservation are of the shadow bucket,
+	// and the call is allowed whatever the Reservation is.
+	DryRun bool
+}
+
+// Observer receives every decision of a RateLimiter, e.g. for logging, metrics and auditing.
+// It is called synchronously after the driver returns, so it must be fast and safe for concurrent use.
+type Observer interface {
+	Observe(ctx context.Context, o *Observation)
+}
+
+type ObserverFunc func(ctx context.Context, o *Observation)
+
+func (f ObserverFunc) Observe(ctx context.Context, o *Observation) {
+	f(ctx, o)
+}
+
+// Option configures a RateLimiter.
+type Option func(lim *RateLimiter)
+
+// WithObserver adds observers to the RateLimiter, they are called in order.
+func WithObserver(observers ...Observer) Option {
+	return func(lim *RateLimiter) {
+		lim.observers = append(lim.observers, observers...)
+	}
+}
+
+// reserve reserves the request and reports the decision to the observers.
+func (lim *RateLimiter) reserve(ctx context.Context, policy string, dryRun bool, req *ReserveRequest) (*Reservation, error) {
+	if dryRun || lim.dryRun {
+		return lim.reserveDryRun(ctx, policy, req)
+	}
+	if len(lim.observers) == 0 {
+		return lim.driver.Reserve(ctx, req)
+	}
+
+	start := time.Now()
+	r, err := lim.driver.Reserve(ctx, req)
+	lim.observe(ctx, &Observation{
+		Policy:      policy,
+		Request:     req,
+		Reservation: r,
+		Err:         err,
+		Driver:      lim.driver,
+		Latency:     time.Since(start),
+	})
+	return r, err
+}
+
+// reserveHierarchy reserves the levels and reports the decision of every level to the observers,
+// each with the latency of the whole call.
+func (lim *RateLimiter) reserveHierarchy(ctx context.Context, d HierarchicalDriver, reqs []*ReserveRequest) (*HierarchicalReservation, error) {
+	if lim.dryRun {
+		return lim.reserveHierarchyDryRun(ctx, d, reqs)
+	}
+	if len(lim.observers) == 0 {
+		return d.ReserveHierarchy(ctx, reqs)
+	}
+
+	start := time.Now()
+	hr, err := d.ReserveHierarchy(ctx, reqs)
+	latency := time.Since(start)
+	for i, req := range reqs {
+		o := &Observation{
+			Request: req,
+			Err:     err,
+			Driver:  lim.driver,
+			Latency: latency,
+		}
+		if err == nil {
+			o.Reservation = hr.Levels[i]
+		}
+		lim.observe(ctx, o)
+	}
+	return hr, err
+}
+
+func (lim *RateLimiter) observe(ctx context.Context, o *Observation) {
+	for _, observer := range lim.observers {
+		observer.Observe(ctx, o)
+	}
+}
@@ -0,0 +1,105 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingObserver struct {
+	mu           sync.Mutex
+	observations []*Observation
+}
+
+func (o *recordingObserver) Observe(ctx context.Context, obs *Observation) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.observations = append(o.observations, obs)
+}
+
+func (o *recordingObserver) take() []*Observation {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	observations := o.observations
+	o.observations = nil
+	return observations
+}
+
+func TestObserver(t *testing.T) {
+	d, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+
+	observer := &recordingObserver{}
+	var calls int
+	limiter := New(d, WithObserver(observer), WithObserver(ObserverFunc(func(ctx context.Context, o *Observation) {
+		calls++
+	})))
+
+	now := time.Now().Truncate(time.Microsecond)
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+
+	req := &ReserveRequest{
+		Key:              "TestObserver:k1",
+		DurationPerToken: time.Second,
+		Burst:            1,
+		Tokens:           1,
+	}
+	allowed, err := limiter.Allow(ctx, &AllowRequest{Key: req.Key, DurationPerToken: req.DurationPerToken, Burst: req.Burst, Tokens: req.Tokens})
+	require.NoError(t, err)
+	require.True(t, allowed)
+	r, err := limiter.Reserve(ctx, req)
+	require.NoError(t, err)
+	require.False(t, r.OK)
+	_, err = limiter.Reserve(ctx, &ReserveRequest{Key: "TestObserver:invalid"})
+	require.ErrorIs(t, err, ErrInvalidParameters)
+
+	observations := observer.take()
+	require.Len(t, observations, 3)
+	require.Equal(t, 3, calls)
+	require.True(t, observations[0].Reservation.OK)
+	require.False(t, observations[1].Reservation.OK)
+	require.Same(t, req, observations[1].Request)
+	require.Same(t, r, observations[1].Reservation)
+	require.Nil(t, observations[2].Reservation)
+	require.ErrorIs(t, observations[2].Err, ErrInvalidParameters)
+	for _, o := range observations {
+		require.Empty(t, o.Policy)
+		require.Equal(t, Driver(d), o.Driver)
+		require.Greater(t, o.Latency, time.Duration(0))
+	}
+
+	// the status of a quota is not a decision
+	_, err = limiter.QuotaStatus(ctx, &ReserveRequest{Key: "TestObserver:quota", Burst: 1, Algorithm: AlgorithmQuota, Period: PeriodDay})
+	require.NoError(t, err)
+	require.Empty(t, observer.take())
+
+	hr, err := limiter.ReserveHierarchy(ctx,
+		&ReserveRequest{Key: "TestObserver:{h}:global", DurationPerToken: time.Second, Burst: 10, Tokens: 1},
+		&ReserveRequest{Key: "TestObserver:{h}:user", DurationPerToken: time.Second, Burst: 1, Tokens: 1},
+	)
+	require.NoError(t, err)
+	observations = observer.take()
+	require.Len(t, observations, 2)
+	for i, o := range observations {
+		require.Same(t, hr.Levels[i], o.Reservation)
+	}
+
+	registry := NewPolicyRegistry()
+	require.NoError(t, registry.Register(Policy{Name: "login", Rate: Rate{Tokens: 1, Per: time.Second}, Burst: 1, KeyTemplate: "TestObserver:login:{userID}"}))
+	policyLimiter := NewPolicyLimiter(d, registry, WithObserver(observer))
+	_, err = policyLimiter.Allow(ctx, "login", "u1")
+	require.NoError(t, err)
+	_, err = policyLimiter.Reserve(ctx, "login", "u1")
+	require.NoError(t, err)
+	observations = observer.take()
+	require.Len(t, observations, 2)
+	for _, o := range observations {
+		require.Equal(t, "login", o.Policy)
+		require.Equal(t, "TestObserver:login:u1", o.Request.Key)
+	}
+}
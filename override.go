@@ -0,0 +1,324 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// OverrideAction is the action of a KeyOverride.
+type OverrideAction int
+
+const (
+	// OverrideActionLimit limits the key by the overridden DurationPerToken and Burst.
+	OverrideActionLimit OverrideAction = iota
+	// OverrideActionAllow always admits the key without consuming tokens, e.g. internal callers.
+	OverrideActionAllow
+	// OverrideActionDeny always rejects the key.
+	OverrideActionDeny
+)
+
+func (a OverrideAction) String() string {
+	switch a {
+	case OverrideActionLimit:
+		return "limit"
+	case OverrideActionAllow:
+		return "allow"
+	case OverrideActionDeny:
+		return "deny"
+	default:
+		return fmt.Sprintf("OverrideAction(%d)", int(a))
+	}
+}
+
+func (a OverrideAction) MarshalText() ([]byte, error) {
+	if a < OverrideActionLimit || a > OverrideActionDeny {
+		return nil, errors.Wrapf(ErrInvalidParameters, "override action %v", a)
+	}
+	return []byte(a.String()), nil
+}
+
+func (a *OverrideAction) UnmarshalText(text []byte) error {
+	for action := OverrideActionLimit; action <= OverrideActionDeny; action++ {
+		if action.String() == string(text) {
+			*a = action
+			return nil
+		}
+	}
+	return errors.Wrapf(ErrInvalidParameters, "unknown override action %q", text)
+}
+
+// KeyOverride overrides the limit of a key, e.g. a custom limit negotiated by an enterprise customer.
+type KeyOverride struct {
+	Action OverrideAction `json:"action"`
+	// DurationPerToken and Burst are used by OverrideActionLimit, the zero fields are not overridden.
+	// Burst is raised to the Tokens and Floor of a request if it is lower, so the request stays valid.
+	DurationPerToken time.Duration `json:"durationPerToken,omitempty"`
+	Burst            int           `json:"burst,omitempty"`
+	// ExpiresAt is the time the override expires, the zero value means never.
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func validateKeyOverride(key string, o *KeyOverride) error {
+	if key == "" || o == nil || o.Action < OverrideActionLimit || o.Action > OverrideActionDeny ||
+		o.DurationPerToken < 0 || o.Burst < 0 ||
+		(o.Action == OverrideActionLimit && o.DurationPerToken == 0 && o.Burst == 0) {
+		return errors.Wrapf(ErrInvalidParameters, "override of key %q: %+v", key, o)
+	}
+	return nil
+}
+
+// expired reports whether the override has expired at now.
+func (o *KeyOverride) expired(now time.Time) bool {
+	return !o.ExpiresAt.IsZero() && !o.ExpiresAt.After(now)
+}
+
+// OverrideStore is the storage of the overrides of keys.
+type OverrideStore interface {
+	// GetOverride returns the unexpired override of the key, or nil if there is none.
+	GetOverride(ctx context.Context, key string) (*KeyOverride, error)
+	// SetOverride sets the override of the key, which expires after ttl unless ttl is 0.
+	SetOverride(ctx context.Context, key string, o *KeyOverride, ttl time.Duration) error
+	// DeleteOverride deletes the override of the key, it is a no-op if there is none.
+	DeleteOverride(ctx context.Context, key string) error
+}
+
+func overrideNow(ctx context.Context) time.Time {
+	if Test {
+		nowFunc, exists := NowFuncFromContextForTest(ctx)
+		if exists {
+			return nowFunc().UTC() // stripMono
+		}
+	}
+	return time.Now().UTC() // stripMono
+}
+
+// OverrideDriver is a Driver that applies the overrides of a store in front of another Driver.
+// It also implements QueueDriver and HierarchicalDriver, which fail with ErrUnsupportedAlgorithm
+// unless the wrapped driver implements them. AdaptiveDriver and SemaphoreDriver are not implemented,
+// since an override replaces the DurationPerToken and Burst of a ReserveRequest, which adaptive requests and leases
+// do not have. Neither is StateDriver, the overrides do not change the stored buckets,
+// so inspect and reset them through the wrapped driver, e.g. in the admin handler.
+type OverrideDriver struct {
+	driver Driver
+	store  OverrideStore
+}
+
+// NewOverrideDriver returns a Driver that looks up the override of every key in the store,
+// the allowed keys are admitted and the denied keys are rejected without calling the driver.
+func NewOverrideDriver(driver Driver, store OverrideStore) *OverrideDriver {
+	return &OverrideDriver{
+		driver: driver,
+		store:  store,
+	}
+}
+
+var (
+	_ QueueDriver        = (*OverrideDriver)(nil)
+	_ HierarchicalDriver = (*OverrideDriver)(nil)
+)
+
+// deniedRetryAfter is the retry after of a key denied by an override that never expires.
+const deniedRetryAfter = 24 * time.Hour
+
+func (d *OverrideDriver) Reserve(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
+	if err := validateReserveRequest(req); err != nil {
+		return nil, err
+	}
+
+	o, err := d.store.GetOverride(ctx, req.Key)
+	if err != nil {
+		return nil, err
+	}
+	if r := overriddenReservation(ctx, req, o); r != nil {
+		return r, nil
+	}
+	return d.driver.Reserve(ctx, overriddenRequest(req, o))
+}
+
+// overriddenReservation returns the reservation of a key that is allowed or denied by its override,
+// or nil if the driver decides.
+func overriddenReservation(ctx context.Context, req *ReserveRequest, o *KeyOverride) *Reservation {
+	if o == nil {
+		return nil
+	}
+
+	switch o.Action {
+	case OverrideActionAllow:
+		now := overrideNow(ctx)
+		return newReservation(req, now, now, true)
+	case OverrideActionDeny:
+		now := overrideNow(ctx)
+		// retry after the override expires
+		retryAt := o.ExpiresAt
+		if retryAt.IsZero() {
+			retryAt = now.Add(deniedRetryAfter)
+		}
+		return newReservation(req, now, retryAt.Add(req.MaxFutureReserve), false)
+	default:
+		return nil
+	}
+}
+
+// overriddenRequest returns the request with the limit of its override, if any.
+func overriddenRequest(req *ReserveRequest, o *KeyOverride) *ReserveRequest {
+	if o == nil || o.Action != OverrideActionLimit {
+		return req
+	}
+
+	overridden := *req
+	if o.DurationPerToken > 0 {
+		overridden.DurationPerToken = o.DurationPerToken
+	}
+	if o.Burst > 0 {
+		overridden.Burst = max(o.Burst, req.Tokens+req.Floor)
+	}
+	return &overridden
+}
+
+// ReserveHierarchy applies the override of every level, the allowed levels are skipped and the others are reserved
+// atomically by the wrapped driver. If a level is denied by its override, nothing is reserved
+// and the other levels are reported as OK at now.
+func (d *OverrideDriver) ReserveHierarchy(ctx context.Context, reqs []*ReserveRequest) (*HierarchicalReservation, error) {
+	hd, ok := d.driver.(HierarchicalDriver)
+	if !ok {
+		return nil, errors.Wrap(ErrUnsupportedAlgorithm, "driver does not support hierarchical limits")
+	}
+	if err := validateHierarchy(reqs); err != nil {
+		return nil, err
+	}
+
+	levels := make([]*Reservation, len(reqs))
+	var reserved []*ReserveRequest
+	var reservedIdx []int
+	denied := false
+	for i, req := range reqs {
+		o, err := d.store.GetOverride(ctx, req.Key)
+		if err != nil {
+			return nil, err
+		}
+		if r := overriddenReservation(ctx, req, o); r != nil {
+			levels[i] = r
+			denied = denied || !r.OK
+			continue
+		}
+		reserved = append(reserved, overriddenRequest(req, o))
+		reservedIdx = append(reservedIdx, i)
+	}
+
+	now := overrideNow(ctx)
+	if denied || len(reserved) == 0 {
+		for i, req := range reqs {
+			if levels[i] == nil {
+				levels[i] = newReservation(req, now, now, true)
+			}
+		}
+		return newHierarchicalReservation(levels, now), nil
+	}
+
+	hr, err := hd.ReserveHierarchy(ctx, reserved)
+	if err != nil {
+		return nil, err
+	}
+	for i, r := range hr.Levels {
+		levels[reservedIdx[i]] = r
+	}
+	return newHierarchicalReservation(levels, hr.Now), nil
+}
+
+func (d *OverrideDriver) AbandonQueue(ctx context.Context, key string, id string) error {
+	qd, ok := d.driver.(QueueDriver)
+	if !ok {
+		return errors.Wrapf(ErrUnsupportedAlgorithm, "%v", AlgorithmLeakyBucketQueue)
+	}
+	return qd.AbandonQueue(ctx, key, id)
+}
+
+// CachedOverrideStore caches the overrides of another store in memory, including the keys without overrides,
+// so the changes of other instances are seen after ttl at most.
+type CachedOverrideStore struct {
+	store      OverrideStore
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]cachedOverride
+	// version is increased by every write, so a lookup that races a write does not cache the stale override.
+	version uint64
+}
+
+type cachedOverride struct {
+	override  *KeyOverride
+	expiresAt time.Time
+}
+
+// NewCachedOverrideStore returns a store that caches at most maxEntries keys for ttl.
+func NewCachedOverrideStore(store OverrideStore, ttl time.Duration, maxEntries int) (*CachedOverrideStore, error) {
+	if store == nil || ttl <= 0 || maxEntries <= 0 {
+		return nil, errors.Wrapf(ErrInvalidParameters, "ttl: %v, maxEntries: %d", ttl, maxEntries)
+	}
+	return &CachedOverrideStore{
+		store:      store,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]cachedOverride),
+	}, nil
+}
+
+func (s *CachedOverrideStore) GetOverride(ctx context.Context, key string) (*KeyOverride, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	entry, ok := s.entries[key]
+	version := s.version
+	s.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) && (entry.override == nil || !entry.override.expired(overrideNow(ctx))) {
+		return entry.override, nil
+	}
+
+	o, err := s.store.GetOverride(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.version != version {
+		return o, nil // written meanwhile, o may be stale
+	}
+	if len(s.entries) >= s.maxEntries {
+		for k, e := range s.entries {
+			if !now.Before(e.expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+		// drop everything rather than tracking the recency of the entries
+		if len(s.entries) >= s.maxEntries {
+			clear(s.entries)
+		}
+	}
+	s.entries[key] = cachedOverride{override: o, expiresAt: now.Add(s.ttl)}
+	return o, nil
+}
+
+// SetOverride sets the override in the store and then invalidates the cached key,
+// the lookups in flight do not cache what they read before the write.
+func (s *CachedOverrideStore) SetOverride(ctx context.Context, key string, o *KeyOverride, ttl time.Duration) error {
+	defer s.invalidate(key)
+	return s.store.SetOverride(ctx, key, o, ttl)
+}
+
+func (s *CachedOverrideStore) DeleteOverride(ctx context.Context, key string) error {
+	defer s.invalidate(key)
+	return s.store.DeleteOverride(ctx, key)
+}
+
+func (s *CachedOverrideStore) invalidate(key string) {
+	s.mu.Lock()
+	delete(s.entries, key)
+	s.version++
+	s.mu.Unlock()
+}
@@ -0,0 +1,98 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// KVOverride is the override of a key, which is used by GormOverrideStore.
+type KVOverride struct {
+	Key    string `json:"key" gorm:"primaryKey;not null;"`
+	Action string `json:"action" gorm:"not null;"`
+	// DurationPerToken is in nanoseconds, the same as time.Duration.
+	DurationPerToken int64 `json:"durationPerToken" gorm:"not null;"`
+	Burst            int   `json:"burst" gorm:"not null;"`
+	// ExpiresAt is in unix microseconds, 0 means never.
+	ExpiresAt int64 `json:"expiresAt" gorm:"not null;"`
+}
+
+// GormOverrideStore is an OverrideStore that uses Gorm as the storage.
+type GormOverrideStore struct {
+	db *gorm.DB
+}
+
+// NewGormOverrideStore returns an OverrideStore that uses Gorm as the storage.
+// Sometimes you may need to auto migrate the KVOverride table, you can use `InitGormOverrideStore` instead.
+func NewGormOverrideStore(db *gorm.DB) *GormOverrideStore {
+	return &GormOverrideStore{
+		db: db,
+	}
+}
+
+// InitGormOverrideStore initializes a GormOverrideStore with the provided Gorm DB.
+func InitGormOverrideStore(ctx context.Context, db *gorm.DB) (*GormOverrideStore, error) {
+	if err := db.WithContext(ctx).AutoMigrate(&KVOverride{}); err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to migrate kv override")
+	}
+
+	return NewGormOverrideStore(db), nil
+}
+
+func (s *GormOverrideStore) GetOverride(ctx context.Context, key string) (*KeyOverride, error) {
+	var kv KVOverride
+	if err := s.db.WithContext(ctx).Where(
+		"key = ? AND (expires_at = 0 OR expires_at > ?)", key, overrideNow(ctx).UnixMicro(),
+	).Take(&kv).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "ratelimiter: failed to get override")
+	}
+
+	o := &KeyOverride{
+		DurationPerToken: time.Duration(kv.DurationPerToken),
+		Burst:            kv.Burst,
+	}
+	if err := o.Action.UnmarshalText([]byte(kv.Action)); err != nil {
+		return nil, err
+	}
+	if kv.ExpiresAt > 0 {
+		o.ExpiresAt = time.UnixMicro(kv.ExpiresAt).UTC()
+	}
+	return o, nil
+}
+
+func (s *GormOverrideStore) SetOverride(ctx context.Context, key string, o *KeyOverride, ttl time.Duration) error {
+	if err := validateKeyOverride(key, o); err != nil {
+		return err
+	}
+	if ttl < 0 {
+		return errors.Wrapf(ErrInvalidParameters, "ttl: %v", ttl)
+	}
+
+	kv := &KVOverride{
+		Key:              key,
+		Action:           o.Action.String(),
+		DurationPerToken: o.DurationPerToken.Nanoseconds(),
+		Burst:            o.Burst,
+	}
+	if ttl > 0 {
+		kv.ExpiresAt = overrideNow(ctx).Add(ttl).UnixMicro()
+	}
+
+	if err := s.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(kv).Error; err != nil {
+		return errors.Wrap(err, "ratelimiter: failed to set override")
+	}
+	return nil
+}
+
+func (s *GormOverrideStore) DeleteOverride(ctx context.Context, key string) error {
+	if err := s.db.WithContext(ctx).Where("key = ?", key).Delete(&KVOverride{}).Error; err != nil {
+		return errors.Wrap(err, "ratelimiter: failed to delete override")
+	}
+	return nil
+}
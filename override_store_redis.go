@@ -0,0 +1,75 @@
+package ratelimiter
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+// RedisOverrideStore is an OverrideStore that saves each override as JSON in a key with the prefix.
+type RedisOverrideStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisOverrideStore returns an OverrideStore that uses Redis as the storage,
+// the prefix separates the overrides from the buckets, e.g. "ratelimiter:override:".
+func NewRedisOverrideStore(client *redis.Client, prefix string) *RedisOverrideStore {
+	return &RedisOverrideStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func (s *RedisOverrideStore) GetOverride(ctx context.Context, key string) (*KeyOverride, error) {
+	data, err := s.client.Get(ctx, s.prefix+key).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "ratelimiter: failed to get override")
+	}
+
+	var o KeyOverride
+	if err := json.Unmarshal(data, &o); err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to unmarshal override")
+	}
+	if o.expired(overrideNow(ctx)) {
+		return nil, nil
+	}
+	return &o, nil
+}
+
+func (s *RedisOverrideStore) SetOverride(ctx context.Context, key string, o *KeyOverride, ttl time.Duration) error {
+	if err := validateKeyOverride(key, o); err != nil {
+		return err
+	}
+	if ttl < 0 {
+		return errors.Wrapf(ErrInvalidParameters, "ttl: %v", ttl)
+	}
+
+	stored := *o
+	stored.ExpiresAt = time.Time{}
+	if ttl > 0 {
+		stored.ExpiresAt = overrideNow(ctx).Add(ttl)
+	}
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return errors.Wrap(err, "ratelimiter: failed to marshal override")
+	}
+
+	if err := s.client.Set(ctx, s.prefix+key, data, ttl).Err(); err != nil {
+		return errors.Wrap(err, "ratelimiter: failed to set override")
+	}
+	return nil
+}
+
+func (s *RedisOverrideStore) DeleteOverride(ctx context.Context, key string) error {
+	if err := s.client.Del(ctx, s.prefix+key).Err(); err != nil {
+		return errors.Wrap(err, "ratelimiter: failed to delete override")
+	}
+	return nil
+}
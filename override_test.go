@@ -0,0 +1,287 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testOverrideStore(t *testing.T, store OverrideStore, key string) {
+	now := time.Now().Truncate(time.Microsecond)
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+
+	o, err := store.GetOverride(ctx, key)
+	require.NoError(t, err)
+	require.Nil(t, o)
+
+	require.NoError(t, store.SetOverride(ctx, key, &KeyOverride{Action: OverrideActionLimit, DurationPerToken: time.Second, Burst: 10}, 0))
+	o, err = store.GetOverride(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, &KeyOverride{Action: OverrideActionLimit, DurationPerToken: time.Second, Burst: 10}, o)
+
+	// a DurationPerToken below a microsecond is kept
+	require.NoError(t, store.SetOverride(ctx, key, &KeyOverride{Action: OverrideActionLimit, DurationPerToken: 500 * time.Nanosecond}, 0))
+	o, err = store.GetOverride(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, 500*time.Nanosecond, o.DurationPerToken)
+
+	// replaced by a temporary override
+	require.NoError(t, store.SetOverride(ctx, key, &KeyOverride{Action: OverrideActionDeny}, time.Hour))
+	o, err = store.GetOverride(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, OverrideActionDeny, o.Action)
+	require.True(t, now.Add(time.Hour).Equal(o.ExpiresAt), "expiresAt: %v", o.ExpiresAt)
+
+	expiredCtx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now.Add(time.Hour)
+	})
+	o, err = store.GetOverride(expiredCtx, key)
+	require.NoError(t, err)
+	require.Nil(t, o)
+
+	require.NoError(t, store.SetOverride(ctx, key, &KeyOverride{Action: OverrideActionAllow}, 0))
+	require.NoError(t, store.DeleteOverride(ctx, key))
+	require.NoError(t, store.DeleteOverride(ctx, key))
+	o, err = store.GetOverride(ctx, key)
+	require.NoError(t, err)
+	require.Nil(t, o)
+
+	err = store.SetOverride(ctx, key, &KeyOverride{Action: OverrideActionLimit}, 0)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+	err = store.SetOverride(ctx, key, &KeyOverride{Action: OverrideActionAllow}, -time.Second)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+}
+
+func TestOverrideStore_Redis(t *testing.T) {
+	testOverrideStore(t, NewRedisOverrideStore(redisCli, "override:"), "TestOverrideStore_Redis")
+}
+
+func TestOverrideStore_GORM(t *testing.T) {
+	testOverrideStore(t, NewGormOverrideStore(db), "TestOverrideStore_GORM")
+}
+
+func TestOverrideDriver(t *testing.T) {
+	d, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+	store := NewRedisOverrideStore(redisCli, "override:")
+	limiter := New(NewOverrideDriver(d, store))
+
+	now := time.Now().Truncate(time.Microsecond)
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+
+	newRequest := func(key string) *ReserveRequest {
+		return &ReserveRequest{
+			Key:              "TestOverrideDriver:" + key,
+			DurationPerToken: time.Second,
+			Burst:            1,
+			Tokens:           1,
+		}
+	}
+	reserveN := func(t *testing.T, key string, n int) []*Reservation {
+		rs := make([]*Reservation, n)
+		for i := range rs {
+			r, err := limiter.Reserve(ctx, newRequest(key))
+			require.NoError(t, err)
+			rs[i] = r
+		}
+		return rs
+	}
+
+	t.Run("no override", func(t *testing.T) {
+		rs := reserveN(t, "default", 2)
+		require.True(t, rs[0].OK)
+		require.False(t, rs[1].OK)
+	})
+
+	t.Run("allow", func(t *testing.T) {
+		require.NoError(t, store.SetOverride(ctx, newRequest("internal").Key, &KeyOverride{Action: OverrideActionAllow}, 0))
+		for _, r := range reserveN(t, "internal", 3) {
+			require.True(t, r.OK)
+			require.Equal(t, time.Duration(0), r.DelayFrom(now))
+		}
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		require.NoError(t, store.SetOverride(ctx, newRequest("abuser").Key, &KeyOverride{Action: OverrideActionDeny}, 0))
+		r := reserveN(t, "abuser", 1)[0]
+		require.False(t, r.OK)
+		require.Equal(t, deniedRetryAfter, r.RetryAfterFrom(now))
+
+		require.NoError(t, store.SetOverride(ctx, newRequest("suspect").Key, &KeyOverride{Action: OverrideActionDeny}, time.Minute))
+		r = reserveN(t, "suspect", 1)[0]
+		require.False(t, r.OK)
+		require.Equal(t, time.Minute, r.RetryAfterFrom(now))
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		require.NoError(t, store.SetOverride(ctx, newRequest("enterprise").Key, &KeyOverride{Action: OverrideActionLimit, Burst: 3}, 0))
+		rs := reserveN(t, "enterprise", 4)
+		for _, r := range rs[:3] {
+			require.True(t, r.OK)
+			require.Equal(t, 3, r.Burst)
+		}
+		require.False(t, rs[3].OK)
+	})
+
+	t.Run("limit below the tokens of the request", func(t *testing.T) {
+		require.NoError(t, store.SetOverride(ctx, "TestOverrideDriver:small", &KeyOverride{Action: OverrideActionLimit, Burst: 1}, 0))
+		r, err := limiter.Reserve(ctx, &ReserveRequest{
+			Key:              "TestOverrideDriver:small",
+			DurationPerToken: time.Second,
+			Burst:            5,
+			Tokens:           2,
+			Floor:            1,
+		})
+		require.NoError(t, err)
+		require.True(t, r.OK)
+		require.Equal(t, 3, r.Burst)
+	})
+}
+
+type countingOverrideStore struct {
+	OverrideStore
+	gets int
+}
+
+func (s *countingOverrideStore) GetOverride(ctx context.Context, key string) (*KeyOverride, error) {
+	s.gets++
+	return s.OverrideStore.GetOverride(ctx, key)
+}
+
+func TestCachedOverrideStore(t *testing.T) {
+	counting := &countingOverrideStore{OverrideStore: NewRedisOverrideStore(redisCli, "TestCachedOverrideStore:")}
+	store, err := NewCachedOverrideStore(counting, time.Minute, 2)
+	require.NoError(t, err)
+
+	now := time.Now().Truncate(time.Microsecond)
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+
+	// the keys without overrides are cached too
+	for range 3 {
+		o, err := store.GetOverride(ctx, "k1")
+		require.NoError(t, err)
+		require.Nil(t, o)
+	}
+	require.Equal(t, 1, counting.gets)
+
+	// set invalidates the cached key
+	require.NoError(t, store.SetOverride(ctx, "k1", &KeyOverride{Action: OverrideActionAllow}, time.Hour))
+	for range 3 {
+		o, err := store.GetOverride(ctx, "k1")
+		require.NoError(t, err)
+		require.Equal(t, OverrideActionAllow, o.Action)
+	}
+	require.Equal(t, 2, counting.gets)
+
+	// the cached override expires with the override
+	expiredCtx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now.Add(time.Hour)
+	})
+	o, err := store.GetOverride(expiredCtx, "k1")
+	require.NoError(t, err)
+	require.Nil(t, o)
+	require.Equal(t, 3, counting.gets)
+
+	// the cache is bounded
+	for _, key := range []string{"k2", "k3", "k1"} {
+		_, err := store.GetOverride(ctx, key)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 6, counting.gets)
+	require.LessOrEqual(t, len(store.entries), 2)
+
+	_, err = NewCachedOverrideStore(counting, time.Minute, 0)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+	_, err = NewCachedOverrideStore(counting, 0, 2)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+}
+
+// racingOverrideStore sets the override of the key while the first lookup is in flight.
+type racingOverrideStore struct {
+	OverrideStore
+	race func()
+}
+
+func (s *racingOverrideStore) GetOverride(ctx context.Context, key string) (*KeyOverride, error) {
+	o, err := s.OverrideStore.GetOverride(ctx, key)
+	if race := s.race; race != nil {
+		s.race = nil
+		race()
+	}
+	return o, err
+}
+
+func TestCachedOverrideStore_RacingWrite(t *testing.T) {
+	racing := &racingOverrideStore{OverrideStore: NewRedisOverrideStore(redisCli, "TestCachedOverrideStore_RacingWrite:")}
+	store, err := NewCachedOverrideStore(racing, time.Minute, 10)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, store.DeleteOverride(ctx, "k"))
+	racing.race = func() {
+		require.NoError(t, store.SetOverride(ctx, "k", &KeyOverride{Action: OverrideActionDeny}, 0))
+	}
+
+	// the lookup read the override before the write, so it must not be cached
+	o, err := store.GetOverride(ctx, "k")
+	require.NoError(t, err)
+	require.Nil(t, o)
+	o, err = store.GetOverride(ctx, "k")
+	require.NoError(t, err)
+	require.Equal(t, OverrideActionDeny, o.Action)
+}
+
+func TestOverrideDriver_LeakyBucketQueue(t *testing.T) {
+	d, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+	store := NewRedisOverrideStore(redisCli, "TestOverrideDriver_LeakyBucketQueue:")
+	testLeakyBucketQueue(t, New(NewOverrideDriver(d, store)), "TestOverrideDriver_LeakyBucketQueue")
+}
+
+func TestOverrideDriver_Hierarchy(t *testing.T) {
+	d, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+	store := NewRedisOverrideStore(redisCli, "TestOverrideDriver_Hierarchy:")
+	limiter := New(NewOverrideDriver(d, store))
+
+	// the hierarchy behaves the same without overrides
+	testHierarchy(t, limiter, "TestOverrideDriver_Hierarchy:{h1}")
+
+	now := time.Now().Truncate(time.Microsecond)
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+	global := &ReserveRequest{Key: "TestOverrideDriver_Hierarchy:{h2}:global", DurationPerToken: time.Second, Burst: 2, Tokens: 1}
+	user := func(userID string) *ReserveRequest {
+		return &ReserveRequest{Key: "TestOverrideDriver_Hierarchy:{h2}:" + userID, DurationPerToken: time.Second, Burst: 1, Tokens: 1}
+	}
+	require.NoError(t, store.SetOverride(ctx, user("internal").Key, &KeyOverride{Action: OverrideActionAllow}, 0))
+	require.NoError(t, store.SetOverride(ctx, user("abuser").Key, &KeyOverride{Action: OverrideActionDeny}, time.Minute))
+
+	// the allowed level is skipped, but its parents still limit it
+	for i := range 3 {
+		hr, err := limiter.ReserveHierarchy(ctx, global, user("internal"))
+		require.NoError(t, err)
+		require.Len(t, hr.Levels, 2)
+		require.True(t, hr.Levels[1].OK)
+		require.Equal(t, i < 2, hr.OK)
+	}
+
+	// the denied level rejects the hierarchy without reserving the others
+	hr, err := limiter.ReserveHierarchy(ctx, global, user("abuser"))
+	require.NoError(t, err)
+	require.False(t, hr.OK)
+	require.Equal(t, 1, hr.DeniedLevel)
+	require.Equal(t, time.Minute, hr.RetryAfterFrom(now))
+
+	_, err = New(NewOverrideDriver(NewMemcachedDriver(nil), store)).ReserveHierarchy(ctx, global)
+	require.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+}
@@ -0,0 +1,306 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Rate is Tokens per Per, e.g. 100 per time.Minute.
+type Rate struct {
+	Tokens int
+	Per    time.Duration
+}
+
+func (r Rate) String() string {
+	return fmt.Sprintf("%d/%v", r.Tokens, r.Per)
+}
+
+// DurationPerToken returns the time interval of each token.
+func (r Rate) DurationPerToken() time.Duration {
+	return r.Per / time.Duration(r.Tokens)
+}
+
+// Policy is a named limit, so that the same limit is defined once and shared by all call sites.
+type Policy struct {
+	Name string
+	// Rate is not used by AlgorithmQuota, which uses Period instead.
+	Rate      Rate
+	Burst     int
+	Algorithm Algorithm
+	// KeyTemplate builds the key from the arguments of a call, each {placeholder} is replaced
+	// by the next argument in order, e.g. "login:{userID}". The default is "<Name>:{key}".
+	KeyTemplate string
+
+	MaxFutureReserve time.Duration
+	// Period and Location are used by AlgorithmQuota, nil Location means UTC.
+	Period   Period
+	Location *time.Location
+	// MaxQueue is used by AlgorithmLeakyBucketQueue.
+	MaxQueue int
+	// Overrides are the limits of specific keys built from KeyTemplate, e.g. a higher burst for an internal user.
+	Overrides map[string]PolicyOverride
+	// DryRun evaluates the policy against a shadow bucket and always allows the calls,
+	// so the would-be denials of a new limit can be observed before it is enforced.
+	DryRun bool
+
+	keyParts []string
+}
+
+// PolicyOverride overrides the limit of a key of a Policy, the zero fields are not overridden.
+type PolicyOverride struct {
+	Rate  Rate
+	Burst int
+}
+
+// compile validates the policy and parses its key template.
+func (p *Policy) compile() error {
+	if p.Name == "" {
+		return errors.Wrap(ErrInvalidParameters, "policy must have a name")
+	}
+	if p.Algorithm != AlgorithmQuota && (p.Rate.Tokens <= 0 || p.Rate.DurationPerToken() < time.Microsecond) {
+		return errors.Wrapf(ErrInvalidParameters, "policy %q: invalid rate %v", p.Name, p.Rate)
+	}
+
+	template := p.KeyTemplate
+	if template == "" {
+		template = p.Name + ":{key}"
+	}
+	keyParts, err := parseKeyTemplate(template)
+	if err != nil {
+		return errors.Wrapf(err, "policy %q", p.Name)
+	}
+	p.keyParts = keyParts
+
+	// the request of one token must be valid, so that the calls fail only for invalid arguments
+	req := p.reserveRequest(strings.Join(keyParts, "x"), 1)
+	if err := validateReserveRequest(req); err != nil {
+		return errors.Wrapf(err, "policy %q", p.Name)
+	}
+
+	for key, override := range p.Overrides {
+		if override.Rate != (Rate{}) && (override.Rate.Tokens <= 0 || override.Rate.DurationPerToken() < time.Microsecond) {
+			return errors.Wrapf(ErrInvalidParameters, "policy %q: invalid rate %v of override %q", p.Name, override.Rate, key)
+		}
+		if err := validateReserveRequest(p.reserveRequest(key, 1)); err != nil {
+			return errors.Wrapf(err, "policy %q: override %q", p.Name, key)
+		}
+	}
+	return nil
+}
+
+// parseKeyTemplate splits the template into the literal parts around its placeholders,
+// so there is always one more part than placeholders.
+func parseKeyTemplate(template string) ([]string, error) {
+	var parts []string
+	rest := template
+	for {
+		start := strings.IndexAny(rest, "{}")
+		if start < 0 {
+			return append(parts, rest), nil
+		}
+		if rest[start] == '}' {
+			return nil, errors.Wrapf(ErrInvalidParameters, "unexpected } in key template %q", template)
+		}
+		end := strings.IndexAny(rest[start+1:], "{}")
+		if end < 0 || rest[start+1+end] == '{' {
+			return nil, errors.Wrapf(ErrInvalidParameters, "unclosed { in key template %q", template)
+		}
+		if end == 0 {
+			return nil, errors.Wrapf(ErrInvalidParameters, "empty placeholder in key template %q", template)
+		}
+		parts = append(parts, rest[:start])
+		rest = rest[start+1+end+1:]
+	}
+}
+
+// Key builds the key from the arguments, which must match the placeholders of the key template.
+func (p *Policy) Key(args ...string) (string, error) {
+	if len(args) != len(p.keyParts)-1 {
+		return "", errors.Wrapf(ErrInvalidParameters, "policy %q requires %d key arguments, got %d", p.Name, len(p.keyParts)-1, len(args))
+	}
+
+	var b strings.Builder
+	for i, part := range p.keyParts {
+		b.WriteString(part)
+		if i < len(args) {
+			b.WriteString(args[i])
+		}
+	}
+	return b.String(), nil
+}
+
+func (p *Policy) reserveRequest(key string, tokens int) *ReserveRequest {
+	rate, burst := p.Rate, p.Burst
+	if override, ok := p.Overrides[key]; ok {
+		if override.Rate != (Rate{}) {
+			rate = override.Rate
+		}
+		if override.Burst != 0 {
+			burst = override.Burst
+		}
+	}
+
+	req := &ReserveRequest{
+		Key:              key,
+		Burst:            burst,
+		Tokens:           tokens,
+		MaxFutureReserve: p.MaxFutureReserve,
+		Algorithm:        p.Algorithm,
+		Period:           p.Period,
+		Location:         p.Location,
+		MaxQueue:         p.MaxQueue,
+	}
+	if p.Algorithm != AlgorithmQuota {
+		req.DurationPerToken = rate.DurationPerToken()
+	}
+	return req
+}
+
+// ReserveRequest returns the request of the tokens for the key built from the arguments.
+func (p *Policy) ReserveRequest(tokens int, args ...string) (*ReserveRequest, error) {
+	key, err := p.Key(args...)
+	if err != nil {
+		return nil, err
+	}
+	return p.reserveRequest(key, tokens), nil
+}
+
+// PolicyRegistry is the set of named policies, the policies are validated at registration.
+type PolicyRegistry struct {
+	mu       sync.RWMutex
+	policies map[string]*Policy
+}
+
+func NewPolicyRegistry() *PolicyRegistry {
+	return &PolicyRegistry{
+		policies: make(map[string]*Policy),
+	}
+}
+
+// Register validates and registers the policies, nothing is registered if any of them is invalid or duplicated.
+func (r *PolicyRegistry) Register(policies ...Policy) error {
+	compiled := make(map[string]*Policy, len(policies))
+	for _, policy := range policies {
+		p := policy
+		if err := p.compile(); err != nil {
+			return err
+		}
+		if compiled[p.Name] != nil {
+			return errors.Wrapf(ErrInvalidParameters, "duplicate policy %q", p.Name)
+		}
+		compiled[p.Name] = &p
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for name := range compiled {
+		if r.policies[name] != nil {
+			return errors.Wrapf(ErrInvalidParameters, "duplicate policy %q", name)
+		}
+	}
+	for name, p := range compiled {
+		r.policies[name] = p
+	}
+	return nil
+}
+
+// Get returns the policy of the name, which must not be modified.
+func (r *PolicyRegistry) Get(name string) (*Policy, error) {
+	r.mu.RLock()
+	p, ok := r.policies[name]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, errors.Wrapf(ErrPolicyNotFound, "policy %q", name)
+	}
+	return p, nil
+}
+
+// Names returns the names of the registered policies.
+func (r *PolicyRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.policies))
+	for name := range r.policies {
+		names = append(names, name)
+	}
+	return names
+}
+
+// PolicyLimiter limits the calls by the named policies of a registry.
+type PolicyLimiter struct {
+	limiter  *RateLimiter
+	registry atomic.Pointer[PolicyRegistry]
+}
+
+func NewPolicyLimiter(driver Driver, registry *PolicyRegistry, opts ...Option) *PolicyLimiter {
+	lim := &PolicyLimiter{
+		limiter: New(driver, opts...),
+	}
+	lim.registry.Store(registry)
+	return lim
+}
+
+// Registry returns the current registry.
+func (lim *PolicyLimiter) Registry() *PolicyRegistry {
+	return lim.registry.Load()
+}
+
+// SetRegistry swaps the registry atomically, the calls in flight finish with the previous one.
+func (lim *PolicyLimiter) SetRegistry(registry *PolicyRegistry) {
+	lim.registry.Store(registry)
+}
+
+// Allow reserves a token of the policy for the key built from the arguments, e.g. Allow(ctx, "login", userID).
+// Unlike Reserve, the MaxFutureReserve of the policy is not used. The policies of AlgorithmLeakyBucketQueue
+// fail with ErrInvalidParameters, since their reservations wait in the queue, so use Reserve for them.
+func (lim *PolicyLimiter) Allow(ctx context.Context, name string, args ...string) (bool, error) {
+	p, req, err := lim.reserveRequest(name, 1, args...)
+	if err != nil {
+		return false, err
+	}
+	if req.Algorithm == AlgorithmLeakyBucketQueue {
+		return false, errors.Wrapf(ErrInvalidParameters, "Allow does not support %v of policy %q", req.Algorithm, name)
+	}
+	req.MaxFutureReserve = 0
+
+	r, err := lim.limiter.reserve(ctx, name, p.DryRun, req)
+	if err != nil {
+		return false, err
+	}
+	return r.OK, nil
+}
+
+// Reserve reserves a token of the policy for the key built from the arguments.
+func (lim *PolicyLimiter) Reserve(ctx context.Context, name string, args ...string) (*Reservation, error) {
+	return lim.ReserveN(ctx, name, 1, args...)
+}
+
+// ReserveN reserves the tokens of the policy for the key built from the arguments.
+func (lim *PolicyLimiter) ReserveN(ctx context.Context, name string, tokens int, args ...string) (*Reservation, error) {
+	p, req, err := lim.reserveRequest(name, tokens, args...)
+	if err != nil {
+		return nil, err
+	}
+	return lim.limiter.reserve(ctx, name, p.DryRun, req)
+}
+
+func (lim *PolicyLimiter) reserveRequest(name string, tokens int, args ...string) (*Policy, *ReserveRequest, error) {
+	p, err := lim.registry.Load().Get(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := p.ReserveRequest(tokens, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, req, nil
+}
@@ -0,0 +1,285 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// PolicyConfig is the schema of a policy file, e.g.
+//
+//	policies:
+//	  - name: login
+//	    rate: 5/min
+//	    burst: 5
+//	    key: "login:{userID}"
+//	    overrides:
+//	      "login:admin": {rate: 100/min, burst: 100}
+//	  - name: export
+//	    algorithm: quota
+//	    burst: 100
+//	    period: day
+//	    location: Asia/Tokyo
+type PolicyConfig struct {
+	Policies []PolicyConfigEntry `json:"policies" yaml:"policies"`
+}
+
+type PolicyConfigEntry struct {
+	Name string `json:"name" yaml:"name"`
+	// Rate is tokens per unit, e.g. "100/min", the unit is one of s, sec, second, m, min, minute, h, hour, d, day,
+	// or a duration, e.g. "100/10s".
+	Rate string `json:"rate" yaml:"rate"`
+	// Burst defaults to the tokens of the rate.
+	Burst int `json:"burst" yaml:"burst"`
+	// Algorithm is one of gcra (default), sliding_window_log, sliding_window_counter, quota and leaky_bucket_queue.
+	Algorithm string `json:"algorithm" yaml:"algorithm"`
+	// Key is the KeyTemplate of the policy.
+	Key string `json:"key" yaml:"key"`
+	// MaxFutureReserve is a duration, e.g. "1s".
+	MaxFutureReserve string `json:"maxFutureReserve" yaml:"maxFutureReserve"`
+	// Period is one of hour, day, week and month.
+	Period string `json:"period" yaml:"period"`
+	// Location is an IANA time zone, e.g. "Asia/Tokyo".
+	Location  string                         `json:"location" yaml:"location"`
+	MaxQueue  int                            `json:"maxQueue" yaml:"maxQueue"`
+	Overrides map[string]PolicyOverrideEntry `json:"overrides" yaml:"overrides"`
+	// DryRun evaluates the policy without enforcing it.
+	DryRun bool `json:"dryRun" yaml:"dryRun"`
+}
+
+type PolicyOverrideEntry struct {
+	Rate  string `json:"rate" yaml:"rate"`
+	Burst int    `json:"burst" yaml:"burst"`
+}
+
+var rateUnits = map[string]time.Duration{
+	"s":      time.Second,
+	"sec":    time.Second,
+	"second": time.Second,
+	"m":      time.Minute,
+	"min":    time.Minute,
+	"minute": time.Minute,
+	"h":      time.Hour,
+	"hour":   time.Hour,
+	"d":      24 * time.Hour,
+	"day":    24 * time.Hour,
+}
+
+// ParseRate parses a rate like "100/min" or "100/10s".
+func ParseRate(s string) (Rate, error) {
+	tokens, unit, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Rate{}, errors.Wrapf(ErrInvalidParameters, "rate %q must be tokens/unit", s)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(tokens))
+	if err != nil || n <= 0 {
+		return Rate{}, errors.Wrapf(ErrInvalidParameters, "invalid tokens of rate %q", s)
+	}
+
+	unit = strings.TrimSpace(unit)
+	per, ok := rateUnits[unit]
+	if !ok {
+		per, err = time.ParseDuration(unit)
+		if err != nil || per <= 0 {
+			return Rate{}, errors.Wrapf(ErrInvalidParameters, "invalid unit of rate %q", s)
+		}
+	}
+	return Rate{Tokens: n, Per: per}, nil
+}
+
+func parseAlgorithm(s string) (Algorithm, error) {
+	if s == "" {
+		return AlgorithmGCRA, nil
+	}
+	for a := AlgorithmGCRA; a <= AlgorithmLeakyBucketQueue; a++ {
+		if a.String() == s {
+			return a, nil
+		}
+	}
+	return 0, errors.Wrapf(ErrInvalidParameters, "unknown algorithm %q", s)
+}
+
+func parsePeriod(s string) (Period, error) {
+	if s == "" {
+		return 0, nil
+	}
+	for p := PeriodHour; p <= PeriodMonth; p++ {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, errors.Wrapf(ErrInvalidParameters, "unknown period %q", s)
+}
+
+// Policy converts the entry to a Policy, which is validated when it is registered.
+func (e *PolicyConfigEntry) Policy() (Policy, error) {
+	p := Policy{
+		Name:        e.Name,
+		Burst:       e.Burst,
+		KeyTemplate: e.Key,
+		MaxQueue:    e.MaxQueue,
+		DryRun:      e.DryRun,
+	}
+
+	var err error
+	if p.Algorithm, err = parseAlgorithm(e.Algorithm); err != nil {
+		return Policy{}, errors.Wrapf(err, "policy %q", e.Name)
+	}
+	if e.Rate != "" {
+		if p.Rate, err = ParseRate(e.Rate); err != nil {
+			return Policy{}, errors.Wrapf(err, "policy %q", e.Name)
+		}
+		if p.Burst == 0 {
+			p.Burst = p.Rate.Tokens
+		}
+	}
+	if e.MaxFutureReserve != "" {
+		if p.MaxFutureReserve, err = time.ParseDuration(e.MaxFutureReserve); err != nil {
+			return Policy{}, errors.Wrapf(ErrInvalidParameters, "policy %q: invalid maxFutureReserve %q", e.Name, e.MaxFutureReserve)
+		}
+	}
+	if p.Period, err = parsePeriod(e.Period); err != nil {
+		return Policy{}, errors.Wrapf(err, "policy %q", e.Name)
+	}
+	if e.Location != "" {
+		if p.Location, err = time.LoadLocation(e.Location); err != nil {
+			return Policy{}, errors.Wrapf(ErrInvalidParameters, "policy %q: invalid location %q", e.Name, e.Location)
+		}
+	}
+
+	if len(e.Overrides) > 0 {
+		p.Overrides = make(map[string]PolicyOverride, len(e.Overrides))
+		for key, o := range e.Overrides {
+			override := PolicyOverride{Burst: o.Burst}
+			if o.Rate != "" {
+				if override.Rate, err = ParseRate(o.Rate); err != nil {
+					return Policy{}, errors.Wrapf(err, "policy %q: override %q", e.Name, key)
+				}
+			}
+			p.Overrides[key] = override
+		}
+	}
+	return p, nil
+}
+
+// Registry converts the config to a registry, it fails if any policy is invalid.
+func (c *PolicyConfig) Registry() (*PolicyRegistry, error) {
+	policies := make([]Policy, 0, len(c.Policies))
+	for i := range c.Policies {
+		p, err := c.Policies[i].Policy()
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+
+	registry := NewPolicyRegistry()
+	if err := registry.Register(policies...); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// ParsePolicyYAML parses the policies of a YAML config, unknown fields are rejected.
+func ParsePolicyYAML(data []byte) (*PolicyRegistry, error) {
+	var c PolicyConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil {
+		return nil, errors.Wrapf(ErrInvalidParameters, "invalid policy config: %v", err)
+	}
+	return c.Registry()
+}
+
+// ParsePolicyJSON parses the policies of a JSON config, unknown fields are rejected.
+func ParsePolicyJSON(data []byte) (*PolicyRegistry, error) {
+	var c PolicyConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return nil, errors.Wrapf(ErrInvalidParameters, "invalid policy config: %v", err)
+	}
+	return c.Registry()
+}
+
+// LoadPolicyFile loads the policies of a file, which is JSON if its extension is .json, otherwise YAML.
+func LoadPolicyFile(path string) (*PolicyRegistry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to read policy file")
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return ParsePolicyJSON(data)
+	}
+	return ParsePolicyYAML(data)
+}
+
+// WatchPolicyFile reloads the policies of the file into the limiter whenever it changes until the context is done.
+// The registry is swapped atomically, so no calls are dropped, and the previous registry is kept if the file is invalid.
+// onReload is called after every reload with its error, it may be nil.
+// The errors of the watcher, e.g. an overflow of its event queue, are reported to onReload too and the watching goes on,
+// the file is reloaded after them in case a change is missed.
+func (lim *PolicyLimiter) WatchPolicyFile(ctx context.Context, path string, onReload func(err error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Wrap(err, "ratelimiter: failed to create watcher")
+	}
+	defer watcher.Close()
+
+	// watch the directory, since editors and config maps replace the file instead of writing it
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		return errors.Wrap(err, "ratelimiter: failed to watch policy file")
+	}
+
+	return lim.watchPolicyFile(ctx, path, watcher.Events, watcher.Errors, onReload)
+}
+
+func (lim *PolicyLimiter) watchPolicyFile(ctx context.Context, path string, events <-chan fsnotify.Event, errs <-chan error, onReload func(err error)) error {
+	if onReload == nil {
+		onReload = func(err error) {}
+	}
+	reload := func() {
+		registry, err := LoadPolicyFile(path)
+		if err == nil {
+			lim.SetRegistry(registry)
+		}
+		onReload(err)
+	}
+
+	name := filepath.Clean(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err, ok := <-errs:
+			if !ok {
+				return errors.New("ratelimiter: policy file watcher is closed")
+			}
+			onReload(errors.Wrap(err, "ratelimiter: failed to watch policy file"))
+			reload()
+		case event, ok := <-events:
+			if !ok {
+				return errors.New("ratelimiter: policy file watcher is closed")
+			}
+			// a config map swaps its ..data symlink instead of the file
+			if filepath.Clean(event.Name) != name && !strings.HasPrefix(filepath.Base(event.Name), "..") {
+				continue
+			}
+			if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+				continue
+			}
+			reload()
+		}
+	}
+}
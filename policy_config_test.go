@@ -0,0 +1,220 @@
+package ratelimiter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRate(t *testing.T) {
+	testCases := []struct {
+		rate     string
+		expected Rate
+	}{
+		{"100/min", Rate{Tokens: 100, Per: time.Minute}},
+		{"10/s", Rate{Tokens: 10, Per: time.Second}},
+		{"5 / hour", Rate{Tokens: 5, Per: time.Hour}},
+		{"1000/day", Rate{Tokens: 1000, Per: 24 * time.Hour}},
+		{"100/10s", Rate{Tokens: 100, Per: 10 * time.Second}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.rate, func(t *testing.T) {
+			rate, err := ParseRate(tc.rate)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, rate)
+		})
+	}
+
+	for _, rate := range []string{"", "100", "0/min", "-1/min", "x/min", "100/week", "100/-1s"} {
+		_, err := ParseRate(rate)
+		require.ErrorIs(t, err, ErrInvalidParameters, rate)
+	}
+}
+
+const testPolicyYAML = `
+policies:
+  - name: login
+    rate: 5/min
+    key: "login:{userID}"
+    overrides:
+      "login:admin": {rate: 100/min, burst: 100}
+  - name: export
+    algorithm: quota
+    burst: 100
+    period: day
+    location: Asia/Tokyo
+    dryRun: true
+`
+
+func TestParsePolicyYAML(t *testing.T) {
+	registry, err := ParsePolicyYAML([]byte(testPolicyYAML))
+	require.NoError(t, err)
+
+	login, err := registry.Get("login")
+	require.NoError(t, err)
+	req, err := login.ReserveRequest(1, "u1")
+	require.NoError(t, err)
+	require.Equal(t, 12*time.Second, req.DurationPerToken)
+	require.Equal(t, 5, req.Burst) // defaults to the tokens of the rate
+
+	req, err = login.ReserveRequest(1, "admin")
+	require.NoError(t, err)
+	require.Equal(t, 600*time.Millisecond, req.DurationPerToken)
+	require.Equal(t, 100, req.Burst)
+
+	export, err := registry.Get("export")
+	require.NoError(t, err)
+	require.Equal(t, AlgorithmQuota, export.Algorithm)
+	require.True(t, export.DryRun)
+	require.False(t, login.DryRun)
+	require.Equal(t, PeriodDay, export.Period)
+	require.Equal(t, "Asia/Tokyo", export.Location.String())
+
+	invalidConfigs := map[string]string{
+		"unknown field":       "policies:\n  - name: login\n    rate: 5/min\n    brust: 5\n",
+		"invalid rate":        "policies:\n  - name: login\n    rate: 5/week\n",
+		"invalid algorithm":   "policies:\n  - name: login\n    rate: 5/min\n    algorithm: fixed_window\n",
+		"invalid period":      "policies:\n  - name: export\n    algorithm: quota\n    burst: 5\n    period: year\n",
+		"invalid location":    "policies:\n  - name: export\n    algorithm: quota\n    burst: 5\n    period: day\n    location: Mars/Olympus\n",
+		"invalid policy":      "policies:\n  - name: login\n    rate: 5/min\n    burst: -1\n",
+		"invalid override":    "policies:\n  - name: login\n    rate: 5/min\n    overrides:\n      \"login:admin\": {burst: -1}\n",
+		"duplicate policy":    "policies:\n  - name: login\n    rate: 5/min\n  - name: login\n    rate: 5/min\n",
+		"invalid max reserve": "policies:\n  - name: login\n    rate: 5/min\n    maxFutureReserve: soon\n",
+	}
+	for name, config := range invalidConfigs {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParsePolicyYAML([]byte(config))
+			require.ErrorIs(t, err, ErrInvalidParameters)
+		})
+	}
+}
+
+func TestParsePolicyJSON(t *testing.T) {
+	registry, err := ParsePolicyJSON([]byte(`{"policies": [{"name": "login", "rate": "5/min", "burst": 10, "maxFutureReserve": "1s"}]}`))
+	require.NoError(t, err)
+
+	login, err := registry.Get("login")
+	require.NoError(t, err)
+	req, err := login.ReserveRequest(1, "u1")
+	require.NoError(t, err)
+	require.Equal(t, &ReserveRequest{
+		Key:              "login:u1",
+		DurationPerToken: 12 * time.Second,
+		Burst:            10,
+		Tokens:           1,
+		MaxFutureReserve: time.Second,
+	}, req)
+
+	_, err = ParsePolicyJSON([]byte(`{"policies": [{"name": "login", "rate": "5/min", "brust": 10}]}`))
+	require.ErrorIs(t, err, ErrInvalidParameters)
+}
+
+func TestPolicyLimiter_WatchPolicyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("policies:\n  - name: login\n    rate: 5/min\n"), 0o600))
+
+	registry, err := LoadPolicyFile(path)
+	require.NoError(t, err)
+	limiter := NewPolicyLimiter(DriverFunc(func(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
+		return newReservation(req, time.Now(), time.Now(), true), nil
+	}), registry)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reloaded := make(chan error, 16)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- limiter.WatchPolicyFile(ctx, path, func(err error) {
+			reloaded <- err
+		})
+	}()
+
+	requireBurst := func(t *testing.T, expected int) {
+		p, err := limiter.Registry().Get("login")
+		require.NoError(t, err)
+		require.Equal(t, expected, p.Burst)
+	}
+	// the watcher may start after the first write and a write may be seen half done,
+	// so keep writing until a reload matches
+	writeUntilReloaded := func(t *testing.T, content string, match func(err error) bool) {
+		deadline := time.After(5 * time.Second)
+		for {
+			require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+			select {
+			case err := <-reloaded:
+				if match(err) {
+					return
+				}
+			case <-time.After(100 * time.Millisecond):
+			case <-deadline:
+				t.Fatal("policy file is not reloaded")
+			}
+		}
+	}
+
+	writeUntilReloaded(t, "policies:\n  - name: login\n    rate: 10/min\n", func(err error) bool {
+		p, _ := limiter.Registry().Get("login")
+		return err == nil && p.Burst == 10
+	})
+	requireBurst(t, 10)
+
+	// the previous registry is kept if the file is invalid
+	writeUntilReloaded(t, "policies:\n  - name: login\n    rate: 10/week\n", func(err error) bool {
+		return err != nil
+	})
+	requireBurst(t, 10)
+
+	allowed, err := limiter.Allow(ctx, "login", "u1")
+	require.NoError(t, err)
+	require.True(t, allowed)
+
+	cancel()
+	require.NoError(t, <-watchErr)
+}
+
+func TestPolicyLimiter_WatchPolicyFile_WatcherError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("policies:\n  - name: login\n    rate: 5/min\n"), 0o600))
+
+	registry, err := LoadPolicyFile(path)
+	require.NoError(t, err)
+	limiter := NewPolicyLimiter(DriverFunc(func(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
+		return newReservation(req, time.Now(), time.Now(), true), nil
+	}), registry)
+
+	events := make(chan fsnotify.Event)
+	errs := make(chan error)
+	reloaded := make(chan error, 16)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- limiter.watchPolicyFile(context.Background(), path, events, errs, func(err error) {
+			reloaded <- err
+		})
+	}()
+
+	// the change is missed by the overflow, it is reloaded after the error is reported
+	require.NoError(t, os.WriteFile(path, []byte("policies:\n  - name: login\n    rate: 10/min\n"), 0o600))
+	errs <- fsnotify.ErrEventOverflow
+	require.ErrorIs(t, <-reloaded, fsnotify.ErrEventOverflow)
+	require.NoError(t, <-reloaded)
+	p, err := limiter.Registry().Get("login")
+	require.NoError(t, err)
+	require.Equal(t, 10, p.Burst)
+
+	// the watching goes on after the error
+	require.NoError(t, os.WriteFile(path, []byte("policies:\n  - name: login\n    rate: 20/min\n"), 0o600))
+	events <- fsnotify.Event{Name: path, Op: fsnotify.Write}
+	require.NoError(t, <-reloaded)
+	p, err = limiter.Registry().Get("login")
+	require.NoError(t, err)
+	require.Equal(t, 20, p.Burst)
+
+	close(errs)
+	require.ErrorContains(t, <-watchErr, "watcher is closed")
+}
@@ -0,0 +1,150 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyRegistry_Register(t *testing.T) {
+	valid := Policy{Name: "login", Rate: Rate{Tokens: 5, Per: time.Minute}, Burst: 5}
+
+	testCases := []struct {
+		name   string
+		policy Policy
+	}{
+		{"no name", Policy{Rate: Rate{Tokens: 5, Per: time.Minute}, Burst: 5}},
+		{"no rate", Policy{Name: "p", Burst: 5}},
+		{"rate too fast", Policy{Name: "p", Rate: Rate{Tokens: 2, Per: time.Microsecond}, Burst: 5}},
+		{"no burst", Policy{Name: "p", Rate: Rate{Tokens: 5, Per: time.Minute}}},
+		{"unclosed placeholder", Policy{Name: "p", Rate: Rate{Tokens: 5, Per: time.Minute}, Burst: 5, KeyTemplate: "p:{id"}},
+		{"unexpected brace", Policy{Name: "p", Rate: Rate{Tokens: 5, Per: time.Minute}, Burst: 5, KeyTemplate: "p:id}"}},
+		{"empty placeholder", Policy{Name: "p", Rate: Rate{Tokens: 5, Per: time.Minute}, Burst: 5, KeyTemplate: "p:{}"}},
+		{"quota without period", Policy{Name: "p", Burst: 5, Algorithm: AlgorithmQuota}},
+		{"queue without max queue", Policy{Name: "p", Rate: Rate{Tokens: 5, Per: time.Minute}, Burst: 5, Algorithm: AlgorithmLeakyBucketQueue}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			registry := NewPolicyRegistry()
+			err := registry.Register(valid, tc.policy)
+			require.ErrorIs(t, err, ErrInvalidParameters)
+
+			// nothing is registered if any policy is invalid
+			_, err = registry.Get(valid.Name)
+			require.ErrorIs(t, err, ErrPolicyNotFound)
+		})
+	}
+
+	registry := NewPolicyRegistry()
+	require.ErrorIs(t, registry.Register(valid, valid), ErrInvalidParameters)
+	require.NoError(t, registry.Register(valid, Policy{Name: "export", Burst: 100, Algorithm: AlgorithmQuota, Period: PeriodDay}))
+	require.ErrorIs(t, registry.Register(valid), ErrInvalidParameters)
+	require.ElementsMatch(t, []string{"login", "export"}, registry.Names())
+}
+
+func TestPolicy_Key(t *testing.T) {
+	registry := NewPolicyRegistry()
+	require.NoError(t, registry.Register(
+		Policy{Name: "login", Rate: Rate{Tokens: 5, Per: time.Minute}, Burst: 5},
+		Policy{Name: "upload", Rate: Rate{Tokens: 5, Per: time.Minute}, Burst: 5, KeyTemplate: "upload:{tenant}:{user}"},
+		Policy{Name: "global", Rate: Rate{Tokens: 5, Per: time.Minute}, Burst: 5, KeyTemplate: "global"},
+	))
+
+	testCases := []struct {
+		name        string
+		args        []string
+		expectedKey string
+	}{
+		{"login", []string{"u1"}, "login:u1"},
+		{"upload", []string{"t1", "u1"}, "upload:t1:u1"},
+		{"global", nil, "global"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := registry.Get(tc.name)
+			require.NoError(t, err)
+			key, err := p.Key(tc.args...)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedKey, key)
+		})
+	}
+
+	p, err := registry.Get("upload")
+	require.NoError(t, err)
+	_, err = p.Key("t1")
+	require.ErrorIs(t, err, ErrInvalidParameters)
+
+	req, err := p.ReserveRequest(2, "t1", "u1")
+	require.NoError(t, err)
+	require.Equal(t, &ReserveRequest{
+		Key:              "upload:t1:u1",
+		DurationPerToken: 12 * time.Second,
+		Burst:            5,
+		Tokens:           2,
+	}, req)
+}
+
+func TestPolicyLimiter_DriverRedis(t *testing.T) {
+	d, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+
+	registry := NewPolicyRegistry()
+	require.NoError(t, registry.Register(Policy{
+		Name:             "login",
+		Rate:             Rate{Tokens: 3, Per: time.Minute},
+		Burst:            3,
+		KeyTemplate:      "TestPolicyLimiter_DriverRedis:login:{userID}",
+		MaxFutureReserve: time.Minute,
+	}))
+	limiter := NewPolicyLimiter(d, registry)
+
+	now := time.Now().Truncate(time.Microsecond)
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+
+	for i := 0; i < 3; i++ {
+		allowed, err := limiter.Allow(ctx, "login", "u1")
+		require.NoError(t, err)
+		require.True(t, allowed)
+	}
+	// Allow does not wait even if the policy has MaxFutureReserve
+	allowed, err := limiter.Allow(ctx, "login", "u1")
+	require.NoError(t, err)
+	require.False(t, allowed)
+
+	r, err := limiter.Reserve(ctx, "login", "u1")
+	require.NoError(t, err)
+	require.True(t, r.OK)
+	require.Equal(t, 20*time.Second, r.DelayFrom(now))
+
+	allowed, err = limiter.Allow(ctx, "login", "u2")
+	require.NoError(t, err)
+	require.True(t, allowed)
+
+	_, err = limiter.Allow(ctx, "signup", "u1")
+	require.ErrorIs(t, err, ErrPolicyNotFound)
+	_, err = limiter.Allow(ctx, "login")
+	require.ErrorIs(t, err, ErrInvalidParameters)
+
+	// the reservations of a queue wait, so Allow would admit them too early and leave them in the queue
+	require.NoError(t, registry.Register(Policy{
+		Name:        "export",
+		Rate:        Rate{Tokens: 1, Per: time.Minute},
+		Burst:       1,
+		KeyTemplate: "TestPolicyLimiter_DriverRedis:export:{userID}",
+		Algorithm:   AlgorithmLeakyBucketQueue,
+		MaxQueue:    5,
+	}))
+	_, err = limiter.Allow(ctx, "export", "u1")
+	require.ErrorIs(t, err, ErrInvalidParameters)
+	// the rejected Allow neither consumed the token nor joined the queue
+	r, err = limiter.Reserve(ctx, "export", "u1")
+	require.NoError(t, err)
+	require.True(t, r.OK)
+	require.Equal(t, now.UTC(), r.TimeToAct.UTC())
+	require.Zero(t, r.QueueDepth)
+}
@@ -0,0 +1,83 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testPriorityFloor(t *testing.T, limiter *RateLimiter, key string) {
+	now := time.Now().Truncate(time.Microsecond)
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+
+	newRequest := func(floor int) *ReserveRequest {
+		return &ReserveRequest{
+			Key:              key,
+			DurationPerToken: time.Second,
+			Burst:            10,
+			Tokens:           1,
+			Floor:            floor,
+		}
+	}
+	critical := newRequest(0)
+	bestEffort := newRequest(3)
+
+	// the best-effort requests can not consume the floor
+	for i := 0; i < 7; i++ {
+		r, err := limiter.Reserve(ctx, bestEffort)
+		require.NoError(t, err)
+		require.True(t, r.OK, "best-effort %d", i)
+	}
+	r, err := limiter.Reserve(ctx, bestEffort)
+	require.NoError(t, err)
+	require.False(t, r.OK)
+	require.Equal(t, time.Second, r.RetryAfterFrom(now))
+
+	// but the critical requests can
+	for i := 0; i < 3; i++ {
+		r, err := limiter.Reserve(ctx, critical)
+		require.NoError(t, err)
+		require.True(t, r.OK, "critical %d", i)
+	}
+	r, err = limiter.Reserve(ctx, critical)
+	require.NoError(t, err)
+	require.False(t, r.OK)
+	require.Equal(t, time.Second, r.RetryAfterFrom(now))
+
+	// a refilled token is still in the floor
+	laterCtx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now.Add(time.Second)
+	})
+	r, err = limiter.Reserve(laterCtx, bestEffort)
+	require.NoError(t, err)
+	require.False(t, r.OK)
+	r, err = limiter.Reserve(laterCtx, critical)
+	require.NoError(t, err)
+	require.True(t, r.OK)
+
+	invalid := newRequest(10)
+	_, err = limiter.Reserve(ctx, invalid)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+	invalid = newRequest(3)
+	invalid.Algorithm = AlgorithmSlidingWindowLog
+	_, err = limiter.Reserve(ctx, invalid)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+}
+
+func TestPriorityFloor_DriverGORM(t *testing.T) {
+	testPriorityFloor(t, New(NewGormDriver(db)), "TestPriorityFloor_DriverGORM")
+}
+
+func TestPriorityFloor_DriverSQL(t *testing.T) {
+	testPriorityFloor(t, New(newSQLDriverForTest(t)), "TestPriorityFloor_DriverSQL")
+}
+
+func TestPriorityFloor_DriverRedis(t *testing.T) {
+	d, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+	testPriorityFloor(t, New(d), "TestPriorityFloor_DriverRedis")
+}
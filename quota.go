@@ -0,0 +1,116 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Period is the length of the calendar-aligned windows of AlgorithmQuota.
+type Period int
+
+const (
+	PeriodHour Period = iota + 1
+	PeriodDay
+	// PeriodWeek starts on Monday.
+	PeriodWeek
+	PeriodMonth
+)
+
+func (p Period) String() string {
+	switch p {
+	case PeriodHour:
+		return "hour"
+	case PeriodDay:
+		return "day"
+	case PeriodWeek:
+		return "week"
+	case PeriodMonth:
+		return "month"
+	default:
+		return fmt.Sprintf("Period(%d)", int(p))
+	}
+}
+
+func (p Period) valid() bool {
+	return p >= PeriodHour && p <= PeriodMonth
+}
+
+// Window returns the window of the period that contains t in the location, nil location means UTC.
+func (p Period) Window(t time.Time, loc *time.Location) (start, end time.Time) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	t = t.In(loc)
+	year, month, day := t.Date()
+
+	switch p {
+	case PeriodHour:
+		start = time.Date(year, month, day, t.Hour(), 0, 0, 0, loc)
+		end = start.Add(time.Hour)
+	case PeriodDay:
+		start = time.Date(year, month, day, 0, 0, 0, 0, loc)
+		end = time.Date(year, month, day+1, 0, 0, 0, 0, loc)
+	case PeriodWeek:
+		offset := (int(t.Weekday()) + 6) % 7 // days since Monday
+		start = time.Date(year, month, day-offset, 0, 0, 0, 0, loc)
+		end = time.Date(year, month, day-offset+7, 0, 0, 0, 0, loc)
+	case PeriodMonth:
+		start = time.Date(year, month, 1, 0, 0, 0, 0, loc)
+		end = time.Date(year, month+1, 1, 0, 0, 0, 0, loc)
+	default:
+		panic(fmt.Sprintf("ratelimiter: invalid period %v", p))
+	}
+	return start, end
+}
+
+// quotaState is the state of a key of AlgorithmQuota, which is the usage of its current window.
+type quotaState struct {
+	Start int64 // start of the window, in microseconds
+	Used  int
+}
+
+func (s quotaState) String() string {
+	return fmt.Sprintf("%d:%d", s.Start, s.Used)
+}
+
+func parseQuotaState(v string) (quotaState, error) {
+	var s quotaState
+	if _, err := fmt.Sscanf(v, "%d:%d", &s.Start, &s.Used); err != nil {
+		return s, errors.Wrapf(err, "ratelimiter: failed to parse quota %q", v)
+	}
+	return s, nil
+}
+
+// reserveQuota applies the quota of the window containing now to the state of a key.
+// next is the state with the requested tokens consumed if ok, it should be stored if ok and tokens are requested.
+func reserveQuota(req *ReserveRequest, now time.Time, state quotaState, found bool) (next quotaState, resetAt time.Time, ok bool) {
+	start, end := req.Period.Window(now, req.Location)
+
+	next = quotaState{Start: start.UnixMicro()}
+	if found && state.Start == next.Start {
+		next.Used = state.Used
+	}
+
+	if next.Used+req.Tokens > req.Burst {
+		return next, end.UTC(), false
+	}
+	next.Used += req.Tokens
+	return next, end.UTC(), true
+}
+
+func newQuotaReservation(req *ReserveRequest, now time.Time, used int, resetAt time.Time, ok bool) *Reservation {
+	timeToAct := now
+	if !ok {
+		timeToAct = resetAt
+	}
+	return &Reservation{
+		ReserveRequest: req,
+		OK:             ok,
+		TimeToAct:      timeToAct,
+		Now:            now,
+		Remaining:      max(req.Burst-used, 0),
+		ResetAt:        resetAt,
+	}
+}
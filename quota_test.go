@@ -0,0 +1,121 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeriodWindow(t *testing.T) {
+	tokyo := time.FixedZone("UTC+9", 9*60*60)
+	now := time.Date(2024, time.January, 31, 20, 30, 0, 0, time.UTC) // Wednesday, 2024-02-01 05:30 in UTC+9
+
+	testCases := []struct {
+		period        Period
+		loc           *time.Location
+		expectedStart time.Time
+		expectedEnd   time.Time
+	}{
+		{PeriodHour, nil, time.Date(2024, time.January, 31, 20, 0, 0, 0, time.UTC), time.Date(2024, time.January, 31, 21, 0, 0, 0, time.UTC)},
+		{PeriodDay, nil, time.Date(2024, time.January, 31, 0, 0, 0, 0, time.UTC), time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{PeriodDay, tokyo, time.Date(2024, time.February, 1, 0, 0, 0, 0, tokyo), time.Date(2024, time.February, 2, 0, 0, 0, 0, tokyo)},
+		{PeriodWeek, nil, time.Date(2024, time.January, 29, 0, 0, 0, 0, time.UTC), time.Date(2024, time.February, 5, 0, 0, 0, 0, time.UTC)},
+		{PeriodMonth, nil, time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{PeriodMonth, tokyo, time.Date(2024, time.February, 1, 0, 0, 0, 0, tokyo), time.Date(2024, time.March, 1, 0, 0, 0, 0, tokyo)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.period.String(), func(t *testing.T) {
+			start, end := tc.period.Window(now, tc.loc)
+			require.True(t, tc.expectedStart.Equal(start), "start: %v", start)
+			require.True(t, tc.expectedEnd.Equal(end), "end: %v", end)
+		})
+	}
+
+	// Sunday belongs to the week starting on the previous Monday
+	start, _ := PeriodWeek.Window(time.Date(2024, time.February, 4, 23, 0, 0, 0, time.UTC), nil)
+	require.Equal(t, time.Date(2024, time.January, 29, 0, 0, 0, 0, time.UTC), start)
+
+	require.Panics(t, func() {
+		Period(0).Window(now, nil)
+	})
+}
+
+func testQuota(t *testing.T, limiter *RateLimiter, key string) {
+	loc := time.FixedZone("UTC+9", 9*60*60)
+	now := time.Now().Truncate(time.Microsecond)
+	_, resetAt := PeriodMonth.Window(now, loc)
+	nextMonth := resetAt.Add(time.Hour)
+
+	newRequest := func(tokens int) *ReserveRequest {
+		return &ReserveRequest{
+			Key:       key,
+			Burst:     3,
+			Tokens:    tokens,
+			Algorithm: AlgorithmQuota,
+			Period:    PeriodMonth,
+			Location:  loc,
+		}
+	}
+
+	testCases := []struct {
+		name              string
+		now               time.Time
+		tokens            int
+		expectedOK        bool
+		expectedRemaining int
+		expectedResetAt   time.Time
+	}{
+		{"first token", now, 1, true, 2, resetAt},
+		{"status", now, 0, true, 2, resetAt},
+		{"two tokens", now, 2, true, 0, resetAt},
+		{"quota exceeded", now, 1, false, 0, resetAt},
+		{"next month", nextMonth, 1, true, 2, resetAt.AddDate(0, 1, 0)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+				return tc.now
+			})
+			r, err := limiter.Reserve(ctx, newRequest(tc.tokens))
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedOK, r.OK)
+			require.Equal(t, tc.expectedRemaining, r.Remaining)
+			require.True(t, tc.expectedResetAt.Equal(r.ResetAt), "resetAt: %v", r.ResetAt)
+			if r.OK {
+				require.Equal(t, time.Duration(0), r.DelayFrom(tc.now))
+			} else {
+				require.Equal(t, tc.expectedResetAt.Sub(tc.now), r.RetryAfterFrom(tc.now))
+			}
+		})
+	}
+
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return nextMonth
+	})
+	r, err := limiter.QuotaStatus(ctx, newRequest(1))
+	require.NoError(t, err)
+	require.True(t, r.OK)
+	require.Equal(t, 2, r.Remaining)
+
+	_, err = limiter.QuotaStatus(ctx, &ReserveRequest{Key: key, DurationPerToken: time.Second, Burst: 1, Tokens: 1})
+	require.ErrorIs(t, err, ErrInvalidParameters)
+
+	invalid := newRequest(1)
+	invalid.Period = 0
+	_, err = limiter.Reserve(ctx, invalid)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+}
+
+func TestQuota_DriverGORM(t *testing.T) {
+	testQuota(t, New(NewGormDriver(db)), "TestQuota_DriverGORM")
+}
+
+func TestQuota_DriverRedis(t *testing.T) {
+	d, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+	testQuota(t, New(d), "TestQuota_DriverRedis")
+}
@@ -2,9 +2,53 @@ package ratelimiter
 
 import (
 	"context"
+	"fmt"
 	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Algorithm is the algorithm a driver uses to decide a reservation.
+type Algorithm int
+
+const (
+	// AlgorithmGCRA is the generic cell rate algorithm, which is equivalent to a token bucket
+	// that is refilled with a token every DurationPerToken and holds at most Burst tokens.
+	AlgorithmGCRA Algorithm = iota
+	// AlgorithmSlidingWindowLog admits at most Burst tokens in any rolling window of Burst * DurationPerToken,
+	// it logs the time of every admitted token so it is exact but uses more storage.
+	AlgorithmSlidingWindowLog
+	// AlgorithmSlidingWindowCounter approximates AlgorithmSlidingWindowLog by weighting the count of the previous fixed window,
+	// it only stores two counters per key so it is suitable for keys of very high cardinality.
+	// It does not support MaxFutureReserve.
+	AlgorithmSlidingWindowCounter
+	// AlgorithmQuota admits at most Burst tokens in each calendar-aligned window of Period in Location,
+	// e.g. 10000 calls per calendar month, DurationPerToken is not used.
+	// It does not support MaxFutureReserve, and Tokens may be 0 to query the quota without consuming it.
+	AlgorithmQuota
+	// AlgorithmLeakyBucketQueue is AlgorithmGCRA that keeps the waiting reservations of a key in a FIFO queue,
+	// it reports the queue position and depth of each reservation and allows abandoning a reservation by RateLimiter.Abandon.
+	// It does not support MaxFutureReserve, the waiting reservations are limited by MaxQueue instead.
+	AlgorithmLeakyBucketQueue
 )
 
+func (a Algorithm) String() string {
+	switch a {
+	case AlgorithmGCRA:
+		return "gcra"
+	case AlgorithmSlidingWindowLog:
+		return "sliding_window_log"
+	case AlgorithmSlidingWindowCounter:
+		return "sliding_window_counter"
+	case AlgorithmQuota:
+		return "quota"
+	case AlgorithmLeakyBucketQueue:
+		return "leaky_bucket_queue"
+	default:
+		return fmt.Sprintf("Algorithm(%d)", int(a))
+	}
+}
+
 type AllowRequest struct {
 	Key              string
 	DurationPerToken time.Duration
@@ -18,6 +62,19 @@ type ReserveRequest struct {
 	Burst            int
 	Tokens           int
 	MaxFutureReserve time.Duration
+	Algorithm        Algorithm
+
+	// Period and Location are used by AlgorithmQuota, nil Location means UTC.
+	Period   Period
+	Location *time.Location
+
+	// MaxQueue is used by AlgorithmLeakyBucketQueue, it is the maximum number of reservations waiting in the queue.
+	MaxQueue int
+
+	// Floor is used by AlgorithmGCRA, it is the number of tokens of Burst reserved for higher priorities.
+	// The request is only OK if Floor tokens are left after it, so that the critical requests with Floor 0
+	// keep being admitted when the bucket is nearly drained by the lower priorities.
+	Floor int
 }
 
 type Reservation struct {
@@ -25,6 +82,28 @@ type Reservation struct {
 	OK        bool
 	TimeToAct time.Time
 	Now       time.Time
+
+	// Remaining and ResetAt are only set by AlgorithmQuota,
+	// they are the tokens left in the current window and the time the window ends.
+	Remaining int
+	ResetAt   time.Time
+
+	// QueueID, QueuePosition and QueueDepth are only set by AlgorithmLeakyBucketQueue.
+	// QueuePosition is the number of reservations waiting ahead of this one,
+	// and QueueDepth is the number of waiting reservations including this one if it is queued.
+	// QueueID is empty if the reservation is not OK.
+	QueueID       string
+	QueuePosition int
+	QueueDepth    int
+}
+
+func newReservation(req *ReserveRequest, now time.Time, timeToAct time.Time, ok bool) *Reservation {
+	return &Reservation{
+		ReserveRequest: req,
+		OK:             ok,
+		TimeToAct:      timeToAct,
+		Now:            now,
+	}
 }
 
 func (r *Reservation) DelayFrom(t time.Time) time.Duration {
@@ -48,7 +127,7 @@ func (r *Reservation) RetryAfterFrom(t time.Time) time.Duration {
 		panic("ratelimiter: cannot get retry after from OK reservation")
 	}
 
-	delay := r.TimeToAct.Sub(t) - r.MaxFutureReserve
+	delay := r.TimeToAct.Sub(t) - r.MaxFutureReserve + time.Duration(r.Floor)*r.DurationPerToken
 	if delay < 0 {
 		return 0
 	}
@@ -69,12 +148,25 @@ func (f DriverFunc) Reserve(ctx context.Context, req *ReserveRequest) (*Reservat
 	return f(ctx, req)
 }
 
+// QueueDriver is implemented by the drivers that support AlgorithmLeakyBucketQueue.
+type QueueDriver interface {
+	// AbandonQueue removes a waiting reservation from the queue of the key,
+	// it is a no-op if the reservation is abandoned or its time to act has passed.
+	AbandonQueue(ctx context.Context, key string, id string) error
+}
+
 type RateLimiter struct {
-	driver Driver
+	driver    Driver
+	observers []Observer
+	dryRun    bool
 }
 
-func New(driver Driver) *RateLimiter {
-	return &RateLimiter{driver: driver}
+func New(driver Driver, opts ...Option) *RateLimiter {
+	lim := &RateLimiter{driver: driver}
+	for _, opt := range opts {
+		opt(lim)
+	}
+	return lim
 }
 
 func (lim *RateLimiter) Allow(ctx context.Context, req *AllowRequest) (bool, error) {
@@ -94,5 +186,36 @@ func (lim *RateLimiter) Allow(ctx context.Context, req *AllowRequest) (bool, err
 }
 
 func (lim *RateLimiter) Reserve(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
-	return lim.driver.Reserve(ctx, req)
+	return lim.reserve(ctx, "", false, req)
+}
+
+// QuotaStatus returns the remaining tokens and the reset time of the current window of an AlgorithmQuota request without consuming it.
+func (lim *RateLimiter) QuotaStatus(ctx context.Context, req *ReserveRequest) (*Reservation, error) {
+	if req.Algorithm != AlgorithmQuota {
+		return nil, errors.Wrapf(ErrInvalidParameters, "QuotaStatus does not support %v", req.Algorithm)
+	}
+
+	statusReq := *req
+	statusReq.Tokens = 0
+	// not a decision, so it is not observed
+	return lim.driver.Reserve(ctx, &statusReq)
+}
+
+// Abandon removes an OK reservation of AlgorithmLeakyBucketQueue from the queue, e.g. when the caller stops waiting.
+// The reservations behind it keep their time to act to preserve the FIFO order,
+// so only abandoning the last reservation gives its tokens back.
+func (lim *RateLimiter) Abandon(ctx context.Context, r *Reservation) error {
+	if r.Algorithm != AlgorithmLeakyBucketQueue || !r.OK {
+		return errors.Wrapf(ErrInvalidParameters, "Abandon only supports OK reservations of %v", AlgorithmLeakyBucketQueue)
+	}
+	// the reservations of the dry-run calls are not queued
+	if r.QueueID == "" {
+		return nil
+	}
+
+	d, ok := lim.driver.(QueueDriver)
+	if !ok {
+		return errors.Wrapf(ErrUnsupportedAlgorithm, "%v", r.Algorithm)
+	}
+	return d.AbandonQueue(ctx, r.Key, r.QueueID)
 }
@@ -35,7 +35,7 @@ func TestMain(m *testing.M) {
 	db = env.DB
 	// db.Logger = db.Logger.LogMode(logger.Info)
 
-	if err = db.AutoMigrate(&KV{}); err != nil {
+	if err = db.AutoMigrate(&KV{}, &KVEvent{}, &KVSemaphore{}, &KVLease{}, &KVOverride{}); err != nil {
 		panic(err)
 	}
 
@@ -83,7 +83,7 @@ func testReverseWithNowAdvanced(t *testing.T, limiter *RateLimiter, key string)
 	durationPerToken := time.Second
 	burst := 10
 
-	now := time.Now()
+	now := time.Now().Truncate(time.Microsecond) // drivers store times in microseconds
 	testCases := []struct {
 		name                string
 		reserveRequest      *ReserveRequest
@@ -0,0 +1,225 @@
+// Package ratelimiterotel instruments the drivers of ratelimiter with OpenTelemetry traces and metrics.
+package ratelimiterotel
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/pkg/errors"
+	"github.com/theplant/ratelimiter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/theplant/ratelimiter/ratelimiterotel"
+
+type Options struct {
+	// TracerProvider is used for the spans, if it is nil, the provider of the span in the context is used,
+	// or the global provider if there is no span in the context.
+	TracerProvider trace.TracerProvider
+	// MeterProvider is used for the metrics, the default is the global provider.
+	MeterProvider metric.MeterProvider
+	// Name is the driver.name attribute of the spans and metrics, e.g. "redis".
+	Name string
+}
+
+// Driver is a ratelimiter.Driver that traces and measures the calls of another driver.
+// The keys are recorded as hashes, so the spans do not leak user identifiers.
+// It also implements ratelimiter.QueueDriver and ratelimiter.HierarchicalDriver if the wrapped driver does.
+type Driver struct {
+	driver         ratelimiter.Driver
+	tracerProvider trace.TracerProvider
+	name           string
+
+	decisions metric.Int64Counter
+	errors    metric.Int64Counter
+	duration  metric.Float64Histogram
+	delay     metric.Float64Histogram
+}
+
+var (
+	_ ratelimiter.Driver             = (*Driver)(nil)
+	_ ratelimiter.QueueDriver        = (*Driver)(nil)
+	_ ratelimiter.HierarchicalDriver = (*Driver)(nil)
+)
+
+// NewDriver returns an instrumented driver, it fails only if the instruments cannot be created.
+func NewDriver(driver ratelimiter.Driver, opts Options) (*Driver, error) {
+	meterProvider := opts.MeterProvider
+	if meterProvider == nil {
+		meterProvider = otel.GetMeterProvider()
+	}
+	meter := meterProvider.Meter(instrumentationName)
+
+	d := &Driver{
+		driver:         driver,
+		tracerProvider: opts.TracerProvider,
+		name:           opts.Name,
+	}
+
+	var err error
+	if d.decisions, err = meter.Int64Counter("ratelimiter.decisions",
+		metric.WithDescription("The number of decisions by result."),
+	); err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to create decisions counter")
+	}
+	if d.errors, err = meter.Int64Counter("ratelimiter.errors",
+		metric.WithDescription("The number of failed driver calls."),
+	); err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to create errors counter")
+	}
+	if d.duration, err = meter.Float64Histogram("ratelimiter.driver.duration",
+		metric.WithDescription("The duration of the driver calls."),
+		metric.WithUnit("s"),
+	); err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to create duration histogram")
+	}
+	if d.delay, err = meter.Float64Histogram("ratelimiter.reservation.delay",
+		metric.WithDescription("The delay of the allowed reservations."),
+		metric.WithUnit("s"),
+	); err != nil {
+		return nil, errors.Wrap(err, "ratelimiter: failed to create delay histogram")
+	}
+	return d, nil
+}
+
+func (d *Driver) tracer(ctx context.Context) trace.Tracer {
+	if d.tracerProvider != nil {
+		return d.tracerProvider.Tracer(instrumentationName)
+	}
+	if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+		return span.TracerProvider().Tracer(instrumentationName)
+	}
+	return otel.GetTracerProvider().Tracer(instrumentationName)
+}
+
+// KeyHash returns the hash of the key recorded in the spans, so a key can be found by hashing it.
+func KeyHash(key string) string {
+	return strconv.FormatUint(xxhash.Sum64String(key), 16)
+}
+
+func requestAttributes(req *ratelimiter.ReserveRequest) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("ratelimiter.key_hash", KeyHash(req.Key)),
+		attribute.String("ratelimiter.algorithm", req.Algorithm.String()),
+		attribute.Int("ratelimiter.tokens", req.Tokens),
+		attribute.Int("ratelimiter.burst", req.Burst),
+	}
+}
+
+func (d *Driver) Reserve(ctx context.Context, req *ratelimiter.ReserveRequest) (*ratelimiter.Reservation, error) {
+	ctx, span := d.tracer(ctx).Start(ctx, "ratelimiter.Reserve",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(requestAttributes(req)...),
+		trace.WithAttributes(attribute.String("driver.name", d.name)),
+	)
+	defer span.End()
+
+	start := time.Now()
+	r, err := d.driver.Reserve(ctx, req)
+	elapsed := time.Since(start)
+	if err != nil {
+		d.record(ctx, span, req.Algorithm, false, 0, err, elapsed)
+		return nil, err
+	}
+	d.record(ctx, span, req.Algorithm, r.OK, wait(r), nil, elapsed)
+	return r, nil
+}
+
+// ReserveHierarchy traces the reservation of the levels in one span, the attributes are of the leaf level.
+func (d *Driver) ReserveHierarchy(ctx context.Context, reqs []*ratelimiter.ReserveRequest) (*ratelimiter.HierarchicalReservation, error) {
+	hd, ok := d.driver.(ratelimiter.HierarchicalDriver)
+	if !ok {
+		return nil, errors.Wrap(ratelimiter.ErrUnsupportedAlgorithm, "driver does not support hierarchical limits")
+	}
+
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("driver.name", d.name),
+			attribute.Int("ratelimiter.levels", len(reqs)),
+		),
+	}
+	if len(reqs) > 0 {
+		opts = append(opts, trace.WithAttributes(requestAttributes(reqs[len(reqs)-1])...))
+	}
+	ctx, span := d.tracer(ctx).Start(ctx, "ratelimiter.ReserveHierarchy", opts...)
+	defer span.End()
+
+	start := time.Now()
+	hr, err := hd.ReserveHierarchy(ctx, reqs)
+	elapsed := time.Since(start)
+	if err != nil {
+		d.record(ctx, span, ratelimiter.AlgorithmGCRA, false, 0, err, elapsed)
+		return nil, err
+	}
+
+	if !hr.OK {
+		span.SetAttributes(attribute.Int("ratelimiter.denied_level", hr.DeniedLevel))
+		d.record(ctx, span, ratelimiter.AlgorithmGCRA, false, hr.RetryAfterFrom(hr.Now), nil, elapsed)
+		return hr, nil
+	}
+	d.record(ctx, span, ratelimiter.AlgorithmGCRA, true, hr.DelayFrom(hr.Now), nil, elapsed)
+	return hr, nil
+}
+
+func (d *Driver) AbandonQueue(ctx context.Context, key string, id string) error {
+	qd, ok := d.driver.(ratelimiter.QueueDriver)
+	if !ok {
+		return errors.Wrapf(ratelimiter.ErrUnsupportedAlgorithm, "%v", ratelimiter.AlgorithmLeakyBucketQueue)
+	}
+
+	ctx, span := d.tracer(ctx).Start(ctx, "ratelimiter.AbandonQueue",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("driver.name", d.name),
+			attribute.String("ratelimiter.key_hash", KeyHash(key)),
+		),
+	)
+	defer span.End()
+
+	err := qd.AbandonQueue(ctx, key, id)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// record records the decision, wait is the delay of an OK decision or the retry after of a denied one.
+func (d *Driver) record(ctx context.Context, span trace.Span, algorithm ratelimiter.Algorithm, ok bool, wait time.Duration, err error, elapsed time.Duration) {
+	attrs := []attribute.KeyValue{
+		attribute.String("driver.name", d.name),
+		attribute.String("ratelimiter.algorithm", algorithm.String()),
+	}
+	d.duration.Record(ctx, elapsed.Seconds(), metric.WithAttributes(attrs...))
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		d.errors.Add(ctx, 1, metric.WithAttributes(attrs...))
+		return
+	}
+
+	span.SetAttributes(attribute.Bool("ratelimiter.ok", ok))
+	d.decisions.Add(ctx, 1, metric.WithAttributes(append(attrs, attribute.Bool("ratelimiter.ok", ok))...))
+	if !ok {
+		span.SetAttributes(attribute.Float64("ratelimiter.retry_after", wait.Seconds()))
+		return
+	}
+	span.SetAttributes(attribute.Float64("ratelimiter.delay", wait.Seconds()))
+	d.delay.Record(ctx, wait.Seconds(), metric.WithAttributes(attrs...))
+}
+
+// wait returns the delay of an OK reservation or the retry after of a denied one.
+func wait(r *ratelimiter.Reservation) time.Duration {
+	if r.OK {
+		return r.DelayFrom(r.Now)
+	}
+	return r.RetryAfterFrom(r.Now)
+}
@@ -0,0 +1,111 @@
+package ratelimiterotel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/theplant/ratelimiter"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestDriver(t *testing.T) {
+	errDriver := errors.New("driver failed")
+	now := time.Now()
+	driver := ratelimiter.DriverFunc(func(ctx context.Context, req *ratelimiter.ReserveRequest) (*ratelimiter.Reservation, error) {
+		switch req.Key {
+		case "denied":
+			return &ratelimiter.Reservation{ReserveRequest: req, Now: now, TimeToAct: now.Add(2 * time.Second)}, nil
+		case "error":
+			return nil, errDriver
+		default:
+			return &ratelimiter.Reservation{ReserveRequest: req, OK: true, Now: now, TimeToAct: now.Add(time.Second)}, nil
+		}
+	})
+
+	recorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	reader := sdkmetric.NewManualReader()
+	meterProvider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	d, err := NewDriver(driver, Options{MeterProvider: meterProvider, Name: "test"})
+	require.NoError(t, err)
+	limiter := ratelimiter.New(d)
+
+	// the tracer provider of the span in the context is used
+	ctx, parent := tracerProvider.Tracer("test").Start(context.Background(), "parent")
+	for _, key := range []string{"allowed", "denied", "error"} {
+		_, _ = limiter.Reserve(ctx, &ratelimiter.ReserveRequest{Key: key, DurationPerToken: time.Second, Burst: 3, Tokens: 2})
+	}
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 4)
+	for _, span := range spans[:3] {
+		require.Equal(t, "ratelimiter.Reserve", span.Name())
+		require.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+	}
+
+	allowed := spanAttributes(spans[0])
+	require.Equal(t, KeyHash("allowed"), allowed["ratelimiter.key_hash"].AsString())
+	require.Equal(t, int64(2), allowed["ratelimiter.tokens"].AsInt64())
+	require.Equal(t, int64(3), allowed["ratelimiter.burst"].AsInt64())
+	require.Equal(t, "gcra", allowed["ratelimiter.algorithm"].AsString())
+	require.True(t, allowed["ratelimiter.ok"].AsBool())
+	require.Equal(t, 1.0, allowed["ratelimiter.delay"].AsFloat64())
+	require.Equal(t, "test", allowed["driver.name"].AsString())
+
+	denied := spanAttributes(spans[1])
+	require.False(t, denied["ratelimiter.ok"].AsBool())
+	require.Equal(t, 2.0, denied["ratelimiter.retry_after"].AsFloat64())
+
+	require.Equal(t, codes.Error, spans[2].Status().Code)
+	require.Len(t, spans[2].Events(), 1)
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+	metrics := make(map[string]metricdata.Aggregation)
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m.Data
+	}
+
+	decisions := metrics["ratelimiter.decisions"].(metricdata.Sum[int64])
+	require.Len(t, decisions.DataPoints, 2)
+	for _, dp := range decisions.DataPoints {
+		require.Equal(t, int64(1), dp.Value)
+	}
+	errs := metrics["ratelimiter.errors"].(metricdata.Sum[int64])
+	require.Len(t, errs.DataPoints, 1)
+	require.Equal(t, int64(1), errs.DataPoints[0].Value)
+	duration := metrics["ratelimiter.driver.duration"].(metricdata.Histogram[float64])
+	require.Equal(t, uint64(3), duration.DataPoints[0].Count)
+	delay := metrics["ratelimiter.reservation.delay"].(metricdata.Histogram[float64])
+	require.Equal(t, uint64(1), delay.DataPoints[0].Count)
+	require.Equal(t, 1.0, delay.DataPoints[0].Sum)
+}
+
+func TestDriver_Unsupported(t *testing.T) {
+	d, err := NewDriver(ratelimiter.DriverFunc(nil), Options{})
+	require.NoError(t, err)
+
+	_, err = ratelimiter.New(d).ReserveHierarchy(context.Background(), &ratelimiter.ReserveRequest{})
+	require.ErrorIs(t, err, ratelimiter.ErrUnsupportedAlgorithm)
+	err = d.AbandonQueue(context.Background(), "key", "id")
+	require.ErrorIs(t, err, ratelimiter.ErrUnsupportedAlgorithm)
+}
@@ -0,0 +1,188 @@
+// Package ratelimiterprom exports the decisions of ratelimiter as Prometheus metrics.
+package ratelimiterprom
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/theplant/ratelimiter"
+)
+
+// OtherLabel is the label value of the policies beyond MaxPolicies.
+const OtherLabel = "other"
+
+type Options struct {
+	// Namespace and Subsystem prefix the metric names, the default namespace is "ratelimiter".
+	Namespace string
+	Subsystem string
+
+	// PolicyLabel returns the policy label of an observation, the default is the policy name of a PolicyLimiter,
+	// or the part of the key before the first ":" for a RateLimiter, e.g. "login" for "login:user1".
+	// It must not return the key itself, otherwise every key becomes a series.
+	PolicyLabel func(o *ratelimiter.Observation) string
+	// MaxPolicies is the maximum number of distinct policy labels, the rest are recorded as OtherLabel.
+	// The default is 100, a negative value means unlimited.
+	MaxPolicies int
+
+	// DelayBuckets are the buckets in seconds of the delays of the OK reservations, the default is
+	// prometheus.ExponentialBuckets(0.001, 4, 10).
+	DelayBuckets []float64
+	// LatencyBuckets are the buckets in seconds of the driver latencies, the default is prometheus.DefBuckets.
+	LatencyBuckets []float64
+}
+
+// Collector is both a prometheus.Collector and a ratelimiter.Observer, e.g.
+//
+//	collector := ratelimiterprom.NewCollector(ratelimiterprom.Options{})
+//	prometheus.MustRegister(collector)
+//	limiter := ratelimiter.New(driver, ratelimiter.WithObserver(collector))
+type Collector struct {
+	policyLabel func(o *ratelimiter.Observation) string
+	maxPolicies int
+
+	decisions *prometheus.CounterVec
+	delay     *prometheus.HistogramVec
+	latency   *prometheus.HistogramVec
+	errors    *prometheus.CounterVec
+
+	mu       sync.Mutex
+	policies map[string]struct{}
+}
+
+var _ ratelimiter.Observer = (*Collector)(nil)
+var _ prometheus.Collector = (*Collector)(nil)
+
+func NewCollector(opts Options) *Collector {
+	if opts.Namespace == "" {
+		opts.Namespace = "ratelimiter"
+	}
+	if opts.PolicyLabel == nil {
+		opts.PolicyLabel = DefaultPolicyLabel
+	}
+	if opts.MaxPolicies == 0 {
+		opts.MaxPolicies = 100
+	}
+	if opts.DelayBuckets == nil {
+		opts.DelayBuckets = prometheus.ExponentialBuckets(0.001, 4, 10)
+	}
+	if opts.LatencyBuckets == nil {
+		opts.LatencyBuckets = prometheus.DefBuckets
+	}
+
+	return &Collector{
+		policyLabel: opts.PolicyLabel,
+		maxPolicies: opts.MaxPolicies,
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "decisions_total",
+			Help:      "The number of decisions by policy and result, which is allowed or denied, the dry-run decisions are counted separately.",
+		}, []string{"policy", "result", "dry_run"}),
+		delay: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "reservation_delay_seconds",
+			Help:      "The delays of the allowed reservations by policy.",
+			Buckets:   opts.DelayBuckets,
+		}, []string{"policy"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "driver_latency_seconds",
+			Help:      "The latencies of the driver calls by driver type.",
+			Buckets:   opts.LatencyBuckets,
+		}, []string{"driver"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "driver_errors_total",
+			Help:      "The number of failed driver calls by policy and driver type.",
+		}, []string{"policy", "driver"}),
+		policies: make(map[string]struct{}),
+	}
+}
+
+// DefaultPolicyLabel returns the policy name of a PolicyLimiter, or the part of the key before the first ":",
+// ignoring the prefix of the shadow buckets of the dry-run calls.
+func DefaultPolicyLabel(o *ratelimiter.Observation) string {
+	if o.Policy != "" {
+		return o.Policy
+	}
+	key := o.Request.Key
+	if o.DryRun {
+		key = strings.TrimPrefix(key, ratelimiter.ShadowKeyPrefix)
+	}
+	prefix, _, _ := strings.Cut(key, ":")
+	return prefix
+}
+
+// DriverLabel returns the type name of the driver, e.g. "RedisDriver".
+func DriverLabel(d ratelimiter.Driver) string {
+	t := reflect.TypeOf(d)
+	if t == nil {
+		return ""
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+func (c *Collector) Observe(ctx context.Context, o *ratelimiter.Observation) {
+	policy := c.limitPolicy(c.policyLabel(o))
+	driver := DriverLabel(o.Driver)
+
+	c.latency.WithLabelValues(driver).Observe(o.Latency.Seconds())
+	if o.Err != nil {
+		c.errors.WithLabelValues(policy, driver).Inc()
+		return
+	}
+
+	dryRun := strconv.FormatBool(o.DryRun)
+	r := o.Reservation
+	if !r.OK {
+		c.decisions.WithLabelValues(policy, "denied", dryRun).Inc()
+		return
+	}
+	c.decisions.WithLabelValues(policy, "allowed", dryRun).Inc()
+	// the dry-run calls are not delayed
+	if !o.DryRun {
+		c.delay.WithLabelValues(policy).Observe(r.DelayFrom(r.Now).Seconds())
+	}
+}
+
+// limitPolicy returns OtherLabel for the new policies once MaxPolicies are seen.
+func (c *Collector) limitPolicy(policy string) string {
+	if c.maxPolicies < 0 {
+		return policy
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.policies[policy]; ok {
+		return policy
+	}
+	if len(c.policies) >= c.maxPolicies {
+		return OtherLabel
+	}
+	c.policies[policy] = struct{}{}
+	return policy
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.decisions.Describe(ch)
+	c.delay.Describe(ch)
+	c.latency.Describe(ch)
+	c.errors.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.decisions.Collect(ch)
+	c.delay.Collect(ch)
+	c.latency.Collect(ch)
+	c.errors.Collect(ch)
+}
@@ -0,0 +1,71 @@
+package ratelimiterprom
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/theplant/ratelimiter"
+)
+
+func TestCollector(t *testing.T) {
+	errDriver := errors.New("driver failed")
+	driver := ratelimiter.DriverFunc(func(ctx context.Context, req *ratelimiter.ReserveRequest) (*ratelimiter.Reservation, error) {
+		now := time.Now()
+		switch {
+		case strings.HasSuffix(req.Key, ":denied"):
+			return &ratelimiter.Reservation{ReserveRequest: req, Now: now, TimeToAct: now.Add(time.Second)}, nil
+		case strings.HasSuffix(req.Key, ":error"):
+			return nil, errDriver
+		default:
+			return &ratelimiter.Reservation{ReserveRequest: req, OK: true, Now: now, TimeToAct: now.Add(10 * time.Millisecond)}, nil
+		}
+	})
+
+	collector := NewCollector(Options{MaxPolicies: 2})
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(collector))
+	limiter := ratelimiter.New(driver, ratelimiter.WithObserver(collector))
+
+	for _, key := range []string{"login:u1", "login:u2", "login:denied", "upload:u1", "upload:error", "export:u1", "search:denied"} {
+		_, _ = limiter.Reserve(context.Background(), &ratelimiter.ReserveRequest{Key: key, DurationPerToken: time.Second, Burst: 1, Tokens: 1})
+	}
+
+	// the dry-run calls are counted separately
+	dryRunLimiter := ratelimiter.New(driver, ratelimiter.WithDryRun(), ratelimiter.WithObserver(collector))
+	r, err := dryRunLimiter.Reserve(context.Background(), &ratelimiter.ReserveRequest{Key: "login:denied", DurationPerToken: time.Second, Burst: 1, Tokens: 1})
+	require.NoError(t, err)
+	require.True(t, r.OK)
+
+	// export and search are beyond MaxPolicies
+	expected := `
+# HELP ratelimiter_decisions_total The number of decisions by policy and result, which is allowed or denied, the dry-run decisions are counted separately.
+# TYPE ratelimiter_decisions_total counter
+ratelimiter_decisions_total{dry_run="false",policy="login",result="allowed"} 2
+ratelimiter_decisions_total{dry_run="false",policy="login",result="denied"} 1
+ratelimiter_decisions_total{dry_run="false",policy="other",result="allowed"} 1
+ratelimiter_decisions_total{dry_run="false",policy="other",result="denied"} 1
+ratelimiter_decisions_total{dry_run="false",policy="upload",result="allowed"} 1
+ratelimiter_decisions_total{dry_run="true",policy="login",result="denied"} 1
+# HELP ratelimiter_driver_errors_total The number of failed driver calls by policy and driver type.
+# TYPE ratelimiter_driver_errors_total counter
+ratelimiter_driver_errors_total{driver="DriverFunc",policy="upload"} 1
+`
+	require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "ratelimiter_decisions_total", "ratelimiter_driver_errors_total"))
+
+	count, err := testutil.GatherAndCount(registry, "ratelimiter_reservation_delay_seconds", "ratelimiter_driver_latency_seconds")
+	require.NoError(t, err)
+	// delay of login, upload and other, latency of DriverFunc
+	require.Equal(t, 4, count)
+}
+
+func TestDriverLabel(t *testing.T) {
+	require.Equal(t, "RedisDriver", DriverLabel(&ratelimiter.RedisDriver{}))
+	require.Equal(t, "DriverFunc", DriverLabel(ratelimiter.DriverFunc(nil)))
+	require.Equal(t, "", DriverLabel(nil))
+}
@@ -0,0 +1,66 @@
+package ratelimiterprom
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/theplant/ratelimiter"
+)
+
+// MirrorCollector records the comparisons of a ratelimiter.MirrorDriver, e.g.
+//
+//	collector := ratelimiterprom.NewMirrorCollector(ratelimiterprom.Options{})
+//	prometheus.MustRegister(collector)
+//	driver := ratelimiter.NewMirrorDriver(oldDriver, newDriver, collector.Observe)
+type MirrorCollector struct {
+	comparisons *prometheus.CounterVec
+	drift       *prometheus.HistogramVec
+}
+
+var _ prometheus.Collector = (*MirrorCollector)(nil)
+
+// NewMirrorCollector returns a collector, only the Namespace, the Subsystem and the DelayBuckets of the options are used,
+// the latter for the absolute drifts.
+func NewMirrorCollector(opts Options) *MirrorCollector {
+	if opts.Namespace == "" {
+		opts.Namespace = "ratelimiter"
+	}
+	if opts.DelayBuckets == nil {
+		opts.DelayBuckets = prometheus.ExponentialBuckets(0.001, 4, 10)
+	}
+
+	return &MirrorCollector{
+		comparisons: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "mirror_comparisons_total",
+			Help:      "The number of comparisons of the mirrored decisions by primary driver type and result.",
+		}, []string{"primary", "result"}),
+		drift: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "mirror_drift_seconds",
+			Help:      "The absolute differences of the times to act of the mirrored decisions that both drivers allowed.",
+			Buckets:   opts.DelayBuckets,
+		}, []string{"primary"}),
+	}
+}
+
+// Observe is the onCompare of ratelimiter.NewMirrorDriver.
+func (c *MirrorCollector) Observe(ctx context.Context, comparison *ratelimiter.MirrorComparison) {
+	primary := DriverLabel(comparison.PrimaryDriver)
+	if comparison.Result == ratelimiter.MirrorAgreed && comparison.Primary.OK {
+		c.drift.WithLabelValues(primary).Observe(comparison.Drift.Abs().Seconds())
+	}
+	c.comparisons.WithLabelValues(primary, comparison.Result.String()).Inc()
+}
+
+func (c *MirrorCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.comparisons.Describe(ch)
+	c.drift.Describe(ch)
+}
+
+func (c *MirrorCollector) Collect(ch chan<- prometheus.Metric) {
+	c.comparisons.Collect(ch)
+	c.drift.Collect(ch)
+}
@@ -0,0 +1,46 @@
+package ratelimiterprom
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/theplant/ratelimiter"
+)
+
+func TestMirrorCollector(t *testing.T) {
+	now := time.Now()
+	newDriver := func(ok bool, delay time.Duration) ratelimiter.DriverFunc {
+		return func(ctx context.Context, req *ratelimiter.ReserveRequest) (*ratelimiter.Reservation, error) {
+			return &ratelimiter.Reservation{ReserveRequest: req, OK: ok || req.Key == "both", Now: now, TimeToAct: now.Add(delay)}, nil
+		}
+	}
+
+	collector := NewMirrorCollector(Options{})
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(collector))
+	d := ratelimiter.NewMirrorDriver(newDriver(true, 0), newDriver(false, 10*time.Millisecond), collector.Observe)
+
+	for _, key := range []string{"both", "both", "primary"} {
+		_, err := d.Reserve(context.Background(), &ratelimiter.ReserveRequest{Key: key, DurationPerToken: time.Second, Burst: 1, Tokens: 1})
+		require.NoError(t, err)
+	}
+	expected := `
+# HELP ratelimiter_mirror_comparisons_total The number of comparisons of the mirrored decisions by primary driver type and result.
+# TYPE ratelimiter_mirror_comparisons_total counter
+ratelimiter_mirror_comparisons_total{primary="DriverFunc",result="agreed"} 2
+ratelimiter_mirror_comparisons_total{primary="DriverFunc",result="primary_only"} 1
+`
+	// the comparisons are done in the background
+	require.Eventually(t, func() bool {
+		return testutil.GatherAndCompare(registry, strings.NewReader(expected), "ratelimiter_mirror_comparisons_total") == nil
+	}, time.Second, time.Millisecond)
+
+	count, err := testutil.GatherAndCount(registry, "ratelimiter_mirror_drift_seconds")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
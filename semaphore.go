@@ -0,0 +1,127 @@
+package ratelimiter
+
+import (
+	"context"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LeaseRequest is the request of a SemaphoreDriver to acquire or renew a lease.
+type LeaseRequest struct {
+	Key   string
+	ID    string
+	Limit int
+	TTL   time.Duration
+}
+
+// SemaphoreDriver is the storage of a Semaphore.
+type SemaphoreDriver interface {
+	// AcquireLease acquires a lease if there are less than Limit unexpired leases of the key,
+	// otherwise it returns ErrConcurrencyLimitExceeded.
+	AcquireLease(ctx context.Context, req *LeaseRequest) (expiresAt time.Time, err error)
+	// RenewLease extends an unexpired lease to expire after TTL, otherwise it returns ErrLeaseNotFound.
+	RenewLease(ctx context.Context, req *LeaseRequest) (expiresAt time.Time, err error)
+	// ReleaseLease releases a lease, it is a no-op if the lease does not exist.
+	ReleaseLease(ctx context.Context, key string, id string) error
+}
+
+// Semaphore is a distributed semaphore which limits the number of concurrent holders of a key,
+// e.g. at most 20 in-flight exports per tenant.
+type Semaphore struct {
+	driver SemaphoreDriver
+}
+
+func NewSemaphore(driver SemaphoreDriver) *Semaphore {
+	return &Semaphore{driver: driver}
+}
+
+// Lease is held until it is released or expires.
+// Long-running work should renew the lease before it expires, e.g. by KeepAlive.
+type Lease struct {
+	Key       string
+	ID        string
+	ExpiresAt time.Time
+
+	driver SemaphoreDriver
+}
+
+// minLeaseTTL is the shortest ttl of a lease, the drivers store the expiration in microseconds
+// and KeepAlive renews the lease every third of ttl.
+const minLeaseTTL = 3 * time.Microsecond
+
+// Acquire acquires a lease of the key that expires after ttl, which must be at least 3µs,
+// it returns ErrConcurrencyLimitExceeded if there are already limit unexpired leases.
+func (s *Semaphore) Acquire(ctx context.Context, key string, limit int, ttl time.Duration) (*Lease, error) {
+	if key == "" || limit <= 0 || ttl < minLeaseTTL {
+		return nil, errors.Wrapf(ErrInvalidParameters, "key: %q, limit: %d, ttl: %v", key, limit, ttl)
+	}
+
+	req := &LeaseRequest{
+		Key:   key,
+		ID:    strconv.FormatUint(rand.Uint64(), 36),
+		Limit: limit,
+		TTL:   ttl,
+	}
+	expiresAt, err := s.driver.AcquireLease(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Lease{
+		Key:       key,
+		ID:        req.ID,
+		ExpiresAt: expiresAt,
+		driver:    s.driver,
+	}, nil
+}
+
+// Renew extends the lease to expire after ttl, it returns ErrLeaseNotFound if the lease is expired or released.
+func (l *Lease) Renew(ctx context.Context, ttl time.Duration) error {
+	if ttl < minLeaseTTL {
+		return errors.Wrapf(ErrInvalidParameters, "ttl: %v", ttl)
+	}
+
+	expiresAt, err := l.driver.RenewLease(ctx, &LeaseRequest{
+		Key: l.Key,
+		ID:  l.ID,
+		TTL: ttl,
+	})
+	if err != nil {
+		return err
+	}
+	l.ExpiresAt = expiresAt
+	return nil
+}
+
+// KeepAlive renews the lease to expire after ttl every third of ttl until the context is done,
+// it returns the error if a renewal fails, e.g. ErrLeaseNotFound if the lease is lost.
+func (l *Lease) KeepAlive(ctx context.Context, ttl time.Duration) error {
+	if ttl < minLeaseTTL {
+		return errors.Wrapf(ErrInvalidParameters, "ttl: %v", ttl)
+	}
+
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := l.Renew(ctx, ttl); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+}
+
+// Release releases the lease so that others can acquire it.
+func (l *Lease) Release(ctx context.Context) error {
+	return l.driver.ReleaseLease(ctx, l.Key, l.ID)
+}
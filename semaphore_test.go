@@ -0,0 +1,185 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testSemaphore(t *testing.T, driver SemaphoreDriver, key string) {
+	s := NewSemaphore(driver)
+	now := time.Now().Truncate(time.Microsecond)
+	ttl := 10 * time.Second
+
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+
+	lease1, err := s.Acquire(ctx, key, 2, ttl)
+	require.NoError(t, err)
+	require.True(t, now.Add(ttl).Equal(lease1.ExpiresAt), "expiresAt: %v", lease1.ExpiresAt)
+
+	lease2, err := s.Acquire(ctx, key, 2, ttl)
+	require.NoError(t, err)
+	require.NotEqual(t, lease1.ID, lease2.ID)
+
+	_, err = s.Acquire(ctx, key, 2, ttl)
+	require.ErrorIs(t, err, ErrConcurrencyLimitExceeded)
+
+	// release frees a slot
+	require.NoError(t, lease1.Release(ctx))
+	require.NoError(t, lease1.Release(ctx))
+	lease3, err := s.Acquire(ctx, key, 2, ttl)
+	require.NoError(t, err)
+
+	// renew extends the lease
+	later := now.Add(ttl / 2)
+	laterCtx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return later
+	})
+	require.NoError(t, lease3.Renew(laterCtx, ttl))
+	require.True(t, later.Add(ttl).Equal(lease3.ExpiresAt), "expiresAt: %v", lease3.ExpiresAt)
+
+	// lease2 expires, but lease3 is renewed
+	expiredCtx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now.Add(ttl)
+	})
+	err = lease2.Renew(expiredCtx, ttl)
+	require.ErrorIs(t, err, ErrLeaseNotFound)
+	err = lease1.Renew(expiredCtx, ttl)
+	require.ErrorIs(t, err, ErrLeaseNotFound)
+
+	lease4, err := s.Acquire(expiredCtx, key, 2, ttl)
+	require.NoError(t, err)
+	_, err = s.Acquire(expiredCtx, key, 2, ttl)
+	require.ErrorIs(t, err, ErrConcurrencyLimitExceeded)
+
+	require.NoError(t, lease3.Release(ctx))
+	require.NoError(t, lease4.Release(ctx))
+
+	_, err = s.Acquire(ctx, key, 0, ttl)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+	_, err = s.Acquire(ctx, key, 1, 0)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+	_, err = s.Acquire(ctx, key, 1, time.Microsecond)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+	err = lease3.KeepAlive(ctx, 2*time.Nanosecond)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+	err = lease3.Renew(ctx, time.Nanosecond)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+}
+
+func testSemaphoreKeepAlive(t *testing.T, driver SemaphoreDriver, key string) {
+	s := NewSemaphore(driver)
+	ttl := 300 * time.Millisecond
+
+	lease, err := s.Acquire(context.Background(), key, 1, ttl)
+	require.NoError(t, err)
+	expiresAt := lease.ExpiresAt
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*ttl)
+	defer cancel()
+	require.NoError(t, lease.KeepAlive(ctx, ttl))
+	require.True(t, lease.ExpiresAt.After(expiresAt.Add(ttl/2)), "expiresAt: %v", lease.ExpiresAt)
+
+	// the lease is still held after the original ttl
+	_, err = s.Acquire(context.Background(), key, 1, ttl)
+	require.ErrorIs(t, err, ErrConcurrencyLimitExceeded)
+
+	require.NoError(t, lease.Release(context.Background()))
+	err = lease.Renew(context.Background(), ttl)
+	require.ErrorIs(t, err, ErrLeaseNotFound)
+}
+
+// testSemaphoreWithRateLimit checks that a key can be used by both a semaphore and a rate limit.
+func testSemaphoreWithRateLimit(t *testing.T, driver interface {
+	Driver
+	SemaphoreDriver
+	StateDriver
+}, key string,
+) {
+	ctx := context.Background()
+	limiter := New(driver)
+	req := &ReserveRequest{Key: key, DurationPerToken: time.Second, Burst: 2, Tokens: 1}
+
+	r, err := limiter.Reserve(ctx, req)
+	require.NoError(t, err)
+	require.True(t, r.OK)
+
+	lease, err := NewSemaphore(driver).Acquire(ctx, key, 1, time.Minute)
+	require.NoError(t, err)
+
+	r, err = limiter.Reserve(ctx, req)
+	require.NoError(t, err)
+	require.True(t, r.OK)
+
+	// reset resets both
+	require.NoError(t, driver.ResetKey(ctx, key))
+	err = lease.Renew(ctx, time.Minute)
+	require.ErrorIs(t, err, ErrLeaseNotFound)
+	state, err := driver.GetKeyState(ctx, key)
+	require.NoError(t, err)
+	require.Nil(t, state)
+}
+
+func TestSemaphore_DriverGORM(t *testing.T) {
+	d := NewGormDriver(db)
+	testSemaphore(t, d, "TestSemaphore_DriverGORM")
+	testSemaphoreKeepAlive(t, d, "TestSemaphoreKeepAlive_DriverGORM")
+	testSemaphoreWithRateLimit(t, d, "TestSemaphoreWithRateLimit_DriverGORM")
+}
+
+func TestSemaphoreCleanup_DriverGORM(t *testing.T) {
+	d := NewGormDriver(db)
+	s := NewSemaphore(d)
+	key := "TestSemaphoreCleanup_DriverGORM"
+	now := time.Now().Truncate(time.Microsecond)
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+
+	countRows := func() (semaphores int64, leases int64) {
+		require.NoError(t, db.Model(&KVSemaphore{}).Where("key = ?", key).Count(&semaphores).Error)
+		require.NoError(t, db.Model(&KVLease{}).Where("key = ?", key).Count(&leases).Error)
+		return semaphores, leases
+	}
+
+	// the lock is removed along with the last lease
+	lease1, err := s.Acquire(ctx, key, 2, time.Second)
+	require.NoError(t, err)
+	lease2, err := s.Acquire(ctx, key, 2, time.Second)
+	require.NoError(t, err)
+	require.NoError(t, lease1.Release(ctx))
+	semaphores, leases := countRows()
+	require.Equal(t, int64(1), semaphores)
+	require.Equal(t, int64(1), leases)
+	require.NoError(t, lease2.Release(ctx))
+	semaphores, leases = countRows()
+	require.Zero(t, semaphores)
+	require.Zero(t, leases)
+
+	// the expired leases are removed by CompactLeases
+	_, err = s.Acquire(ctx, key, 2, time.Second)
+	require.NoError(t, err)
+	deleted, err := d.CompactLeases(ctx, key)
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+	deleted, err = d.CompactLeases(WithNowFuncForTest(context.Background(), func() time.Time {
+		return now.Add(time.Second)
+	}), key)
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	semaphores, leases = countRows()
+	require.Zero(t, semaphores)
+	require.Zero(t, leases)
+}
+
+func TestSemaphore_DriverRedis(t *testing.T) {
+	d, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+	testSemaphore(t, d, "TestSemaphore_DriverRedis")
+	testSemaphoreKeepAlive(t, d, "TestSemaphoreKeepAlive_DriverRedis")
+	testSemaphoreWithRateLimit(t, d, "TestSemaphoreWithRateLimit_DriverRedis")
+}
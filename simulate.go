@@ -0,0 +1,32 @@
+package ratelimiter
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SimulateGCRA reserves the request at each offset from start against a bucket in memory, which is empty at first,
+// e.g. to try a rate and a burst against a traffic pattern before applying them. The offsets must not decrease.
+func SimulateGCRA(req *ReserveRequest, start time.Time, offsets []time.Duration) ([]*Reservation, error) {
+	if err := validateReserveRequest(req, AlgorithmGCRA); err != nil {
+		return nil, err
+	}
+
+	var timeBase time.Time
+	found := false
+	rs := make([]*Reservation, len(offsets))
+	for i, offset := range offsets {
+		if i > 0 && offset < offsets[i-1] {
+			return nil, errors.Wrapf(ErrInvalidParameters, "offset %v is before %v", offset, offsets[i-1])
+		}
+
+		now := start.Add(offset).UTC()
+		timeToAct, ok := reserveGCRA(req, now, timeBase, found)
+		if ok {
+			timeBase, found = timeToAct, true
+		}
+		rs[i] = newReservation(req, now, timeToAct, ok)
+	}
+	return rs, nil
+}
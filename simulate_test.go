@@ -0,0 +1,47 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimulateGCRA(t *testing.T) {
+	start := time.Now()
+	req := &ReserveRequest{
+		Key:              "TestSimulateGCRA",
+		DurationPerToken: time.Second,
+		Burst:            2,
+		Tokens:           1,
+		MaxFutureReserve: time.Second,
+	}
+
+	rs, err := SimulateGCRA(req, start, []time.Duration{0, 0, 0, 0, 1500 * time.Millisecond, 5 * time.Second})
+	require.NoError(t, err)
+
+	expected := []struct {
+		ok    bool
+		delay time.Duration
+	}{
+		{true, 0},
+		{true, 0},
+		{true, time.Second},
+		{false, 0},
+		{true, 500 * time.Millisecond},
+		{true, 0},
+	}
+	require.Len(t, rs, len(expected))
+	for i, e := range expected {
+		require.Equal(t, e.ok, rs[i].OK, i)
+		if e.ok {
+			require.Equal(t, e.delay, rs[i].DelayFrom(rs[i].Now), i)
+		}
+	}
+	require.Equal(t, time.Second, rs[3].RetryAfterFrom(rs[3].Now))
+
+	_, err = SimulateGCRA(req, start, []time.Duration{time.Second, 0})
+	require.ErrorIs(t, err, ErrInvalidParameters)
+	_, err = SimulateGCRA(&ReserveRequest{Key: "k", DurationPerToken: time.Second, Burst: 1, Tokens: 1, Algorithm: AlgorithmQuota}, start, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,69 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// slidingWindowCounterState is the state of a key of AlgorithmSlidingWindowCounter,
+// which is the counts of the current fixed window and the previous one.
+type slidingWindowCounterState struct {
+	Window int64 // index of the current window, which starts at Window * window duration
+	Prev   int
+	Curr   int
+}
+
+func (s slidingWindowCounterState) String() string {
+	return fmt.Sprintf("%d:%d:%d", s.Window, s.Prev, s.Curr)
+}
+
+func parseSlidingWindowCounterState(v string) (slidingWindowCounterState, error) {
+	var s slidingWindowCounterState
+	if _, err := fmt.Sscanf(v, "%d:%d:%d", &s.Window, &s.Prev, &s.Curr); err != nil {
+		return s, errors.Wrapf(err, "ratelimiter: failed to parse sliding window counter %q", v)
+	}
+	return s, nil
+}
+
+// reserveSlidingWindowCounter applies the sliding window counter to the state of a key.
+// The count of the rolling window is estimated by weighting the previous window by its overlap with the rolling window.
+// If ok is true, next should be stored as the new state.
+func reserveSlidingWindowCounter(req *ReserveRequest, now time.Time, state slidingWindowCounterState, found bool) (timeToAct time.Time, next slidingWindowCounterState, ok bool) {
+	window := int64(req.Burst) * req.DurationPerToken.Microseconds()
+	unixMicroNow := now.UnixMicro()
+	index := unixMicroNow / window
+
+	next = slidingWindowCounterState{Window: index}
+	if found {
+		switch state.Window {
+		case index:
+			next.Prev, next.Curr = state.Prev, state.Curr
+		case index - 1:
+			next.Prev = state.Curr
+		}
+	}
+
+	start := index * window
+	elapsed := unixMicroNow - start
+	burst, tokens, prev, curr := float64(req.Burst), float64(req.Tokens), float64(next.Prev), float64(next.Curr)
+
+	estimated := prev*float64(window-elapsed)/float64(window) + curr
+	if estimated+tokens <= burst {
+		next.Curr += req.Tokens
+		return now, next, true
+	}
+
+	// the earliest time the estimated count leaves room for the tokens
+	var unixMicroToAct int64
+	if curr+tokens <= burst {
+		// the weight of the previous window decreases enough in the current window
+		unixMicroToAct = start + int64(math.Ceil(float64(window)*(1-(burst-curr-tokens)/prev)))
+	} else {
+		// the current window becomes the previous window
+		unixMicroToAct = start + window + int64(math.Ceil(float64(window)*math.Max(0, 1-(burst-tokens)/curr)))
+	}
+	return time.UnixMicro(unixMicroToAct).UTC(), state, false
+}
@@ -0,0 +1,143 @@
+package ratelimiter
+
+import (
+	"context"
+	"math/rand/v2"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlidingWindowCounterState(t *testing.T) {
+	state := slidingWindowCounterState{Window: 123, Prev: 4, Curr: 5}
+	parsed, err := parseSlidingWindowCounterState(state.String())
+	require.NoError(t, err)
+	require.Equal(t, state, parsed)
+
+	_, err = parseSlidingWindowCounterState("1700000000000000")
+	require.ErrorContains(t, err, "failed to parse sliding window counter")
+}
+
+// TestSlidingWindowCounter_AdmissionError compares the admissions of the sliding window counter with the exact counting of the sliding window log.
+func TestSlidingWindowCounter_AdmissionError(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	burst := 100
+	durationPerToken := 10 * time.Millisecond
+	window := time.Duration(burst) * durationPerToken
+	start := time.UnixMicro(1700000000000000 / window.Microseconds() * window.Microseconds())
+
+	// random arrivals at about 3 times the limit for 200 windows
+	var arrivals []time.Time
+	for at := start; at.Before(start.Add(200 * window)); {
+		at = at.Add(time.Duration(rng.ExpFloat64() * float64(durationPerToken) / 3))
+		arrivals = append(arrivals, at)
+	}
+
+	req := &ReserveRequest{
+		Key:              "TestSlidingWindowCounter_AdmissionError",
+		DurationPerToken: durationPerToken,
+		Burst:            burst,
+		Tokens:           1,
+	}
+
+	var state slidingWindowCounterState
+	var counterAdmitted []time.Time
+	var logEntries []slidingWindowLogEntry
+	logAdmitted := 0
+	for i, now := range arrivals {
+		_, next, ok := reserveSlidingWindowCounter(req, now, state, i > 0)
+		if ok {
+			state = next
+			counterAdmitted = append(counterAdmitted, now)
+		}
+
+		for len(logEntries) > 0 && !logEntries[len(logEntries)-1].At.After(now.Add(-window)) {
+			logEntries = logEntries[:len(logEntries)-1]
+		}
+		if _, ok := reserveSlidingWindowLog(req, now, logEntries); ok {
+			logEntries = append([]slidingWindowLogEntry{{At: now, Tokens: 1}}, logEntries...)
+			logAdmitted++
+		}
+	}
+
+	// the total admissions are close to the exact counting
+	diff := float64(len(counterAdmitted)-logAdmitted) / float64(logAdmitted)
+	t.Logf("admitted: counter %d, log %d, diff %.4f", len(counterAdmitted), logAdmitted, diff)
+	require.InDelta(t, 0, diff, 0.02)
+
+	// the count of any rolling window only exceeds the limit slightly
+	maxCount := 0
+	for i, at := range counterAdmitted {
+		j := sort.Search(len(counterAdmitted), func(j int) bool {
+			return counterAdmitted[j].After(at.Add(-window))
+		})
+		maxCount = max(maxCount, i-j+1)
+	}
+	t.Logf("max count of rolling windows: %d", maxCount)
+	require.LessOrEqual(t, maxCount, burst*110/100)
+}
+
+func testSlidingWindowCounter(t *testing.T, limiter *RateLimiter, key string) {
+	// at most 4 tokens in any 4 seconds, approximately
+	durationPerToken := time.Second
+	burst := 4
+
+	window := (time.Duration(burst) * durationPerToken).Microseconds()
+	start := time.UnixMicro(time.Now().UnixMicro() / window * window)
+	testCases := []struct {
+		name              string
+		now               time.Time
+		tokens            int
+		expectedOK        bool
+		expectedTimeToAct time.Time
+	}{
+		{"three tokens", start, 3, true, start},
+		{"fourth token", start.Add(1 * time.Second), 1, true, start.Add(1 * time.Second)},
+		{"current window is full", start.Add(2 * time.Second), 1, false, start.Add(5 * time.Second)},
+		{"weighted previous window", start.Add(5 * time.Second), 1, true, start.Add(5 * time.Second)},
+		{"weight of previous window decreases", start.Add(5 * time.Second), 1, false, start.Add(6 * time.Second)},
+		{"retry", start.Add(6 * time.Second), 1, true, start.Add(6 * time.Second)},
+		{"previous window is out of date", start.Add(12 * time.Second), 4, true, start.Add(12 * time.Second)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+				return tc.now
+			})
+			r, err := limiter.Reserve(ctx, &ReserveRequest{
+				Key:              key,
+				DurationPerToken: durationPerToken,
+				Burst:            burst,
+				Tokens:           tc.tokens,
+				Algorithm:        AlgorithmSlidingWindowCounter,
+			})
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedOK, r.OK)
+			require.Equal(t, tc.expectedTimeToAct.UTC(), r.TimeToAct.UTC())
+		})
+	}
+
+	_, err := limiter.Reserve(context.Background(), &ReserveRequest{
+		Key:              key,
+		DurationPerToken: durationPerToken,
+		Burst:            burst,
+		Tokens:           1,
+		MaxFutureReserve: time.Second,
+		Algorithm:        AlgorithmSlidingWindowCounter,
+	})
+	require.ErrorIs(t, err, ErrInvalidParameters)
+}
+
+func TestSlidingWindowCounter_DriverGORM(t *testing.T) {
+	testSlidingWindowCounter(t, New(NewGormDriver(db)), "TestSlidingWindowCounter_DriverGORM")
+}
+
+func TestSlidingWindowCounter_DriverRedis(t *testing.T) {
+	d, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+	testSlidingWindowCounter(t, New(d), "TestSlidingWindowCounter_DriverRedis")
+}
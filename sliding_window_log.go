@@ -0,0 +1,40 @@
+package ratelimiter
+
+import "time"
+
+type slidingWindowLogEntry struct {
+	At     time.Time
+	Tokens int
+}
+
+// reserveSlidingWindowLog applies the sliding window log to the entries of a key,
+// which must be sorted by time in descending order and exclude the entries out of the window.
+// If ok is true, an entry of the requested tokens should be logged at timeToAct.
+func reserveSlidingWindowLog(req *ReserveRequest, now time.Time, entries []slidingWindowLogEntry) (timeToAct time.Time, ok bool) {
+	window := time.Duration(req.Burst) * req.DurationPerToken
+
+	// keep the log in order if there are reservations in the future
+	timeToAct = now
+	if len(entries) > 0 && entries[0].At.After(timeToAct) {
+		timeToAct = entries[0].At
+	}
+
+	// the k-th most recent token must be out of the window to leave room for the requested tokens
+	k := req.Burst - req.Tokens + 1
+	count := 0
+	for _, entry := range entries {
+		count += entry.Tokens
+		if count >= k {
+			if t := entry.At.Add(window); t.After(timeToAct) {
+				timeToAct = t
+			}
+			break
+		}
+	}
+	timeToAct = timeToAct.UTC()
+
+	if timeToAct.After(now.Add(req.MaxFutureReserve)) {
+		return timeToAct, false
+	}
+	return timeToAct, true
+}
@@ -0,0 +1,125 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReserveSlidingWindowLog(t *testing.T) {
+	now := time.Now().Truncate(time.Microsecond).UTC()
+	req := &ReserveRequest{
+		Key:              "TestReserveSlidingWindowLog",
+		DurationPerToken: time.Second,
+		Burst:            3,
+		Tokens:           2,
+	}
+
+	// empty log
+	timeToAct, ok := reserveSlidingWindowLog(req, now, nil)
+	require.True(t, ok)
+	require.Equal(t, now, timeToAct)
+
+	// the second most recent token must leave the window (3s)
+	entries := []slidingWindowLogEntry{
+		{At: now.Add(-500 * time.Millisecond), Tokens: 1},
+		{At: now.Add(-2 * time.Second), Tokens: 1},
+	}
+	timeToAct, ok = reserveSlidingWindowLog(req, now, entries)
+	require.False(t, ok)
+	require.Equal(t, now.Add(time.Second), timeToAct)
+
+	// grouped tokens
+	entries = []slidingWindowLogEntry{
+		{At: now.Add(-500 * time.Millisecond), Tokens: 2},
+	}
+	timeToAct, ok = reserveSlidingWindowLog(req, now, entries)
+	require.False(t, ok)
+	require.Equal(t, now.Add(2500*time.Millisecond), timeToAct)
+
+	// reserve in the future
+	req.MaxFutureReserve = 3 * time.Second
+	timeToAct, ok = reserveSlidingWindowLog(req, now, entries)
+	require.True(t, ok)
+	require.Equal(t, now.Add(2500*time.Millisecond), timeToAct)
+
+	// keep the log in order
+	entries = []slidingWindowLogEntry{
+		{At: now.Add(time.Second), Tokens: 1},
+	}
+	timeToAct, ok = reserveSlidingWindowLog(req, now, entries)
+	require.True(t, ok)
+	require.Equal(t, now.Add(time.Second), timeToAct)
+}
+
+func testSlidingWindowLog(t *testing.T, limiter *RateLimiter, key string) {
+	// at most 3 tokens in any 3 seconds
+	durationPerToken := time.Second
+	burst := 3
+
+	now := time.Now().Truncate(time.Microsecond)
+	testCases := []struct {
+		name              string
+		now               time.Time
+		tokens            int
+		maxFutureReserve  time.Duration
+		expectedOK        bool
+		expectedTimeToAct time.Time
+	}{
+		{"first token", now, 1, 0, true, now},
+		{"second token", now.Add(1 * time.Second), 1, 0, true, now.Add(1 * time.Second)},
+		{"third token", now.Add(1500 * time.Millisecond), 1, 0, true, now.Add(1500 * time.Millisecond)},
+		// GCRA would admit it since a token is refilled at now+1s, but the window still has 3 tokens
+		{"window is full", now.Add(2 * time.Second), 1, 0, false, now.Add(3 * time.Second)},
+		{"first token leaves the window", now.Add(3 * time.Second), 1, 0, true, now.Add(3 * time.Second)},
+		{"two tokens", now.Add(3 * time.Second), 2, 0, false, now.Add(4500 * time.Millisecond)},
+		{"two tokens in the future", now.Add(3 * time.Second), 2, 2 * time.Second, true, now.Add(4500 * time.Millisecond)},
+		{"after future reservation", now.Add(3 * time.Second), 1, 5 * time.Second, true, now.Add(6 * time.Second)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+				return tc.now
+			})
+			req := &ReserveRequest{
+				Key:              key,
+				DurationPerToken: durationPerToken,
+				Burst:            burst,
+				Tokens:           tc.tokens,
+				MaxFutureReserve: tc.maxFutureReserve,
+				Algorithm:        AlgorithmSlidingWindowLog,
+			}
+			r, err := limiter.Reserve(ctx, req)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedOK, r.OK)
+			require.Equal(t, tc.expectedTimeToAct.UTC(), r.TimeToAct.UTC())
+			if !r.OK {
+				require.Equal(t, tc.expectedTimeToAct.Sub(tc.now)-tc.maxFutureReserve, r.RetryAfterFrom(tc.now))
+			}
+		})
+	}
+}
+
+func TestSlidingWindowLog_DriverGORM(t *testing.T) {
+	testSlidingWindowLog(t, New(NewGormDriver(db)), "TestSlidingWindowLog_DriverGORM")
+}
+
+func TestSlidingWindowLog_DriverRedis(t *testing.T) {
+	d, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+	testSlidingWindowLog(t, New(d), "TestSlidingWindowLog_DriverRedis")
+}
+
+func TestSlidingWindowLog_UnsupportedDriver(t *testing.T) {
+	_, err := New(newSQLDriverForTest(t)).Reserve(context.Background(), &ReserveRequest{
+		Key:              "TestSlidingWindowLog_UnsupportedDriver",
+		DurationPerToken: time.Second,
+		Burst:            1,
+		Tokens:           1,
+		Algorithm:        AlgorithmSlidingWindowLog,
+	})
+	require.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+}
@@ -0,0 +1,72 @@
+package ratelimiter
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// KeyState is the stored state of a key.
+type KeyState struct {
+	Key string `json:"key"`
+	// Value is the stored value of the key, e.g. the timeBase in unix microseconds of AlgorithmGCRA,
+	// it is empty if the state is not a single value, e.g. the log of AlgorithmSlidingWindowLog.
+	Value string `json:"value"`
+}
+
+// StateDriver is implemented by the drivers whose keys can be listed, inspected and reset, e.g. for the admin tools.
+type StateDriver interface {
+	// ScanKeys returns a batch of about limit keys with the prefix from the cursor, which is empty for the first batch,
+	// and the cursor of the next batch, which is empty after the last batch.
+	ScanKeys(ctx context.Context, prefix string, cursor string, limit int) (keys []string, next string, err error)
+	// GetKeyState returns the state of the key, or nil if the key does not exist.
+	GetKeyState(ctx context.Context, key string) (*KeyState, error)
+	// ResetKey deletes the state of the key, so the key has its full burst again.
+	ResetKey(ctx context.Context, key string) error
+	// ResetKeyIf deletes the state of the key only if its value is still the provided one and reports whether it is deleted,
+	// e.g. for a garbage collection that must not delete a key reserved after it is read.
+	ResetKeyIf(ctx context.Context, key string, value string) (bool, error)
+}
+
+// BucketStatus is the status of an AlgorithmGCRA bucket at Now.
+type BucketStatus struct {
+	Key   string `json:"key"`
+	Found bool   `json:"found"`
+	// TimeBase is the time the tokens are reserved until, it is zero if the key is not found.
+	TimeBase time.Time `json:"timeBase"`
+	// Available is the number of tokens that can be reserved without waiting.
+	Available int `json:"available"`
+	// FullIn is the time until the bucket is full again.
+	FullIn time.Duration `json:"fullIn"`
+	Now    time.Time     `json:"now"`
+}
+
+// NewBucketStatus returns the status of the AlgorithmGCRA bucket of the state at now, the state is nil if the key is not found.
+func NewBucketStatus(key string, state *KeyState, durationPerToken time.Duration, burst int, now time.Time) (*BucketStatus, error) {
+	if durationPerToken <= 0 || burst <= 0 {
+		return nil, errors.Wrapf(ErrInvalidParameters, "durationPerToken %v and burst %d must be positive", durationPerToken, burst)
+	}
+
+	s := &BucketStatus{
+		Key:       key,
+		Available: burst,
+		Now:       now,
+	}
+	if state == nil {
+		return s, nil
+	}
+
+	unixMicroBase, err := strconv.ParseInt(state.Value, 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidParameters, "key %q is not a GCRA bucket", key)
+	}
+	s.Found = true
+	s.TimeBase = time.UnixMicro(unixMicroBase).UTC()
+
+	available := int(now.Sub(s.TimeBase) / durationPerToken)
+	s.Available = max(0, min(burst, available))
+	s.FullIn = max(0, s.TimeBase.Add(time.Duration(burst)*durationPerToken).Sub(now))
+	return s, nil
+}
@@ -0,0 +1,122 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testStateDriver(t *testing.T, d interface {
+	Driver
+	StateDriver
+}, prefix string) {
+	now := time.Now().Truncate(time.Microsecond)
+	ctx := WithNowFuncForTest(context.Background(), func() time.Time {
+		return now
+	})
+
+	var expectedKeys []string
+	for i := range 5 {
+		key := fmt.Sprintf("%s:user%d", prefix, i)
+		expectedKeys = append(expectedKeys, key)
+		_, err := d.Reserve(ctx, &ReserveRequest{Key: key, DurationPerToken: time.Second, Burst: 3, Tokens: 2})
+		require.NoError(t, err)
+	}
+	// the wildcards of the prefix are literal
+	_, err := d.Reserve(ctx, &ReserveRequest{Key: prefix + "x_user", DurationPerToken: time.Second, Burst: 3, Tokens: 1})
+	require.NoError(t, err)
+
+	var keys []string
+	cursor := ""
+	for {
+		batch, next, err := d.ScanKeys(ctx, prefix+":", cursor, 2)
+		require.NoError(t, err)
+		keys = append(keys, batch...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	sort.Strings(keys)
+	require.Equal(t, expectedKeys, keys)
+
+	state, err := d.GetKeyState(ctx, expectedKeys[0])
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	status, err := NewBucketStatus(state.Key, state, time.Second, 3, now)
+	require.NoError(t, err)
+	require.True(t, status.Found)
+	require.Equal(t, 1, status.Available)
+	require.Equal(t, 2*time.Second, status.FullIn)
+	require.True(t, now.Add(-time.Second).Equal(status.TimeBase))
+
+	require.NoError(t, d.ResetKey(ctx, expectedKeys[0]))
+	require.NoError(t, d.ResetKey(ctx, expectedKeys[0]))
+	state, err = d.GetKeyState(ctx, expectedKeys[0])
+	require.NoError(t, err)
+	require.Nil(t, state)
+
+	r, err := d.Reserve(ctx, &ReserveRequest{Key: expectedKeys[0], DurationPerToken: time.Second, Burst: 3, Tokens: 3})
+	require.NoError(t, err)
+	require.True(t, r.OK)
+
+	// the key is kept if it is reserved after it is read
+	state, err = d.GetKeyState(ctx, expectedKeys[1])
+	require.NoError(t, err)
+	_, err = d.Reserve(ctx, &ReserveRequest{Key: expectedKeys[1], DurationPerToken: time.Second, Burst: 3, Tokens: 1})
+	require.NoError(t, err)
+	deleted, err := d.ResetKeyIf(ctx, expectedKeys[1], state.Value)
+	require.NoError(t, err)
+	require.False(t, deleted)
+	state, err = d.GetKeyState(ctx, expectedKeys[1])
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	deleted, err = d.ResetKeyIf(ctx, expectedKeys[1], state.Value)
+	require.NoError(t, err)
+	require.True(t, deleted)
+	deleted, err = d.ResetKeyIf(ctx, expectedKeys[1], state.Value)
+	require.NoError(t, err)
+	require.False(t, deleted)
+
+	_, _, err = d.ScanKeys(ctx, prefix, "", 0)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+}
+
+func TestStateDriver_DriverRedis(t *testing.T) {
+	d, err := InitRedisDriver(context.Background(), redisCli)
+	require.NoError(t, err)
+	testStateDriver(t, d, "TestStateDriver_DriverRedis*")
+}
+
+func TestStateDriver_DriverGORM(t *testing.T) {
+	testStateDriver(t, NewGormDriver(db), "TestStateDriver_DriverGORM%")
+}
+
+func TestNewBucketStatus(t *testing.T) {
+	now := time.Now().Truncate(time.Microsecond)
+
+	status, err := NewBucketStatus("k", nil, time.Second, 10, now)
+	require.NoError(t, err)
+	require.Equal(t, &BucketStatus{Key: "k", Available: 10, Now: now}, status)
+
+	// reserved into the future
+	status, err = NewBucketStatus("k", &KeyState{Key: "k", Value: fmt.Sprint(now.Add(5 * time.Second).UnixMicro())}, time.Second, 10, now)
+	require.NoError(t, err)
+	require.Equal(t, 0, status.Available)
+	require.Equal(t, 15*time.Second, status.FullIn)
+
+	// full for long
+	status, err = NewBucketStatus("k", &KeyState{Key: "k", Value: fmt.Sprint(now.Add(-time.Hour).UnixMicro())}, time.Second, 10, now)
+	require.NoError(t, err)
+	require.Equal(t, 10, status.Available)
+	require.Equal(t, time.Duration(0), status.FullIn)
+
+	_, err = NewBucketStatus("k", &KeyState{Key: "k", Value: "1:2"}, time.Second, 10, now)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+	_, err = NewBucketStatus("k", nil, 0, 10, now)
+	require.ErrorIs(t, err, ErrInvalidParameters)
+}